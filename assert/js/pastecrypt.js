@@ -0,0 +1,79 @@
+/*
+ * The end-to-end encrypted pastes, the format of pkg/pastecrypt: "fm1.<iv>.<ciphertext>" with the base64url
+ * encoded parts, the ciphertext is the AES-256-GCM encryption of the JSON payload {title, files}. The key is
+ * kept in the fragment of the paste URL, which the browsers do not send to the server.
+ */
+var pastecrypt = (function () {
+    'use strict';
+
+    var version = 'fm1';
+
+    function encode(bytes) {
+        var binary = '';
+        bytes.forEach(function (b) {
+            binary += String.fromCharCode(b);
+        });
+        return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    function decode(s) {
+        s = s.replace(/-/g, '+').replace(/_/g, '/');
+        while (s.length % 4) {
+            s += '=';
+        }
+        var binary = atob(s);
+        var bytes = new Uint8Array(binary.length);
+        for (var i = 0; i < binary.length; i++) {
+            bytes[i] = binary.charCodeAt(i);
+        }
+        return bytes;
+    }
+
+    function importKey(raw, usage) {
+        if (!window.crypto || !window.crypto.subtle) {
+            return Promise.reject(new Error('the encryption needs a secure context, open the page with https'));
+        }
+        return crypto.subtle.importKey('raw', raw, 'AES-GCM', false, [usage]);
+    }
+
+    // seal encrypts the payload with a new key, it resolves to {sealed, key} with the key encoded for the URL
+    function seal(payload) {
+        var raw = new Uint8Array(32);
+        var iv = new Uint8Array(12);
+        crypto.getRandomValues(raw);
+        crypto.getRandomValues(iv);
+        var plaintext = new TextEncoder().encode(JSON.stringify(payload));
+        return importKey(raw, 'encrypt').then(function (key) {
+            return crypto.subtle.encrypt({name: 'AES-GCM', iv: iv}, key, plaintext);
+        }).then(function (ciphertext) {
+            return {
+                sealed: [version, encode(iv), encode(new Uint8Array(ciphertext))].join('.'),
+                key: encode(raw)
+            };
+        });
+    }
+
+    // open decrypts the sealed paste with the key of the URL fragment, it resolves to the payload
+    function open(sealed, fragment) {
+        var parts = sealed.trim().split('.');
+        if (parts.length !== 3 || parts[0] !== version) {
+            return Promise.reject(new Error('not a ' + version + ' sealed paste'));
+        }
+        var raw = decode(fragment.replace(/^#/, ''));
+        if (raw.length !== 32) {
+            return Promise.reject(new Error('the key of the URL is missing or invalid'));
+        }
+        return importKey(raw, 'decrypt').then(function (key) {
+            return crypto.subtle.decrypt({name: 'AES-GCM', iv: decode(parts[1])}, key, decode(parts[2]));
+        }).then(function (plaintext) {
+            return JSON.parse(new TextDecoder().decode(plaintext));
+        }, function (err) {
+            if (err.name === 'OperationError') {
+                throw new Error('failed to decrypt the paste, the key is wrong');
+            }
+            throw err;
+        });
+    }
+
+    return {seal: seal, open: open};
+})();
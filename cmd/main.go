@@ -5,6 +5,7 @@ import (
 	"github.com/graydovee/fileManager/pkg/config"
 	"github.com/spf13/cobra"
 	"os"
+	"strconv"
 )
 
 func main() {
@@ -32,6 +33,31 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+// sizeValue is a flag of a byte size like 512MiB
+type sizeValue int64
+
+func newSizeValue(p *int64, value int64) *sizeValue {
+	*p = value
+	return (*sizeValue)(p)
+}
+
+func (v *sizeValue) Set(s string) error {
+	size, err := config.ParseSize(s)
+	if err != nil {
+		return err
+	}
+	*v = sizeValue(size)
+	return nil
+}
+
+func (v *sizeValue) Type() string {
+	return "size"
+}
+
+func (v *sizeValue) String() string {
+	return strconv.FormatInt(int64(*v), 10)
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -42,14 +68,36 @@ func Execute() {
 }
 
 func init() {
-	f := rootCmd.Flags()
+	f := rootCmd.PersistentFlags()
 	f.StringVarP(&cfg.Address, "address", "a", config.GetDefault().Address, "server listen address")
 	f.BoolVarP(&cfg.EnableTls, "tls", "t", config.GetDefault().EnableTls, "enable https")
 	f.StringVar(&cfg.InternalHost, "internal-host", config.GetDefault().InternalHost, "internal host")
+	f.StringSliceVar(&cfg.TrustedProxies, "trusted-proxies", config.GetDefault().TrustedProxies, "IPs or CIDRs of the reverse proxies whose X-Forwarded-For is trusted")
 
 	f.StringVar(&cfg.Resource.StaticDir, "resource-static", config.GetDefault().Resource.StaticDir, "static file directory")
 	f.StringVar(&cfg.Resource.TemplateDir, "template-dir", config.GetDefault().Resource.TemplateDir, "template file directory")
 
+	f.StringVar(&cfg.Upload.Naming, "upload-naming", config.GetDefault().Upload.Naming, "naming strategy of uploads: timestamp, original, uuid, shortid, hash or a template like {yyyy}/{mm}/{uuid}-{name}")
+	f.IntVar(&cfg.Upload.Workers, "upload-workers", config.GetDefault().Upload.Workers, "how many files of a multi-file upload are uploaded concurrently")
+	f.Int64Var(&cfg.Upload.MaxSize, "upload-max-size", config.GetDefault().Upload.MaxSize, "max size in bytes of an upload request, 0 means unlimited")
+
+	f.Float64Var(&cfg.Limit.RequestRate, "limit-request-rate", config.GetDefault().Limit.RequestRate, "requests per second of every client, 0 means unlimited")
+	f.IntVar(&cfg.Limit.RequestBurst, "limit-request-burst", config.GetDefault().Limit.RequestBurst, "how many requests a client may send at once, 0 means the request rate")
+	f.Int64Var(&cfg.Limit.Bandwidth, "limit-bandwidth", config.GetDefault().Limit.Bandwidth, "bytes per second of all uploads and downloads, 0 means unlimited")
+	f.Int64Var(&cfg.Limit.ConnectionBandwidth, "limit-connection-bandwidth", config.GetDefault().Limit.ConnectionBandwidth, "bytes per second of every upload or download, 0 means unlimited")
+	f.Int64Var(&cfg.Limit.ClientBandwidth, "limit-client-bandwidth", config.GetDefault().Limit.ClientBandwidth, "bytes per second of the uploads and downloads of every client, 0 means unlimited")
+
+	f.StringSliceVar(&cfg.Webhook.URLs, "webhook-urls", config.GetDefault().Webhook.URLs, "urls the file and paste events are posted to")
+	f.StringVar(&cfg.Webhook.Secret, "webhook-secret", config.GetDefault().Webhook.Secret, "secret of the HMAC-SHA256 signature of the webhook payloads")
+	f.StringSliceVar(&cfg.Webhook.Events, "webhook-events", config.GetDefault().Webhook.Events, "event types which are posted: file.upload, file.delete, paste.create, share.access, all if empty")
+	f.StringSliceVar(&cfg.Webhook.Prefixes, "webhook-prefixes", config.GetDefault().Webhook.Prefixes, "paths whose events are posted, all if empty")
+	f.StringVar(&cfg.Webhook.QueueDir, "webhook-queue-dir", config.GetDefault().Webhook.QueueDir, "directory of the pending webhook deliveries")
+	f.IntVar(&cfg.Webhook.MaxAttempts, "webhook-max-attempts", config.GetDefault().Webhook.MaxAttempts, "how often a webhook delivery is tried before it is dropped")
+
+	f.StringVar(&cfg.Code.LanguagesFile, "code-languages-file", config.GetDefault().Code.LanguagesFile, "JSON file of languages added to the built-in languages of the code server")
+	f.IntVar(&cfg.Code.IDLength, "code-id-length", config.GetDefault().Code.IDLength, "length of the random base62 ids of the pastes, 4 to 32")
+	f.BoolVar(&cfg.Code.Dedup, "code-dedup", config.GetDefault().Code.Dedup, "reuse the id of an identical paste")
+
 	f.StringVar(&cfg.Store.Type, "store-type", config.GetDefault().Store.Type, "store type")
 
 	f.StringVar(&cfg.Store.Local.UploadDir, "upload-dir", config.GetDefault().Store.Local.UploadDir, "file upload directory")
@@ -60,4 +108,29 @@ func init() {
 	f.StringVar(&cfg.Store.S3.Bucket, "s3-bucket", config.GetDefault().Store.S3.Bucket, "s3 bucket")
 	f.BoolVar(&cfg.Store.S3.DisablePathStyle, "s3-disable-path-style", config.GetDefault().Store.S3.DisablePathStyle, "s3 disable path style")
 	f.BoolVar(&cfg.Store.S3.DisableSSL, "s3-disable-ssl", config.GetDefault().Store.S3.DisableSSL, "s3 disable ssl")
+
+	f.StringVar(&cfg.Store.Encryption.Key, "encryption-key", config.GetDefault().Store.Encryption.Key, "base64 or hex encoded master key, enables at-rest encryption")
+	f.StringVar(&cfg.Store.Encryption.KeyFile, "encryption-key-file", config.GetDefault().Store.Encryption.KeyFile, "master key file, enables at-rest encryption")
+	f.StringSliceVar(&cfg.Store.Encryption.PreviousKeyFiles, "encryption-previous-key-files", config.GetDefault().Store.Encryption.PreviousKeyFiles, "old master key files, only used for decryption")
+
+	f.StringVar(&cfg.Store.Cache.Dir, "cache-dir", config.GetDefault().Store.Cache.Dir, "local cache directory of downloaded files, enables the cache")
+	f.Var(newSizeValue(&cfg.Store.Cache.MaxSize, config.GetDefault().Store.Cache.MaxSize), "cache-max-size", "max size of the local cache, e.g. 512MiB")
+
+	f.BoolVar(&cfg.Store.Dedup, "dedup", config.GetDefault().Store.Dedup, "store identical files only once")
+
+	f.BoolVar(&cfg.Store.Versioning.Enabled, "versioning", config.GetDefault().Store.Versioning.Enabled, "keep the history of overwritten and deleted files")
+	f.IntVar(&cfg.Store.Versioning.MaxVersions, "versioning-max-versions", config.GetDefault().Store.Versioning.MaxVersions, "max count of old versions per file, 0 means unlimited")
+	f.DurationVar(&cfg.Store.Versioning.MaxAge, "versioning-max-age", config.GetDefault().Store.Versioning.MaxAge, "max age of old versions, 0 means unlimited")
+
+	f.BoolVar(&cfg.Store.Trash.Enabled, "trash", config.GetDefault().Store.Trash.Enabled, "move deleted files to the trash")
+	f.DurationVar(&cfg.Store.Trash.Retention, "trash-retention", config.GetDefault().Store.Trash.Retention, "how long deleted files are kept in the trash, 0 means until the trash is emptied")
+
+	f.StringSliceVar(&cfg.Store.Retention.Rules, "retention-rules", config.GetDefault().Store.Retention.Rules, "delete the files matching a pattern after a ttl, e.g. tmp/**=7d")
+	f.DurationVar(&cfg.Store.Retention.Interval, "retention-interval", config.GetDefault().Store.Retention.Interval, "how often the expired files are deleted, 0 disables the deletion")
+
+	f.Int64Var(&cfg.Store.Quota.MaxBytes, "quota-max-bytes", config.GetDefault().Store.Quota.MaxBytes, "max total size in bytes of the stored files, 0 means unlimited")
+	f.Int64Var(&cfg.Store.Quota.MaxFiles, "quota-max-files", config.GetDefault().Store.Quota.MaxFiles, "max count of stored files, 0 means unlimited")
+	f.Int64Var(&cfg.Store.Quota.MaxFileSize, "quota-max-file-size", config.GetDefault().Store.Quota.MaxFileSize, "max size in bytes of a single file, 0 means unlimited")
+	f.StringSliceVar(&cfg.Store.Quota.Rules, "quota-rules", config.GetDefault().Store.Quota.Rules, "quotas of tokens and path prefixes, e.g. token:<token>=10G/1000, token:*=1G or prefix:tmp=5G; the tokens are not authenticated, token quotas need an authenticating proxy")
+	f.StringVar(&cfg.Store.Quota.AdminToken, "quota-admin-token", config.GetDefault().Store.Quota.AdminToken, "token authorizing POST /quota/rebuild, the rebuild is disabled if empty")
 }
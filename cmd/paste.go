@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/graydovee/fileManager/pkg/pastecrypt"
+	"github.com/spf13/cobra"
+)
+
+// pasteFlags are the options of the paste command
+var pasteFlags struct {
+	server    string
+	title     string
+	language  string
+	expiresIn string
+	parent    string
+	fork      bool
+	private   bool
+	token     string
+	password  string
+	encrypt   bool
+	decrypt   string
+}
+
+// pasteFile is a file of the JSON body of POST /code
+type pasteFile struct {
+	Name     string `json:"name,omitempty"`
+	Language string `json:"language,omitempty"`
+	Content  string `json:"content"`
+}
+
+// pasteBody is the JSON body of POST /code
+type pasteBody struct {
+	Title     string      `json:"title,omitempty"`
+	ExpiresIn string      `json:"expiresIn,omitempty"`
+	Parent    string      `json:"parent,omitempty"`
+	Fork      bool        `json:"fork,omitempty"`
+	Private   bool        `json:"private,omitempty"`
+	Password  string      `json:"password,omitempty"`
+	Encrypted bool        `json:"encrypted,omitempty"`
+	Files     []pasteFile `json:"files"`
+}
+
+// pasteCmd creates a paste of the files, or of stdin, on a running server and prints its URL
+var pasteCmd = &cobra.Command{
+	Use:   "paste [files...]",
+	Short: "paste files or stdin to the code server",
+	Long: `Create a paste of the files on the code server and print its URL, several files make a multi-file paste.
+Stdin is pasted if no file is given or the file is "-". The language of every file is detected by the server
+unless --lang is set. A paste with --password is encrypted on the server and asks for the password when it is
+shown. With --encrypt the files are encrypted before they are sent, the server stores the sealed paste it
+cannot read and the printed URL carries the key after #, --decrypt prints the files of such a URL.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if pasteFlags.decrypt != "" {
+			return decryptPaste(pasteFlags.decrypt)
+		}
+		if len(args) == 0 {
+			args = []string{"-"}
+		}
+		body := pasteBody{
+			Title:     pasteFlags.title,
+			ExpiresIn: pasteFlags.expiresIn,
+			Parent:    pasteFlags.parent,
+			Fork:      pasteFlags.fork,
+			Private:   pasteFlags.private,
+			Password:  pasteFlags.password,
+		}
+		for _, arg := range args {
+			file := pasteFile{Language: pasteFlags.language}
+			var content []byte
+			var err error
+			if arg == "-" {
+				content, err = io.ReadAll(os.Stdin)
+			} else {
+				file.Name = filepath.Base(arg)
+				content, err = os.ReadFile(arg)
+			}
+			if err != nil {
+				return err
+			}
+			file.Content = string(content)
+			body.Files = append(body.Files, file)
+		}
+		// the title and the files are sealed, the key never leaves this process
+		var key []byte
+		if pasteFlags.encrypt {
+			var err error
+			if key, err = pastecrypt.NewKey(); err != nil {
+				return err
+			}
+			payload := &pastecrypt.Payload{Title: body.Title}
+			for _, file := range body.Files {
+				payload.Files = append(payload.Files, pastecrypt.File(file))
+			}
+			sealed, err := pastecrypt.Seal(key, payload)
+			if err != nil {
+				return err
+			}
+			body.Title, body.Encrypted = "", true
+			body.Files = []pasteFile{{Content: sealed}}
+		}
+
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(http.MethodPost, pasteServer()+"/code", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if pasteFlags.token != "" {
+			req.Header.Set("X-Auth-Token", pasteFlags.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		answer, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusCreated {
+			return errors.New(strings.TrimSpace(fmt.Sprintf("%s: %s", resp.Status, answer)))
+		}
+		if key != nil {
+			fmt.Printf("%s#%s\n", strings.TrimSpace(string(answer)), pastecrypt.EncodeKey(key))
+			return nil
+		}
+		fmt.Print(string(answer))
+		return nil
+	},
+}
+
+// decryptPaste prints the files of an encrypted paste, the key is the fragment of the URL
+func decryptPaste(pasteURL string) error {
+	u, err := url.Parse(pasteURL)
+	if err != nil {
+		return err
+	}
+	key, err := pastecrypt.DecodeKey(u.Fragment)
+	if err != nil {
+		return err
+	}
+	u.Fragment, u.RawQuery = "", ""
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/raw") + "/raw"
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if pasteFlags.password != "" {
+		req.Header.Set("X-Paste-Password", pasteFlags.password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	sealed, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(strings.TrimSpace(fmt.Sprintf("%s: %s", resp.Status, sealed)))
+	}
+	payload, err := pastecrypt.Open(key, string(sealed))
+	if err != nil {
+		return err
+	}
+	for i, file := range payload.Files {
+		if len(payload.Files) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("==> %s <==\n", file.Name)
+		}
+		fmt.Print(file.Content)
+	}
+	return nil
+}
+
+// pasteServer is the server of --server, by default the internal host on the port of the listen address
+func pasteServer() string {
+	if pasteFlags.server != "" {
+		return strings.TrimSuffix(pasteFlags.server, "/")
+	}
+	schema := "http"
+	if cfg.EnableTls {
+		schema = "https"
+	}
+	host := cfg.InternalHost
+	if host == "" {
+		host = "localhost"
+	}
+	if _, port, err := net.SplitHostPort(cfg.Address); err == nil {
+		host = net.JoinHostPort(host, port)
+	}
+	return fmt.Sprintf("%s://%s", schema, host)
+}
+
+func init() {
+	f := pasteCmd.Flags()
+	f.StringVar(&pasteFlags.server, "server", "", "url of the server, by default the internal host on the port of --address")
+	f.StringVar(&pasteFlags.title, "title", "", "title of the paste")
+	f.StringVar(&pasteFlags.language, "lang", "", "language of the files, detected if empty")
+	f.StringVar(&pasteFlags.expiresIn, "expires-in", "", "lifetime of the paste, e.g. 1h or 7d")
+	f.StringVar(&pasteFlags.parent, "parent", "", "id of the paste the new paste is a revision of")
+	f.BoolVar(&pasteFlags.fork, "fork", false, "fork the parent instead of revising it")
+	f.BoolVar(&pasteFlags.private, "private", false, "hide the paste from the list and the search")
+	f.StringVar(&pasteFlags.token, "token", os.Getenv("FILEMANAGER_TOKEN"), "auth token of the author, FILEMANAGER_TOKEN by default")
+	f.StringVar(&pasteFlags.password, "password", "", "password of the paste, it is encrypted on the server")
+	f.BoolVar(&pasteFlags.encrypt, "encrypt", false, "encrypt the files end-to-end, the key is printed in the fragment of the url")
+	f.StringVar(&pasteFlags.decrypt, "decrypt", "", "print the files of the encrypted paste of the url with its #key")
+	rootCmd.AddCommand(pasteCmd)
+}
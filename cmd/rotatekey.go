@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/spf13/cobra"
+)
+
+var newKeyFile string
+
+// rotateKeyCmd re-wraps the data keys of all encrypted files with a new master key
+var rotateKeyCmd = &cobra.Command{
+	Use:   "rotate-key",
+	Short: "re-wrap all encrypted files with a new master key",
+	Long: `Re-wrap the per-file data keys of all encrypted files with the master key in --new-key-file.
+The files are decrypted with the configured master key and previous keys, the content itself is not re-encrypted.
+After rotation, configure the new key as master key and optionally keep the old one in --encryption-previous-key-files.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !cfg.Store.Encryption.Enabled() {
+			return errors.New("encryption is not enabled, configure the current master key first")
+		}
+		newKey, err := store.LoadMasterKey(newKeyFile)
+		if err != nil {
+			return err
+		}
+
+		st, err := store.New(&cfg.Store)
+		if err != nil {
+			return err
+		}
+		encryptStore, ok := store.As[*store.EncryptStore](st)
+		if !ok {
+			return errors.New("store is not encrypted")
+		}
+
+		rotated, err := encryptStore.Rotate(context.Background(), "", newKey)
+		fmt.Printf("%d files rotated\n", rotated)
+		return err
+	},
+}
+
+func init() {
+	rotateKeyCmd.Flags().StringVar(&newKeyFile, "new-key-file", "", "file containing the new master key")
+	_ = rotateKeyCmd.MarkFlagRequired("new-key-file")
+	rootCmd.AddCommand(rotateKeyCmd)
+}
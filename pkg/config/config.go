@@ -1,11 +1,16 @@
 package config
 
 import (
+	"fmt"
 	"github.com/joho/godotenv"
 	"github.com/spf13/pflag"
+	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type Config struct {
@@ -15,15 +20,83 @@ type Config struct {
 
 	InternalHost string
 
+	// TrustedProxies are the IPs or CIDRs of the reverse proxies whose X-Forwarded-For is trusted, without
+	// them the client IP is the remote address
+	TrustedProxies []string
+
 	Resource ResourceConfig
 
+	Upload UploadConfig
+
+	Limit LimitConfig
+
+	Webhook WebhookConfig
+
+	Code CodeConfig
+
 	Store StoreConfig
 }
 
+type UploadConfig struct {
+	// Naming is the name of a naming strategy or a path template like {yyyy}/{mm}/{uuid}-{name}
+	Naming string
+	// Workers is how many files of a multi-file upload are uploaded concurrently
+	Workers int
+	// MaxSize is the max size in bytes of an upload request, 0 means unlimited
+	MaxSize int64
+}
+
+// LimitConfig limits the request rate and the byte rate of the transfers with token buckets, 0 means unlimited.
+// A client is identified by its token or else by its IP.
+type LimitConfig struct {
+	// RequestRate is the requests per second of every client, RequestBurst how many it may send at once
+	RequestRate  float64
+	RequestBurst int
+	// Bandwidth is the bytes per second of all transfers together
+	Bandwidth int64
+	// ConnectionBandwidth is the bytes per second of every transfer
+	ConnectionBandwidth int64
+	// ClientBandwidth is the bytes per second of all transfers of every client
+	ClientBandwidth int64
+}
+
+// WebhookConfig posts the file and paste events to URLs, signed with an HMAC of Secret if it is set
+type WebhookConfig struct {
+	URLs   []string
+	Secret string
+	// Events are the event types which are posted, e.g. file.upload, all events if empty
+	Events []string
+	// Prefixes are the paths whose events are posted, all paths if empty
+	Prefixes []string
+	// QueueDir keeps the pending deliveries across restarts
+	QueueDir string
+	// MaxAttempts is how often a delivery is tried before it is dropped
+	MaxAttempts int
+}
+
+// CodeConfig configures the code server
+type CodeConfig struct {
+	// LanguagesFile is a JSON list of languages which are added to the built-in ones or replace them
+	LanguagesFile string
+	// IDLength is the length of the random base62 IDs of the pastes
+	IDLength int
+	// Dedup reuses the ID of an identical paste instead of creating a new one
+	Dedup bool
+}
+
 type StoreConfig struct {
 	Type  string
 	S3    S3StoreConfig
 	Local LocalStoreConfig
+
+	Encryption EncryptionConfig
+	Cache      CacheConfig
+	// Dedup stores the content of identical files only once
+	Dedup      bool
+	Versioning VersioningConfig
+	Trash      TrashConfig
+	Retention  RetentionConfig
+	Quota      QuotaConfig
 }
 
 type ResourceConfig struct {
@@ -45,9 +118,72 @@ type S3StoreConfig struct {
 	DisableSSL       bool
 }
 
+// EncryptionConfig enables at-rest encryption when a master key is configured
+type EncryptionConfig struct {
+	// Key is the base64 or hex encoded 32 bytes master key
+	Key string
+	// KeyFile is the file containing the master key, it is used when Key is empty
+	KeyFile string
+	// PreviousKeyFiles contains old master keys which are only used to decrypt files not rotated yet
+	PreviousKeyFiles []string
+}
+
+func (e *EncryptionConfig) Enabled() bool {
+	return e.Key != "" || e.KeyFile != ""
+}
+
+// VersioningConfig keeps the history of overwritten and deleted files
+type VersioningConfig struct {
+	Enabled bool
+	// MaxVersions is the max count of old versions kept per file, 0 means unlimited
+	MaxVersions int
+	// MaxAge is the max age of old versions, 0 means unlimited
+	MaxAge time.Duration
+}
+
+// TrashConfig makes deletion move files to the trash, they are purged after Retention
+type TrashConfig struct {
+	Enabled bool
+	// Retention is how long deleted files are kept, 0 means until the trash is emptied
+	Retention time.Duration
+}
+
+// RetentionConfig deletes the expired files every Interval, a file expires at the time given at
+// upload or by the first rule matching its path, e.g. tmp/**=7d
+type RetentionConfig struct {
+	Rules    []string
+	Interval time.Duration
+}
+
+// QuotaConfig limits the storage used by all files, by the files of every token and under path prefixes,
+// 0 means unlimited
+type QuotaConfig struct {
+	MaxBytes int64
+	MaxFiles int64
+	// MaxFileSize is the max size in bytes of a single file
+	MaxFileSize int64
+	// Rules are the quotas of tokens and path prefixes like token:<token>=10G/1000, token:*=1G or prefix:tmp=5G.
+	// The server does not authenticate the tokens, a client escapes its token quota by sending another token or
+	// none. The token quotas need a proxy in front of the server which only lets the known tokens through.
+	Rules []string
+	// AdminToken authorizes POST /quota/rebuild, which scans the whole store. The rebuild is disabled if it is empty.
+	AdminToken string
+}
+
+func (q *QuotaConfig) Enabled() bool {
+	return q.MaxBytes > 0 || q.MaxFiles > 0 || q.MaxFileSize > 0 || len(q.Rules) > 0
+}
+
+// CacheConfig enables a local read-through cache of downloaded files when Dir is set
+type CacheConfig struct {
+	Dir     string
+	MaxSize int64
+}
+
 const (
-	StoreTypeLocal = "local"
-	StoreTypeS3    = "s3"
+	StoreTypeLocal  = "local"
+	StoreTypeS3     = "s3"
+	StoreTypeMemory = "memory"
 )
 
 func (l *LocalStoreConfig) Build() error {
@@ -72,6 +208,18 @@ const (
 	defaultUploadDir   = "./uploads"
 	defaultStaticDir   = "./assert"
 	defaultTemplateDir = "./template"
+
+	defaultWebhookQueueDir    = "./webhooks"
+	defaultWebhookMaxAttempts = 8
+
+	defaultUploadNaming  = "timestamp"
+	defaultUploadWorkers = 4
+
+	defaultCodeIDLength = 8
+
+	defaultCacheMaxSize      = 1 << 30
+	defaultTrashRetention    = 30 * 24 * time.Hour
+	defaultRetentionInterval = time.Hour
 )
 
 var defaultConfigLoader sync.Once
@@ -83,13 +231,39 @@ func GetDefault(envFileName ...string) *Config {
 			// ignore error
 		}
 		defaultConfig = Config{
-			Address:      GetEnvOrDefault("SERVER_LISTEN_ADDRESS", ":8080"),
-			EnableTls:    EnvExist("SERVER_ENABLE_TLS"),
-			InternalHost: GetEnvOrDefault("INTERNAL_HOST", "127.0.0.1"),
+			Address:        GetEnvOrDefault("SERVER_LISTEN_ADDRESS", ":8080"),
+			EnableTls:      EnvExist("SERVER_ENABLE_TLS"),
+			InternalHost:   GetEnvOrDefault("INTERNAL_HOST", "127.0.0.1"),
+			TrustedProxies: GetEnvList("SERVER_TRUSTED_PROXIES"),
 			Resource: ResourceConfig{
 				StaticDir:   GetEnvOrDefault("RESOURCE_STATIC_DIR", defaultStaticDir),
 				TemplateDir: GetEnvOrDefault("RESOURCE_TEMPLATE_DIR", defaultTemplateDir),
 			},
+			Upload: UploadConfig{
+				Naming:  GetEnvOrDefault("UPLOAD_NAMING", defaultUploadNaming),
+				Workers: GetEnvIntOrDefault("UPLOAD_WORKERS", defaultUploadWorkers),
+				MaxSize: GetEnvSizeOrDefault("UPLOAD_MAX_SIZE", 0),
+			},
+			Limit: LimitConfig{
+				RequestRate:         GetEnvFloatOrDefault("LIMIT_REQUEST_RATE", 0),
+				RequestBurst:        GetEnvIntOrDefault("LIMIT_REQUEST_BURST", 0),
+				Bandwidth:           GetEnvSizeOrDefault("LIMIT_BANDWIDTH", 0),
+				ConnectionBandwidth: GetEnvSizeOrDefault("LIMIT_CONNECTION_BANDWIDTH", 0),
+				ClientBandwidth:     GetEnvSizeOrDefault("LIMIT_CLIENT_BANDWIDTH", 0),
+			},
+			Webhook: WebhookConfig{
+				URLs:        GetEnvList("WEBHOOK_URLS"),
+				Secret:      GetEnvOrDefault("WEBHOOK_SECRET"),
+				Events:      GetEnvList("WEBHOOK_EVENTS"),
+				Prefixes:    GetEnvList("WEBHOOK_PREFIXES"),
+				QueueDir:    GetEnvOrDefault("WEBHOOK_QUEUE_DIR", defaultWebhookQueueDir),
+				MaxAttempts: GetEnvIntOrDefault("WEBHOOK_MAX_ATTEMPTS", defaultWebhookMaxAttempts),
+			},
+			Code: CodeConfig{
+				LanguagesFile: GetEnvOrDefault("CODE_LANGUAGES_FILE"),
+				IDLength:      GetEnvIntOrDefault("CODE_ID_LENGTH", defaultCodeIDLength),
+				Dedup:         EnvExist("CODE_DEDUP"),
+			},
 			Store: StoreConfig{
 				Type: os.Getenv("STORE_TYPE"),
 				Local: LocalStoreConfig{
@@ -103,6 +277,36 @@ func GetDefault(envFileName ...string) *Config {
 					DisablePathStyle: EnvExist("STORE_S3_DISABLE_PATH_STYLE"),
 					DisableSSL:       EnvExist("STORE_S3_DISABLE_SSL"),
 				},
+				Encryption: EncryptionConfig{
+					Key:              GetEnvOrDefault("STORE_ENCRYPTION_KEY"),
+					KeyFile:          GetEnvOrDefault("STORE_ENCRYPTION_KEY_FILE"),
+					PreviousKeyFiles: GetEnvList("STORE_ENCRYPTION_PREVIOUS_KEY_FILES"),
+				},
+				Cache: CacheConfig{
+					Dir:     GetEnvOrDefault("STORE_CACHE_DIR"),
+					MaxSize: GetEnvSizeOrDefault("STORE_CACHE_MAX_SIZE", defaultCacheMaxSize),
+				},
+				Dedup: EnvExist("STORE_DEDUP"),
+				Versioning: VersioningConfig{
+					Enabled:     EnvExist("STORE_VERSIONING"),
+					MaxVersions: GetEnvIntOrDefault("STORE_VERSIONING_MAX_VERSIONS", 0),
+					MaxAge:      GetEnvDurationOrDefault("STORE_VERSIONING_MAX_AGE", 0),
+				},
+				Trash: TrashConfig{
+					Enabled:   EnvExist("STORE_TRASH"),
+					Retention: GetEnvDurationOrDefault("STORE_TRASH_RETENTION", defaultTrashRetention),
+				},
+				Retention: RetentionConfig{
+					Rules:    GetEnvList("STORE_RETENTION_RULES"),
+					Interval: GetEnvDurationOrDefault("STORE_RETENTION_INTERVAL", defaultRetentionInterval),
+				},
+				Quota: QuotaConfig{
+					MaxBytes:    GetEnvSizeOrDefault("STORE_QUOTA_MAX_BYTES", 0),
+					MaxFiles:    int64(GetEnvIntOrDefault("STORE_QUOTA_MAX_FILES", 0)),
+					MaxFileSize: GetEnvSizeOrDefault("STORE_QUOTA_MAX_FILE_SIZE", 0),
+					Rules:       GetEnvList("STORE_QUOTA_RULES"),
+					AdminToken:  os.Getenv("STORE_QUOTA_ADMIN_TOKEN"),
+				},
 			},
 		}
 	})
@@ -123,5 +327,83 @@ func GetEnvOrDefault(envKey string, defaultValue ...string) string {
 	return ""
 }
 
+// GetEnvSizeOrDefault parses a size like 1024, 512K, 100MB or 2G from the environment variable
+func GetEnvSizeOrDefault(envKey string, defaultValue int64) int64 {
+	return getEnvValue(envKey, defaultValue, ParseSize)
+}
+
+// GetEnvIntOrDefault parses an integer from the environment variable
+func GetEnvIntOrDefault(envKey string, defaultValue int) int {
+	return getEnvValue(envKey, defaultValue, strconv.Atoi)
+}
+
+// GetEnvFloatOrDefault parses a float from the environment variable
+func GetEnvFloatOrDefault(envKey string, defaultValue float64) float64 {
+	return getEnvValue(envKey, defaultValue, func(s string) (float64, error) {
+		return strconv.ParseFloat(s, 64)
+	})
+}
+
+// GetEnvDurationOrDefault parses a duration like 90m, 12h or 30d from the environment variable
+func GetEnvDurationOrDefault(envKey string, defaultValue time.Duration) time.Duration {
+	return getEnvValue(envKey, defaultValue, ParseDuration)
+}
+
+// getEnvValue parses the environment variable, the default is used if it is unset, or if it is invalid
+// which is logged so a typo does not go unnoticed
+func getEnvValue[T any](envKey string, defaultValue T, parse func(string) (T, error)) T {
+	v := strings.TrimSpace(os.Getenv(envKey))
+	if v == "" {
+		return defaultValue
+	}
+	parsed, err := parse(v)
+	if err != nil {
+		log.Printf("Invalid %s %q, using the default %v: %v", envKey, v, defaultValue, err)
+		return defaultValue
+	}
+	return parsed
+}
+
+// ParseDuration is time.ParseDuration with an additional d suffix for days
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// ParseSize parses a byte size with an optional K, M, G or T suffix, followed by an optional i and B in any case
+func ParseSize(value string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	unit := int64(1)
+	for i, suffix := range []string{"K", "M", "G", "T"} {
+		if rest, ok := strings.CutSuffix(strings.TrimSuffix(s, "I"), suffix); ok {
+			s, unit = rest, 1<<(10*(i+1))
+			break
+		}
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return size * unit, nil
+}
+
+// GetEnvList splits a comma separated environment variable
+func GetEnvList(envKey string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(envKey), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func (c *Config) RegisterFlags(f *pflag.FlagSet) {
 }
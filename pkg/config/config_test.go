@@ -0,0 +1,44 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSize(t *testing.T) {
+	for value, want := range map[string]int64{
+		"1024":   1024,
+		"512K":   512 << 10,
+		"512kb":  512 << 10,
+		"100mb":  100 << 20,
+		"100MB":  100 << 20,
+		" 2g ":   2 << 30,
+		"1T":     1 << 40,
+		"10b":    10,
+		"10 KiB": 10 << 10,
+		"512MiB": 512 << 20,
+		"5i":     -1,
+		"MB":     -1,
+		"x":      -1,
+	} {
+		size, err := ParseSize(value)
+		if want < 0 {
+			assert.Error(t, err, value)
+			continue
+		}
+		assert.NoError(t, err, value)
+		assert.Equal(t, want, size, value)
+	}
+}
+
+func TestGetEnvOrDefault(t *testing.T) {
+	t.Setenv("TEST_SIZE", "100mb")
+	t.Setenv("TEST_INT", "x")
+	t.Setenv("TEST_DURATION", "7d")
+	assert.Equal(t, int64(100<<20), GetEnvSizeOrDefault("TEST_SIZE", 1))
+	assert.Equal(t, 3, GetEnvIntOrDefault("TEST_INT", 3))
+	assert.Equal(t, 3, GetEnvIntOrDefault("TEST_UNSET", 3))
+	assert.Equal(t, 7*24*time.Hour, GetEnvDurationOrDefault("TEST_DURATION", time.Hour))
+}
@@ -1,10 +1,12 @@
 package pkg
 
 import (
+	"context"
 	"github.com/gin-gonic/gin"
 	"github.com/graydovee/fileManager/pkg/config"
 	"github.com/graydovee/fileManager/pkg/server"
 	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/webhook"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"html/template"
@@ -36,27 +38,30 @@ func NewHttpServer(cfg *config.Config) (*HttpServer, error) {
 
 func (s *HttpServer) init() error {
 	s.engine = echo.New()
+	ipExtractor, err := server.NewIPExtractor(s.cfg.TrustedProxies)
+	if err != nil {
+		return err
+	}
+	s.engine.IPExtractor = ipExtractor
 	s.engine.Use(middleware.Logger())
 	s.engine.Use(middleware.Recover())
+	s.engine.Use(server.NewThrottle(&s.cfg.Limit).Middleware())
+
+	fileStore, err := store.New(&s.cfg.Store)
+	if err != nil {
+		log.Fatalf("Error creating store: %s\n", err.Error())
+	}
 
-	var fileStore store.Store
-	switch s.cfg.Store.Type {
-	case config.StoreTypeLocal:
-		fileStore = store.NewLocalStore(&s.cfg.Store.Local)
-	case config.StoreTypeS3:
-		st, err := store.NewS3Store(&s.cfg.Store.S3)
-		if err != nil {
-			log.Fatalf("Error creating S3 store: %s\n", err.Error())
-		}
-		fileStore = st
-	default:
-		log.Fatalf("Unsupported store type: %s\n", s.cfg.Store.Type)
+	hooks, err := webhook.NewDispatcher(&s.cfg.Webhook)
+	if err != nil {
+		return err
 	}
+	go hooks.Run(context.Background())
 
-	if err := server.NewFileServer(s.cfg, fileStore).Setup(s.engine); err != nil {
+	if err := server.NewFileServer(s.cfg, fileStore, hooks).Setup(s.engine); err != nil {
 		return err
 	}
-	if err := server.NewCodeServer(s.cfg, fileStore).Setup(s.engine); err != nil {
+	if err := server.NewCodeServer(s.cfg, fileStore, hooks).Setup(s.engine); err != nil {
 		return err
 	}
 
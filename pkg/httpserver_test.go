@@ -1,7 +1,12 @@
 package pkg
 
 import (
+	"archive/zip"
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"github.com/graydovee/fileManager/pkg/config"
 	flag "github.com/spf13/pflag"
@@ -15,6 +20,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewHttpServer(t *testing.T) {
@@ -25,7 +31,21 @@ func TestNewHttpServer(t *testing.T) {
 	flag.Parse()
 
 	gin.SetMode(gin.TestMode)
-	server, err := NewHttpServer(config.GetDefault())
+	cfg := *config.GetDefault()
+	cfg.Store.Type = config.StoreTypeMemory
+	cfg.Store.Quota.Rules = []string{"token:quota-token=64/2"}
+
+	events := make(chan map[string]interface{}, 16)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := map[string]interface{}{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+		events <- event
+	}))
+	defer hook.Close()
+	cfg.Webhook.URLs = []string{hook.URL}
+	cfg.Webhook.Prefixes = []string{"hooked"}
+	cfg.Webhook.QueueDir = t.TempDir()
+	server, err := NewHttpServer(&cfg)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -89,6 +109,18 @@ func TestNewHttpServer(t *testing.T) {
 	assert.Equal(t, filContent, content)
 	resp.Body.Close()
 
+	// 测试 Range 下载
+	req, err = http.NewRequest("GET", ts.URL+filepath.Join("/download", downloadFilePath), nil)
+	assert.NoError(t, err)
+	req.Header.Set("Range", "bytes=5-8")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	content, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, filContent[5:9], content)
+	resp.Body.Close()
+
 	// 测试 DELETE "/delete/*file"
 	req, err = http.NewRequest("DELETE", ts.URL+filepath.Join("/delete", downloadFilePath), nil)
 	assert.NoError(t, err)
@@ -103,4 +135,515 @@ func TestNewHttpServer(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 	resp.Body.Close()
 
+	// 测试过期时间
+	req, err = http.NewRequest("PUT", ts.URL+"/upload", bytes.NewReader(filContent))
+	assert.NoError(t, err)
+	req.Header.Set("X-Filename", "tmp.txt")
+	req.Header.Set("X-Expires-In", "soon")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp.Body.Close()
+
+	req.Body = io.NopCloser(bytes.NewReader(filContent))
+	req.Header.Set("X-Expires-In", "7d")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "Expires at:")
+	resp.Body.Close()
+
+	// 测试指定路径上传
+	put := func(path string, headers map[string]string) *http.Response {
+		req, err := http.NewRequest("PUT", ts.URL+path, bytes.NewReader(filContent))
+		assert.NoError(t, err)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	resp = put("/upload/latest/agent", nil)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	etag := resp.Header.Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, http.StatusConflict, put("/upload/latest/agent", nil).StatusCode)
+	assert.Equal(t, http.StatusOK, put("/upload", map[string]string{"X-Path": "latest/agent", "X-Overwrite": "always"}).StatusCode)
+	assert.Equal(t, http.StatusPreconditionFailed, put("/upload/latest/agent", map[string]string{"X-Overwrite": "if-match", "If-Match": `"stale"`}).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, put("/upload/latest/agent", map[string]string{"X-Overwrite": "sometimes"}).StatusCode)
+	assert.Equal(t, http.StatusBadRequest, put("/upload/.meta/agent", nil).StatusCode)
+
+	resp, err = client.Get(ts.URL + "/download/latest/agent")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, put("/upload/latest/agent", map[string]string{"X-Overwrite": "if-match", "If-Match": resp.Header.Get("ETag")}).StatusCode)
+
+	// 测试多文件和文件夹上传
+	body = &bytes.Buffer{}
+	writer = multipart.NewWriter(body)
+	assert.NoError(t, writer.WriteField("path", "albums"))
+	for _, name := range []string{"photos/a.jpg", "photos/sub/b.jpg", "c.txt"} {
+		assert.NoError(t, writer.WriteField("relative_path", name))
+		part, err := writer.CreateFormFile("file", filepath.Base(name))
+		assert.NoError(t, err)
+		_, err = part.Write([]byte(name))
+		assert.NoError(t, err)
+	}
+	writer.Close()
+
+	req, err = http.NewRequest("POST", ts.URL+"/upload", body)
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var results []struct {
+		Name  string `json:"name"`
+		Path  string `json:"path"`
+		Error string `json:"error"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+	resp.Body.Close()
+	if assert.Len(t, results, 3) {
+		assert.Equal(t, "albums/photos/sub/b.jpg", results[1].Path)
+	}
+
+	resp, err = client.Get(ts.URL + "/download/albums/photos/sub/b.jpg")
+	assert.NoError(t, err)
+	content, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "photos/sub/b.jpg", string(content))
+	resp.Body.Close()
+
+	// 测试上传校验和
+	sum := sha256.Sum256(filContent)
+	checksum := hex.EncodeToString(sum[:])
+	assert.Equal(t, http.StatusBadRequest, put("/upload/checked/agent", map[string]string{"X-Checksum-Sha256": strings.Repeat("0", 64)}).StatusCode)
+	resp, err = client.Get(ts.URL + "/download/checked/agent")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, put("/upload/checked/agent", map[string]string{"X-Checksum-Sha256": checksum}).StatusCode)
+
+	resp, err = client.Get(ts.URL + "/download/checked/agent")
+	assert.NoError(t, err)
+	assert.Equal(t, checksum, resp.Header.Get("X-Checksum-Sha256"))
+	assert.Equal(t, "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":", resp.Header.Get("Repr-Digest"))
+	resp.Body.Close()
+	resp, err = client.Get(ts.URL + "/download/checked/agent.sha256")
+	assert.NoError(t, err)
+	content, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, checksum+"  agent\n", string(content))
+	resp.Body.Close()
+
+	// 测试 webhook 通知
+	assert.Equal(t, http.StatusOK, put("/upload/hooked/agent", map[string]string{"X-Auth-Token": "hook-token"}).StatusCode)
+	select {
+	case event := <-events:
+		assert.Equal(t, "file.upload", event["type"])
+		assert.Equal(t, "hooked/agent", event["key"])
+		assert.Equal(t, float64(len(filContent)), event["size"])
+		assert.NotEmpty(t, event["sha256"])
+		assert.NotEmpty(t, event["actor"].(map[string]interface{})["token"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("the upload event is not delivered")
+	}
+	resp, err = client.Get(ts.URL + "/download/hooked/agent")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	select {
+	case event := <-events:
+		assert.Equal(t, "share.access", event["type"])
+		assert.Equal(t, "hooked/agent", event["key"])
+		assert.Equal(t, ts.URL+"/download/hooked/agent", event["url"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("the access event is not delivered")
+	}
+
+	// 测试令牌配额
+	quotaHeaders := map[string]string{"Authorization": "Bearer quota-token"}
+	assert.Equal(t, http.StatusOK, put("/upload/quota/a", quotaHeaders).StatusCode)
+	assert.Equal(t, http.StatusOK, put("/upload/quota/b", quotaHeaders).StatusCode)
+	assert.Equal(t, http.StatusInsufficientStorage, put("/upload/quota/c", quotaHeaders).StatusCode)
+	assert.Equal(t, http.StatusOK, put("/upload/quota/c", nil).StatusCode)
+
+	req, err = http.NewRequest("GET", ts.URL+"/quota", nil)
+	assert.NoError(t, err)
+	req.Header.Set("X-Auth-Token", "quota-token")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	var usage struct {
+		Owner struct {
+			Bytes    int64 `json:"bytes"`
+			Files    int64 `json:"files"`
+			MaxFiles int64 `json:"maxFiles"`
+		} `json:"owner"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&usage))
+	resp.Body.Close()
+	assert.Equal(t, int64(2*len(filContent)), usage.Owner.Bytes)
+	assert.Equal(t, int64(2), usage.Owner.Files)
+	assert.Equal(t, int64(2), usage.Owner.MaxFiles)
+
+	req, err = http.NewRequest("DELETE", ts.URL+"/delete/quota/a", nil)
+	assert.NoError(t, err)
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, put("/upload/quota/d", quotaHeaders).StatusCode)
+
+	// 测试命令行创建代码片段
+	resp, err = client.Post(ts.URL+"/code/go", "application/x-www-form-urlencoded", strings.NewReader("package main\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	pasteURL := strings.TrimSpace(string(data))
+	assert.Regexp(t, `/code/go/[0-9a-zA-Z]{8}$`, pasteURL)
+
+	resp, err = client.Get(pasteURL + "/raw")
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "package main\n", string(data))
+
+	req, err = http.NewRequest("PUT", ts.URL+"/code?lang=python", strings.NewReader("print(1)"))
+	assert.NoError(t, err)
+	req.Header.Set("Accept", "application/json")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	var paste struct {
+		URL    string `json:"url"`
+		RawURL string `json:"rawUrl"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&paste))
+	resp.Body.Close()
+	assert.Equal(t, paste.URL+"/raw", paste.RawURL)
+
+	req, err = http.NewRequest("PUT", ts.URL+"/code?lang=go&title=Build+log&expires_in=1d", strings.NewReader("go build ./..."))
+	assert.NoError(t, err)
+	req.Header.Set("Accept", "application/json")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	var record struct {
+		URL       string     `json:"url"`
+		Title     string     `json:"title"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&record))
+	resp.Body.Close()
+	assert.Equal(t, "Build log", record.Title)
+	assert.NotNil(t, record.ExpiresAt)
+	for _, page := range []string{record.URL, ts.URL + "/code"} {
+		resp, err = client.Get(page)
+		assert.NoError(t, err)
+		data, err = io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Contains(t, string(data), "Build log")
+	}
+	req, err = http.NewRequest("PUT", ts.URL+"/code?lang=cobol", strings.NewReader("DISPLAY 1"))
+	assert.NoError(t, err)
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// 测试语言注册表
+	req, err = http.NewRequest("PUT", ts.URL+"/code?lang=psql", strings.NewReader("SELECT 1;"))
+	assert.NoError(t, err)
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Regexp(t, `/code/sql/[^/]+$`, strings.TrimSpace(string(data)))
+
+	resp, err = client.Get(ts.URL + "/code/languages")
+	assert.NoError(t, err)
+	var languages []struct {
+		Name       string   `json:"name"`
+		Extensions []string `json:"extensions"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&languages))
+	resp.Body.Close()
+	assert.Contains(t, languages, struct {
+		Name       string   `json:"name"`
+		Extensions []string `json:"extensions"`
+	}{"toml", []string{".toml"}})
+
+	// 测试自动检测语言
+	req, err = http.NewRequest("PUT", ts.URL+"/code", strings.NewReader("package main\n\nfunc main() {}\n"))
+	assert.NoError(t, err)
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	pasteURL = strings.TrimSpace(string(data))
+	assert.Regexp(t, `/code/go/[^/]+$`, pasteURL)
+
+	resp, err = client.Get(pasteURL + "?lang=python")
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(data), `value="python" selected`)
+
+	// 测试服务端高亮
+	req, err = http.NewRequest("GET", pasteURL, nil)
+	assert.NoError(t, err)
+	req.Header.Set("User-Agent", "curl/8.5.0")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "text/plain; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Contains(t, string(data), "\x1b[")
+
+	req, err = http.NewRequest("PUT", ts.URL+"/code?lang=md", strings.NewReader("# Notes\n\n<script>alert(1)</script>\n"))
+	assert.NoError(t, err)
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	markdownURL := strings.TrimSpace(string(data))
+	resp, err = client.Get(markdownURL)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Contains(t, string(data), "<h1>Notes</h1>")
+	assert.NotContains(t, string(data), "<script>alert")
+
+	// 测试 fork 和差异, 匿名的代码片段只能 fork
+	parentID := pasteURL[strings.LastIndex(pasteURL, "/")+1:]
+	req, err = http.NewRequest("POST", ts.URL+"/code", strings.NewReader("language=auto&parent="+parentID+"&fork=1&code=package+main%0A%0Afunc+main()+%7B+println(1)+%7D%0A"))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	revisionURL := resp.Request.URL.String()
+	assert.Regexp(t, `/code/go/[^/]+$`, revisionURL)
+	revisionID := revisionURL[strings.LastIndex(revisionURL, "/")+1:]
+	for _, page := range []struct{ url, contains string }{
+		{revisionURL, parentID},
+		{revisionURL + "/edit", `name="parent" value="` + revisionID},
+		{revisionURL + "/edit", `name="fork"`},
+		{pasteURL + "/fork", `name="fork"`},
+		{revisionURL + "/diff", parentID},
+		{revisionURL + "/diff?view=split", parentID},
+	} {
+		resp, err = client.Get(page.url)
+		assert.NoError(t, err)
+		data, err = io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, page.url)
+		assert.Contains(t, string(data), page.contains, page.url)
+	}
+	assert.Contains(t, string(data), "diff-insert")
+
+	// 测试多文件代码片段
+	body = &bytes.Buffer{}
+	writer = multipart.NewWriter(body)
+	for name, content := range map[string]string{"main.go": "package main\n", "go.mod": "module repro\n"} {
+		part, err = writer.CreateFormFile("file", name)
+		assert.NoError(t, err)
+		_, err = part.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.WriteField("title", "repro"))
+	writer.Close()
+	req, err = http.NewRequest("POST", ts.URL+"/code", body)
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	multiURL := strings.TrimSpace(string(data))
+
+	resp, err = client.Get(multiURL + "?file=go.mod")
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(data), "nav-tabs")
+	assert.Contains(t, string(data), `href="?file=main.go"`)
+	assert.Contains(t, string(data), "repro")
+
+	resp, err = client.Get(multiURL + "/raw?file=go.mod")
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "module repro\n", string(data))
+
+	resp, err = client.Get(multiURL + "/zip")
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "application/zip", resp.Header.Get("Content-Type"))
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if assert.NoError(t, err) {
+		var names []string
+		for _, f := range archive.File {
+			names = append(names, f.Name)
+		}
+		assert.ElementsMatch(t, []string{"main.go", "go.mod"}, names)
+	}
+
+	req, err = http.NewRequest("POST", ts.URL+"/code", strings.NewReader(`{"title": "json", "files": [{"name": "a.py", "content": "print(1)\n"}, {"name": "b.sql", "content": "SELECT 1;\n"}]}`))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	var multi struct {
+		URL   string `json:"url"`
+		Files []struct {
+			Name     string `json:"name"`
+			Language string `json:"language"`
+		} `json:"files"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&multi))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Regexp(t, `/code/python/[^/]+$`, multi.URL)
+	if assert.Len(t, multi.Files, 2) {
+		assert.Equal(t, "sql", multi.Files[1].Language)
+	}
+	multiID := multi.URL[strings.LastIndex(multi.URL, "/")+1:]
+	req, err = http.NewRequest("POST", ts.URL+"/code", strings.NewReader(`{"parent": "`+multiID+`", "fork": true, "files": [{"name": "a.py", "content": "print(2)\n"}]}`))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	for _, view := range []string{"unified", "split"} {
+		resp, err = client.Get(strings.TrimSpace(string(data)) + "/diff?view=" + view)
+		assert.NoError(t, err)
+		page, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Contains(t, string(page), "b.sql")
+		assert.Contains(t, string(page), "print(2)")
+	}
+
+	// 测试代码片段列表和搜索
+	req, err = http.NewRequest("PUT", ts.URL+"/code?lang=go&title=Hidden", strings.NewReader("package hidden\n"))
+	assert.NoError(t, err)
+	req.Header.Set("X-Private", "1")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	for _, page := range []struct{ url, contains, excludes string }{
+		{ts.URL + "/code/list", "Build log", "Hidden"},
+		{ts.URL + "/code/list?q=repro&lang=go", "repro", "Build log"},
+		{ts.URL + "/code", `href="/code/list"`, "Hidden"},
+	} {
+		resp, err = client.Get(page.url)
+		assert.NoError(t, err)
+		data, err = io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode, page.url)
+		assert.Contains(t, string(data), page.contains, page.url)
+		assert.NotContains(t, string(data), page.excludes, page.url)
+	}
+
+	// 测试密码保护和端到端加密的代码片段
+	req, err = http.NewRequest("PUT", ts.URL+"/code?lang=bash&title=Secrets", strings.NewReader("export TOKEN=abc\n"))
+	assert.NoError(t, err)
+	req.Header.Set("X-Paste-Password", "hunter2")
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	pasteURL = strings.TrimSpace(string(data))
+	resp, err = client.Get(pasteURL)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, string(data), `name="password"`)
+	assert.NotContains(t, string(data), "TOKEN")
+	resp, err = client.Post(pasteURL+"/unlock", "application/x-www-form-urlencoded", strings.NewReader("password=wrong"))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	sealed := "fm1.AAAAAAAAAAAAAAAA.AAAAAAAAAAAAAAAAAAAAAA"
+	resp, err = client.Post(ts.URL+"/code", "application/json", strings.NewReader(`{"encrypted": true, "files": [{"content": "`+sealed+`"}]}`))
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, err = client.Get(strings.TrimSpace(string(data)))
+	assert.NoError(t, err)
+	data, err = io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(data), `data-sealed="`+sealed+`"`)
+	assert.Contains(t, string(data), "/assert/js/pastecrypt.js")
+
+	// 测试上传大小限制
+	cfg.Upload.MaxSize = 1024
+	large := bytes.Repeat([]byte("x"), 4096)
+	body = &bytes.Buffer{}
+	writer = multipart.NewWriter(body)
+	part, err = writer.CreateFormFile("file", "large.bin")
+	assert.NoError(t, err)
+	_, err = part.Write(large)
+	assert.NoError(t, err)
+	writer.Close()
+	req, err = http.NewRequest("POST", ts.URL+"/upload", io.MultiReader(body))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	resp.Body.Close()
+
+	req, err = http.NewRequest("PUT", ts.URL+"/upload/large.bin", bytes.NewReader(large))
+	assert.NoError(t, err)
+	resp, err = client.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	resp.Body.Close()
+
+	resp, err = client.Get(ts.URL + "/download/large.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
 }
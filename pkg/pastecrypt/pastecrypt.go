@@ -0,0 +1,136 @@
+// Package pastecrypt seals the end-to-end encrypted pastes, which the code server stores without being able
+// to read them. A sealed paste is "fm1.<iv>.<ciphertext>" with the base64url encoded parts, the ciphertext is
+// the AES-256-GCM encryption of the JSON payload. The key is kept in the fragment of the paste URL, which
+// the browsers do not send, and the browser page decrypts the paste with WebCrypto in the same format.
+package pastecrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Version is the prefix of the sealed pastes
+const Version = "fm1"
+
+const (
+	// KeySize is the size of the AES-256 keys
+	KeySize = 32
+	// ivSize is the nonce size of GCM
+	ivSize = 12
+	// tagSize is the size of the GCM authentication tag appended to the ciphertext
+	tagSize = 16
+)
+
+var encoding = base64.RawURLEncoding
+
+// File is a file of a paste
+type File struct {
+	Name     string `json:"name,omitempty"`
+	Language string `json:"language,omitempty"`
+	Content  string `json:"content"`
+}
+
+// Payload is the encrypted content of a paste, the title is encrypted too
+type Payload struct {
+	Title string `json:"title,omitempty"`
+	Files []File `json:"files"`
+}
+
+// NewKey returns a random key
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// EncodeKey encodes the key for the URL fragment
+func EncodeKey(key []byte) string {
+	return encoding.EncodeToString(key)
+}
+
+// DecodeKey decodes the key of a URL fragment
+func DecodeKey(s string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.TrimPrefix(s, "#"))
+	if err != nil || len(key) != KeySize {
+		return nil, errors.New("invalid paste key")
+	}
+	return key, nil
+}
+
+// Seal encrypts the payload with the key
+func Seal(key []byte, payload *Payload) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, ivSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	ciphertext := aead.Seal(nil, iv, plaintext, nil)
+	return strings.Join([]string{Version, encoding.EncodeToString(iv), encoding.EncodeToString(ciphertext)}, "."), nil
+}
+
+// Open decrypts the sealed paste with the key
+func Open(key []byte, sealed string) (*Payload, error) {
+	iv, ciphertext, err := parse(sealed)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, iv, ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("failed to decrypt the paste, the key is wrong")
+	}
+	payload := &Payload{}
+	if err := json.Unmarshal(plaintext, payload); err != nil {
+		return nil, fmt.Errorf("failed to parse the paste: %w", err)
+	}
+	return payload, nil
+}
+
+// Valid checks the format of a sealed paste, it cannot be decrypted without the key
+func Valid(sealed string) error {
+	_, _, err := parse(sealed)
+	return err
+}
+
+func parse(sealed string) (iv, ciphertext []byte, err error) {
+	parts := strings.Split(strings.TrimSpace(sealed), ".")
+	if len(parts) != 3 || parts[0] != Version {
+		return nil, nil, fmt.Errorf("not a %s sealed paste", Version)
+	}
+	if iv, err = encoding.DecodeString(parts[1]); err != nil || len(iv) != ivSize {
+		return nil, nil, errors.New("invalid iv of the sealed paste")
+	}
+	if ciphertext, err = encoding.DecodeString(parts[2]); err != nil || len(ciphertext) < tagSize {
+		return nil, nil, errors.New("invalid ciphertext of the sealed paste")
+	}
+	return iv, ciphertext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, errors.New("invalid paste key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
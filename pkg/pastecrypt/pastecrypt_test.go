@@ -0,0 +1,53 @@
+package pastecrypt
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := NewKey()
+	assert.NoError(t, err)
+	payload := &Payload{Title: "secret", Files: []File{{Name: "a.env", Content: "TOKEN=1\n"}, {Content: "x"}}}
+
+	sealed, err := Seal(key, payload)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed, Version+"."))
+	assert.NotContains(t, sealed, "TOKEN")
+	assert.NoError(t, Valid(sealed))
+
+	decoded, err := DecodeKey("#" + EncodeKey(key))
+	assert.NoError(t, err)
+	opened, err := Open(decoded, sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, opened)
+
+	other, err := NewKey()
+	assert.NoError(t, err)
+	_, err = Open(other, sealed)
+	assert.Error(t, err)
+	// the sealed pastes are authenticated
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if tampered != sealed {
+		_, err = Open(key, tampered)
+		assert.Error(t, err)
+	}
+}
+
+func TestValid(t *testing.T) {
+	for _, sealed := range []string{
+		"",
+		"plain text",
+		"fm2.AAAAAAAAAAAAAAAA.AAAAAAAAAAAAAAAAAAAAAA",
+		"fm1.AAAA.AAAAAAAAAAAAAAAAAAAAAA",
+		"fm1.AAAAAAAAAAAAAAAA.AAAA",
+		"fm1.AAAAAAAAAAAAAAAA.!!!!AAAAAAAAAAAAAAAAAA",
+	} {
+		assert.Error(t, Valid(sealed), sealed)
+	}
+	assert.NoError(t, Valid("fm1.AAAAAAAAAAAAAAAA.AAAAAAAAAAAAAAAAAAAAAA"))
+	_, err := DecodeKey("short")
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/labstack/echo/v4"
+)
+
+// checksumHeader carries the hex SHA-256 of an upload or download
+const checksumHeader = "X-Checksum-Sha256"
+
+// checksumSuffix is the suffix of the sidecar URL which serves the SHA-256 of a file like sha256sum
+const checksumSuffix = ".sha256"
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// digests are the digests of an upload announced by the client, nil if not announced
+type digests struct {
+	md5    []byte
+	sha256 []byte
+}
+
+// parseDigests reads the expected digests from Content-MD5, Digest, Repr-Digest and X-Checksum-Sha256
+func parseDigests(header http.Header) (*digests, error) {
+	d := &digests{}
+	set := func(field *[]byte, value []byte, size int, source string) error {
+		if len(value) != size {
+			return fmt.Errorf("invalid %s digest", source)
+		}
+		if *field != nil && !bytes.Equal(*field, value) {
+			return fmt.Errorf("conflicting %s digests", source)
+		}
+		*field = value
+		return nil
+	}
+
+	if v := header.Get("Content-MD5"); v != "" {
+		sum, _ := base64.StdEncoding.DecodeString(v)
+		if err := set(&d.md5, sum, md5.Size, "Content-MD5"); err != nil {
+			return nil, err
+		}
+	}
+	if v := header.Get(checksumHeader); v != "" {
+		sum, _ := hex.DecodeString(v)
+		if err := set(&d.sha256, sum, sha256.Size, checksumHeader); err != nil {
+			return nil, err
+		}
+	}
+	// Digest is "SHA-256=<base64>", Repr-Digest is "sha-256=:<base64>:"
+	for _, name := range []string{"Digest", "Repr-Digest"} {
+		for _, item := range strings.Split(header.Get(name), ",") {
+			alg, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				continue
+			}
+			sum, _ := base64.StdEncoding.DecodeString(strings.Trim(value, ":"))
+			var err error
+			switch strings.ToLower(alg) {
+			case "sha-256":
+				err = set(&d.sha256, sum, sha256.Size, name)
+			case "md5":
+				err = set(&d.md5, sum, md5.Size, name)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if d.md5 == nil && d.sha256 == nil {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// digestReader hashes the content while it is read and fails at the end if it does not match the
+// expected digests, so that the store drops the upload instead of committing it
+type digestReader struct {
+	r        io.Reader
+	expected *digests
+	md5      hash.Hash
+	sha256   hash.Hash
+	mismatch bool
+}
+
+func newDigestReader(r io.Reader, expected *digests) *digestReader {
+	d := &digestReader{r: r, expected: expected, sha256: sha256.New()}
+	if expected != nil && expected.md5 != nil {
+		d.md5 = md5.New()
+	}
+	return d
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.sha256.Write(p[:n])
+	if d.md5 != nil {
+		d.md5.Write(p[:n])
+	}
+	if err == io.EOF && !d.verify() {
+		d.mismatch = true
+		return n, errChecksumMismatch
+	}
+	return n, err
+}
+
+func (d *digestReader) verify() bool {
+	if d.expected == nil {
+		return true
+	}
+	if d.expected.sha256 != nil && !bytes.Equal(d.expected.sha256, d.sha256.Sum(nil)) {
+		return false
+	}
+	return d.md5 == nil || bytes.Equal(d.expected.md5, d.md5.Sum(nil))
+}
+
+// SHA256 returns the hex SHA-256 of the content read so far
+func (d *digestReader) SHA256() string {
+	return hex.EncodeToString(d.sha256.Sum(nil))
+}
+
+// setChecksumHeaders sets the digest headers of a download from the SHA-256 stored at upload
+func setChecksumHeaders(c echo.Context, meta *store.FileMeta) {
+	sum, err := hex.DecodeString(meta.Metadata[store.MetaSHA256])
+	if err != nil || len(sum) != sha256.Size {
+		return
+	}
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	c.Response().Header().Set(checksumHeader, hex.EncodeToString(sum))
+	c.Response().Header().Set("Repr-Digest", "sha-256=:"+encoded+":")
+	c.Response().Header().Set("Digest", "SHA-256="+encoded)
+}
+
+// checksumSidecar serves <file>.sha256 in the format of sha256sum, ok is false if file has no stored checksum
+func (f *FilerServer) checksumSidecar(c echo.Context, file string) (ok bool, err error) {
+	key, found := strings.CutSuffix(file, checksumSuffix)
+	if !found {
+		return false, nil
+	}
+	meta, err := f.store.FileMeta(context.Background(), key)
+	if err != nil || meta == nil || meta.Metadata[store.MetaSHA256] == "" {
+		return false, err
+	}
+	return true, c.String(http.StatusOK, fmt.Sprintf("%s  %s\n", meta.Metadata[store.MetaSHA256], path.Base(key)))
+}
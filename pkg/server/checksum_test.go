@@ -0,0 +1,72 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseDigests(t *testing.T) {
+	content := []byte("hello")
+	sha := sha256.Sum256(content)
+	sum := md5.Sum(content)
+	b64 := base64.StdEncoding.EncodeToString(sha[:])
+
+	d, err := parseDigests(http.Header{})
+	assert.NoError(t, err)
+	assert.Nil(t, d)
+
+	header := http.Header{}
+	header.Set(checksumHeader, hex.EncodeToString(sha[:]))
+	header.Set("Repr-Digest", "sha-256=:"+b64+":")
+	header.Set("Digest", "SHA-256="+b64)
+	header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	d, err = parseDigests(header)
+	assert.NoError(t, err)
+	assert.Equal(t, sha[:], d.sha256)
+	assert.Equal(t, sum[:], d.md5)
+
+	for name, value := range map[string]string{
+		checksumHeader: "abc",
+		"Content-MD5":  "not base64",
+		"Digest":       "SHA-256=" + base64.StdEncoding.EncodeToString(sum[:]),
+	} {
+		header := http.Header{}
+		header.Set(name, value)
+		_, err := parseDigests(header)
+		assert.Error(t, err, name)
+	}
+
+	// conflicting digests
+	header = http.Header{}
+	header.Set(checksumHeader, hex.EncodeToString(sha[:]))
+	header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(make([]byte, sha256.Size)))
+	_, err = parseDigests(header)
+	assert.Error(t, err)
+}
+
+func Test_digestReader(t *testing.T) {
+	sha := sha256.Sum256([]byte("hello"))
+
+	r := newDigestReader(strings.NewReader("hello"), &digests{sha256: sha[:]})
+	_, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.False(t, r.mismatch)
+	assert.Equal(t, hex.EncodeToString(sha[:]), r.SHA256())
+
+	r = newDigestReader(strings.NewReader("hellO"), &digests{sha256: sha[:]})
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, errChecksumMismatch)
+	assert.True(t, r.mismatch)
+
+	r = newDigestReader(strings.NewReader("anything"), nil)
+	_, err = io.ReadAll(r)
+	assert.NoError(t, err)
+}
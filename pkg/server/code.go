@@ -1,151 +1,575 @@
 package server
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
-	"crypto/md5"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/graydovee/fileManager/pkg/config"
 	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/webhook"
 	"github.com/labstack/echo/v4"
+	"io"
+	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strings"
+	"time"
 )
 
 // CodeServer handles code upload and display functionalities
 type CodeServer struct {
-	store store.Store
+	cfg       *config.Config
+	store     store.Store
+	hooks     *webhook.Dispatcher
+	languages *LanguageRegistry
+	index     *pasteIndex
+	catalog   *pasteCatalog
+	// unlocks limits the password attempts of the protected pastes
+	unlocks *clientLimiters
+	// derivations holds a slot of every Argon2id derivation running
+	derivations chan struct{}
 }
 
 // NewCodeServer creates a new instance of CodeServer
-func NewCodeServer(_ *config.Config, st store.Store) *CodeServer {
+func NewCodeServer(cfg *config.Config, st store.Store, hooks *webhook.Dispatcher) *CodeServer {
 	return &CodeServer{
-		store: st,
+		cfg:         cfg,
+		store:       st,
+		hooks:       hooks,
+		index:       newPasteIndex(),
+		catalog:     newPasteCatalog(),
+		unlocks:     newUnlockLimiters(),
+		derivations: make(chan struct{}, maxDerivations),
 	}
 }
 
 // Setup configures the routes and middleware for CodeServer
 func (s *CodeServer) Setup(e *echo.Echo) error {
+	if err := validatePasteIDLength(s.cfg.Code.IDLength); err != nil {
+		return err
+	}
+	languages, err := LoadLanguageRegistry(s.cfg.Code.LanguagesFile)
+	if err != nil {
+		return err
+	}
+	s.languages = languages
+
 	group := e.Group("/code")
 
 	// Routes
 	group.GET("", s.handleUploadPage)
+	group.GET("/languages", s.handleLanguages)
+	group.GET("/list", s.handlePasteList)
 	group.GET("/:lang/:hash", s.handleCodeShow)
+	group.GET("/:lang/:hash/raw", s.handleCodeRaw)
+	group.GET("/:lang/:hash/zip", s.handleCodeZip)
+	group.GET("/:lang/:hash/edit", s.handleEditPage)
+	group.GET("/:lang/:hash/fork", s.handleEditPage)
+	group.GET("/:lang/:hash/diff", s.handleCodeDiff)
 	group.POST("", s.handleUpload)
+	group.PUT("", s.handleRawUpload)
+	group.POST("/:lang", s.handleRawUpload)
+	group.POST("/:lang/:hash/unlock", s.handleUnlock)
 
 	return nil
 }
 
-// extMap maps programming languages to their respective file extensions
-var extMap = map[string]string{
-	"c":          ".c",
-	"cpp":        ".cpp",
-	"bash":       ".sh",
-	"go":         ".go",
-	"python":     ".py",
-	"java":       ".java",
-	"javascript": ".js",
-	"rust":       ".rs",
-	"php":        ".php",
-	"html":       ".html",
-	"yaml":       ".yaml",
-	"json":       ".json",
-	"xml":        ".xml",
-}
-
-// handleUploadPage renders the code upload page with supported extensions
+// maxPasteSize is the max size of a paste, the pastes are kept in memory when shown
+const maxPasteSize = 10 << 20
+
+// recentPastes is the count of pastes listed on the upload page
+const recentPastes = 20
+
+// handleUploadPage renders the code upload page with the supported languages and the recent pastes
 func (s *CodeServer) handleUploadPage(c echo.Context) error {
+	pastes, err := s.findPastes(context.Background(), &pasteFilter{}, recentPastes)
+	if err != nil {
+		c.Logger().Errorf("Error listing the pastes: %v", err)
+	}
 	return c.Render(http.StatusOK, "code.html", map[string]interface{}{
-		"ExtMap": extMap,
+		"Languages": s.languages.Languages(),
+		"Pastes":    pastes,
+		"Files":     []pasteInput{{}},
 	})
 }
 
-// handleUpload processes the code upload form
+// handleLanguages lists the supported languages as JSON
+func (s *CodeServer) handleLanguages(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.languages.Languages())
+}
+
+// handleUpload creates a paste from the upload form, from a multipart upload of its files, e.g.
+// curl -F file=@main.go -F file=@go.mod http://host/code, or from a JSON body. The form is redirected to the
+// paste, the others get its URL like handleRawUpload.
 func (s *CodeServer) handleUpload(c echo.Context) error {
-	// Parse the form data
-	if err := c.Request().ParseForm(); err != nil {
-		c.Logger().Errorf("Error parsing form: %v", err)
-		return c.String(http.StatusBadRequest, "Invalid form data")
+	req, status, err := readPasteRequest(c)
+	if err != nil {
+		return c.String(status, err.Error())
 	}
 
-	code := c.FormValue("code")
-	language := c.FormValue("language")
+	paste, status, err := s.savePaste(c, req)
+	if err != nil {
+		return c.String(status, err.Error())
+	}
 
-	if code == "" || language == "" {
-		return c.String(http.StatusBadRequest, "Code or language is empty")
+	mediaType, _, _ := mime.ParseMediaType(c.Request().Header.Get(echo.HeaderContentType))
+	if mediaType == echo.MIMEApplicationForm {
+		return c.Redirect(http.StatusSeeOther, paste.Path())
 	}
+	return pasteCreated(c, paste)
+}
 
-	ext, ok := extMap[language]
-	if !ok {
-		return c.String(http.StatusBadRequest, "Language not supported")
+// handleRawUpload creates a paste from the request body, e.g. curl --data-binary @main.go http://host/code/go,
+// the language is taken from the path or the lang query and detected if it is missing. The URL of the paste
+// is answered in plain text, or as JSON if it is accepted.
+func (s *CodeServer) handleRawUpload(c echo.Context) error {
+	language := c.Param("lang")
+	if language == "" {
+		language = c.QueryParam("lang")
 	}
 
-	// Create directory structure
-	dirname := filepath.Join("code", language)
-	if err := os.MkdirAll(dirname, os.ModePerm); err != nil {
-		c.Logger().Errorf("Failed to create directory %s: %v", dirname, err)
-		return c.String(http.StatusInternalServerError, "Create directory failed")
+	body, err := io.ReadAll(io.LimitReader(throttleReader(c, c.Request().Body), maxPasteSize+1))
+	if err != nil {
+		c.Logger().Errorf("Error reading the code: %v", err)
+		return c.String(http.StatusBadRequest, "Error reading the code")
+	}
+	if len(body) > maxPasteSize {
+		return c.String(http.StatusRequestEntityTooLarge, "Code is too large")
+	}
+	if len(body) == 0 {
+		return c.String(http.StatusBadRequest, "Code is empty")
 	}
 
-	// Generate filename using a short hash of the code
-	filename := GetTimeStamp() + "-" + shortHash(code)
-	filePath := filepath.Join(dirname, filename+ext)
+	req := &pasteRequest{Files: []pasteInput{{
+		Name:     pasteOption(c, "X-Filename", "filename"),
+		Language: language,
+		Content:  string(body),
+	}}}
+	req.withOptions(c)
+	paste, status, err := s.savePaste(c, req)
+	if err != nil {
+		return c.String(status, err.Error())
+	}
+	return pasteCreated(c, paste)
+}
 
-	// Upload the file to the store
-	buffer := bytes.NewBuffer([]byte(code))
-	if err := s.store.UploadFile(context.Background(), buffer, filePath); err != nil {
-		c.Logger().Errorf("Failed to save code %s: %v", filePath, err)
-		return c.String(http.StatusInternalServerError, "Failed to save code")
+// pasteCreated answers the URL of the new paste in plain text, or as JSON if it is accepted
+func pasteCreated(c echo.Context, paste *pasteResult) error {
+	if strings.Contains(c.Request().Header.Get("Accept"), "application/json") {
+		return c.JSON(http.StatusCreated, paste)
 	}
+	return c.String(http.StatusCreated, paste.URL+"\n")
+}
 
-	// Optionally, you can redirect to the code display page
-	displayURL := fmt.Sprintf("/code/%s/%s", language, filename)
-	return c.Redirect(http.StatusSeeOther, displayURL)
+// pasteResult is the answer to the creation of a paste
+type pasteResult struct {
+	*Paste
+	URL    string `json:"url"`
+	RawURL string `json:"rawUrl"`
+	// Protected replaces the password of the paste, which is not answered
+	Protected bool `json:"protected,omitempty"`
 }
 
-// handleCodeShow retrieves and displays the uploaded code
-func (s *CodeServer) handleCodeShow(c echo.Context) error {
-	lang := c.Param("lang")
-	hash := c.Param("hash")
+func (s *CodeServer) newPasteResult(c echo.Context, p *Paste) *pasteResult {
+	result := &pasteResult{
+		Paste:     p,
+		URL:       getUrl(c.Request().Host, p.Path(), s.cfg.EnableTls),
+		RawURL:    getUrl(c.Request().Host, p.Path()+"/raw", s.cfg.EnableTls),
+		Protected: p.Password != nil,
+	}
+	if p.Password != nil {
+		shown := *p
+		shown.Password = nil
+		result.Paste = &shown
+	}
+	return result
+}
 
-	ext, ok := extMap[lang]
-	if !ok {
-		return c.String(http.StatusBadRequest, "Language not supported")
+// savePaste stores the files and the record of a paste and notifies the webhooks, the error is the message
+// to answer with status. A paste of a single file keeps the layout of the code directory of its language,
+// the files of a multi-file paste are kept under code/files/<id>.
+func (s *CodeServer) savePaste(c echo.Context, req *pasteRequest) (*pasteResult, int, error) {
+	if len(req.Files) == 0 {
+		return nil, http.StatusBadRequest, errors.New("Code is empty")
+	}
+	if len(req.Files) > maxPasteFiles {
+		return nil, http.StatusBadRequest, fmt.Errorf("A paste has at most %d files", maxPasteFiles)
+	}
+	if req.Encrypted {
+		if err := checkEncryptedPaste(req); err != nil {
+			return nil, http.StatusBadRequest, err
+		}
+	}
+	parent, fork, status, err := s.pasteParent(c, req.Parent, req.Fork)
+	if err != nil {
+		return nil, status, err
+	}
+
+	paste := &Paste{
+		Title:   req.Title,
+		Created: time.Now().UTC(),
+		Author:  requestOwner(c),
+		// the revisions of a private paste stay private
+		Private:   req.Private || (parent != nil && !fork && parent.Private),
+		Encrypted: req.Encrypted,
+	}
+	if req.ExpiresIn != "" {
+		ttl, err := config.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			return nil, http.StatusBadRequest, fmt.Errorf("invalid expires in %q", req.ExpiresIn)
+		}
+		expiresAt := paste.Created.Add(ttl)
+		paste.ExpiresAt = &expiresAt
 	}
 
-	filePath := filepath.Join("code", lang, hash+ext)
+	files := make([]PasteFile, len(req.Files))
+	codes := make([]string, len(req.Files))
+	names := make(map[string]bool)
+	for i := range req.Files {
+		input := &req.Files[i]
+		if input.Content == "" {
+			return nil, http.StatusBadRequest, errors.New("Code is empty")
+		}
+		name := ""
+		if input.Name != "" {
+			if name, err = cleanPasteFileName(input.Name); err != nil {
+				return nil, http.StatusBadRequest, errors.New("Invalid file name")
+			}
+		}
+		// the language of an encrypted paste is sealed with its code
+		lang := s.languages.Get(fallbackLanguage)
+		if !req.Encrypted {
+			lang = s.pasteLanguage(req, parent, input, name)
+		}
+		if lang == nil {
+			return nil, http.StatusBadRequest, errors.New("Language not supported")
+		}
+		if name == "" && len(req.Files) > 1 {
+			name = fmt.Sprintf("file%d%s", i+1, lang.Ext())
+		}
+		if names[name] {
+			return nil, http.StatusBadRequest, fmt.Errorf("Duplicate file name %s", name)
+		}
+		names[name] = true
+		files[i] = PasteFile{Name: name, Language: lang.Name, Size: int64(len(input.Content))}
+		codes[i] = input.Content
+		paste.Size += files[i].Size
+	}
+	if paste.Size > maxPasteSize {
+		return nil, http.StatusRequestEntityTooLarge, errors.New("Code is too large")
+	}
+	paste.Language = files[0].Language
+	if parent != nil {
+		paste.Parent = parent.ID
+	}
 
-	// Check if the file exists
-	meta, err := s.store.FileMeta(context.Background(), filePath)
-	if err != nil {
-		c.Logger().Errorf("Error checking file existence %s: %v", filePath, err)
-		return c.String(http.StatusInternalServerError, "Error checking file")
+	// the files of a protected paste are stored encrypted with the key of the password
+	var key []byte
+	if req.Password != "" {
+		err = s.derive(c.Request().Context(), func() (err error) {
+			paste.Password, key, err = newPastePassword(req.Password)
+			return err
+		})
+		if err != nil {
+			c.Logger().Errorf("Failed to protect the paste: %v", err)
+			return nil, http.StatusInternalServerError, errors.New("Failed to save code")
+		}
+	}
+
+	// an identical paste is reused if the dedup is enabled, the protected and encrypted pastes never are
+	ctx := uploadContext(c)
+	hash := ""
+	if s.cfg.Code.Dedup && paste.Password == nil && !paste.Encrypted {
+		hash = pasteHash(paste, files, fork, codes)
+		duplicate, err := s.duplicatePaste(ctx, hash, paste)
+		if err != nil {
+			c.Logger().Errorf("Failed to look up the paste of hash %s: %v", hash, err)
+			return nil, http.StatusInternalServerError, errors.New("Failed to save code")
+		}
+		if duplicate != nil {
+			return s.newPasteResult(c, duplicate), http.StatusOK, nil
+		}
+	}
+
+	keys := func(id string) []string {
+		if len(files) == 1 {
+			return []string{pasteKey(paste.Language, id, s.languages.Get(paste.Language).Ext())}
+		}
+		keys := make([]string, len(files))
+		for i := range files {
+			keys[i] = pasteFileKey(id, files[i].Name)
+		}
+		return keys
+	}
+	if paste.ID, err = s.newPasteID(ctx, keys); err != nil {
+		c.Logger().Errorf("Failed to generate the paste id: %v", err)
+		return nil, http.StatusInternalServerError, errors.New("Failed to save code")
+	}
+	for i, key := range keys(paste.ID) {
+		files[i].Key = key
+	}
+	paste.Key = files[0].Key
+	// a single file is only listed if it is named
+	if len(files) > 1 || files[0].Name != "" {
+		paste.Files = files
+	}
+	paste.Root, paste.Revision = paste.ID, 1
+	if parent != nil && !fork {
+		paste.Root, paste.Revision = parent.ChainRoot(), parent.Rev()+1
+	}
+
+	// Upload the files to the store
+	for i, file := range files {
+		content := []byte(req.Files[i].Content)
+		if key != nil {
+			if content, err = encryptCode(key, req.Files[i].Content); err != nil {
+				c.Logger().Errorf("Failed to encrypt code %s: %v", file.Key, err)
+				s.deletePasteFiles(ctx, files[:i])
+				return nil, http.StatusInternalServerError, errors.New("Failed to save code")
+			}
+		}
+		if err := store.UploadFileWithMetadata(ctx, s.store, bytes.NewReader(content), file.Key, pasteMetadata(paste)); err != nil {
+			c.Logger().Errorf("Failed to save code %s: %v", file.Key, err)
+			s.deletePasteFiles(ctx, files[:i])
+			return nil, http.StatusInternalServerError, errors.New("Failed to save code")
+		}
+	}
+	if err := s.savePasteRecord(ctx, paste); err != nil {
+		c.Logger().Errorf("Failed to save the record of %s: %v", paste.Key, err)
+		s.deletePasteFiles(ctx, files)
+		return nil, http.StatusInternalServerError, errors.New("Failed to save code")
+	}
+	if hash != "" {
+		if err := s.savePasteHash(ctx, hash, paste); err != nil {
+			c.Logger().Errorf("Failed to save the hash of %s: %v", paste.ID, err)
+		}
+	}
+	s.indexPaste(paste, codes)
+
+	result := s.newPasteResult(c, paste)
+
+	event := newEvent(c, webhook.EventPasteCreate, paste.Key)
+	event.Size = paste.Size
+	event.URL = result.URL
+	// the code directories are not served by /download
+	event.DownloadURL = result.RawURL
+	s.hooks.Notify(event)
+
+	return result, http.StatusOK, nil
+}
+
+// deletePasteFiles removes the files of a paste which failed to be saved
+func (s *CodeServer) deletePasteFiles(ctx context.Context, files []PasteFile) {
+	for _, file := range files {
+		_ = s.store.DeleteFile(ctx, file.Key)
 	}
+}
 
-	if meta == nil {
-		return c.String(http.StatusNotFound, "Code not found")
+// handleCodeShow retrieves and displays the uploaded code, the file query selects the file of a multi-file
+// paste which is shown in its tab
+func (s *CodeServer) handleCodeShow(c echo.Context) error {
+	paste, ok, err := s.findPaste(c)
+	if !ok {
+		return err
+	}
+	s.notifyAccess(c, paste)
+	file, ok, err := findPasteFile(c, paste)
+	if !ok {
+		return err
 	}
 
 	// Download the file content
-	buffer := bytes.NewBuffer(nil)
-	if err := s.store.DownloadFile(context.Background(), buffer, filePath); err != nil {
-		c.Logger().Errorf("Failed to download code %s: %v", filePath, err)
+	code, err := s.readCode(c, paste, file)
+	if err != nil {
+		c.Logger().Errorf("Failed to download code %s: %v", file.Key, err)
 		return c.String(http.StatusInternalServerError, "Failed to download code")
 	}
+	if paste.Encrypted {
+		return showEncryptedPaste(c, paste, code)
+	}
+
+	// the language can be overridden to highlight the code differently, the paste is not changed
+	language := s.fileLanguage(file)
+	if override := s.languages.Lookup(c.QueryParam("lang")); override != nil {
+		language = override
+	}
+
+	// curl gets the code colored for the terminal, the others the highlighted page
+	format := pasteFormat(c)
+	c.Response().Header().Add("Vary", "User-Agent")
+	if format == formatANSI {
+		files := []PasteFile{*file}
+		if c.QueryParam("file") == "" {
+			files = paste.PasteFiles()
+		}
+		colored := bytes.NewBuffer(nil)
+		if err := s.colorFiles(c, colored, paste, files, file, language, code); err != nil {
+			c.Logger().Errorf("Failed to highlight code %s: %v", paste.Key, err)
+			return c.String(http.StatusInternalServerError, "Failed to highlight code")
+		}
+		return c.Blob(http.StatusOK, "text/plain; charset=utf-8", colored.Bytes())
+	}
 
 	data := map[string]interface{}{
-		"Code":     buffer.String(),
-		"Language": lang,
+		"Language":    language,
+		"Paste":       paste,
+		"Files":       paste.PasteFiles(),
+		"File":        file,
+		"Languages":   s.languages.Languages(),
+		"Description": pasteDescription(code),
+	}
+	revisions, err := s.pasteRevisions(context.Background(), paste, requestOwner(c))
+	if err != nil {
+		c.Logger().Errorf("Error listing the revisions of %s: %v", paste.ID, err)
+		return c.String(http.StatusInternalServerError, "Error listing the revisions")
+	}
+	data["Revisions"] = revisions
+	// a fork does not link its parent if it is private to somebody else
+	if paste.Parent != "" {
+		parent, err := s.loadPasteByID(context.Background(), paste.Parent)
+		if err != nil {
+			c.Logger().Errorf("Error loading the paste %s: %v", paste.Parent, err)
+		}
+		if parent != nil && parent.VisibleTo(requestOwner(c)) {
+			data["Parent"] = parent
+		}
+	}
+	if language.Name == markdownLanguage && format != formatSource {
+		rendered, err := renderMarkdown(code)
+		if err != nil {
+			c.Logger().Errorf("Failed to render code %s: %v", file.Key, err)
+			return c.String(http.StatusInternalServerError, "Failed to render code")
+		}
+		data["Rendered"] = rendered
+	} else {
+		highlighted, err := highlightHTML(language, code)
+		if err != nil {
+			c.Logger().Errorf("Failed to highlight code %s: %v", file.Key, err)
+			return c.String(http.StatusInternalServerError, "Failed to highlight code")
+		}
+		data["Highlighted"] = highlighted
 	}
 
 	return c.Render(http.StatusOK, "codeshow.html", data)
 }
 
-// shortHash generates a short MD5 hash from the given code string
-func shortHash(code string) string {
-	hash := md5.Sum([]byte(code))
-	hexString := hex.EncodeToString(hash[:])
-	return hexString[:8]
+// fileLanguage returns the language of the file, the fallback language if it was removed from the registry
+func (s *CodeServer) fileLanguage(file *PasteFile) *Language {
+	if language := s.languages.Get(file.Language); language != nil {
+		return language
+	}
+	return s.languages.Get(fallbackLanguage)
+}
+
+// colorFiles writes the files colored for the terminal, every file of a multi-file paste under a header
+// with its name. The code of the shown file is downloaded already.
+func (s *CodeServer) colorFiles(c echo.Context, w io.Writer, paste *Paste, files []PasteFile, shown *PasteFile, language *Language, code string) error {
+	if len(files) == 1 {
+		return highlightANSI(w, language, code)
+	}
+	for i := range files {
+		file := &files[i]
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "\x1b[1m==> %s <==\x1b[0m\n", file.Name)
+		fileCode, fileLanguage := code, language
+		if file.Key != shown.Key {
+			var err error
+			if fileCode, err = s.readCode(c, paste, file); err != nil {
+				return err
+			}
+			fileLanguage = s.fileLanguage(file)
+		}
+		if err := highlightANSI(w, fileLanguage, fileCode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyAccess posts the access of the shared link of the paste
+func (s *CodeServer) notifyAccess(c echo.Context, paste *Paste) {
+	event := newEvent(c, webhook.EventShareAccess, paste.Key)
+	event.Size = paste.Size
+	event.URL = getUrl(c.Request().Host, paste.Path(), s.cfg.EnableTls)
+	event.DownloadURL = event.URL + "/raw"
+	s.hooks.Notify(event)
+}
+
+// handleCodeRaw returns a file of the uploaded code as plain text, the first one if the file query is missing
+func (s *CodeServer) handleCodeRaw(c echo.Context) error {
+	paste, ok, err := s.findPaste(c)
+	if !ok {
+		return err
+	}
+	s.notifyAccess(c, paste)
+	file, ok, err := findPasteFile(c, paste)
+	if !ok {
+		return err
+	}
+
+	c.Response().Header().Set("Content-Type", "text/plain; charset=utf-8")
+	c.Response().Header().Set("Content-Length", fmt.Sprintf("%d", file.Size))
+	c.Response().WriteHeader(http.StatusOK)
+	if err := s.copyCode(c, throttleWriter(c, c.Response().Writer), paste, file); err != nil {
+		c.Logger().Errorf("Failed to download code %s: %v", file.Key, err)
+		return err
+	}
+	return nil
+}
+
+// handleCodeZip downloads the files of the paste as a zip archive
+func (s *CodeServer) handleCodeZip(c echo.Context) error {
+	paste, ok, err := s.findPaste(c)
+	if !ok {
+		return err
+	}
+	s.notifyAccess(c, paste)
+
+	c.Response().Header().Set("Content-Type", "application/zip")
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, paste.ID))
+	c.Response().WriteHeader(http.StatusOK)
+	archive := zip.NewWriter(throttleWriter(c, c.Response().Writer))
+	for _, file := range paste.PasteFiles() {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: file.Name, Method: zip.Deflate, Modified: paste.Created})
+		if err != nil {
+			return err
+		}
+		if err := s.copyCode(c, w, paste, &file); err != nil {
+			c.Logger().Errorf("Failed to download code %s: %v", file.Key, err)
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// findPasteFile returns the file of the file query, if ok is false the request is answered already
+func findPasteFile(c echo.Context, paste *Paste) (file *PasteFile, ok bool, err error) {
+	file = paste.File(c.QueryParam("file"))
+	if file == nil {
+		return nil, false, c.String(http.StatusNotFound, "File not found")
+	}
+	return file, true, nil
+}
+
+// findPaste loads the paste of the request, if ok is false the request is answered already, e.g. a protected
+// paste which is not unlocked asks for its password
+func (s *CodeServer) findPaste(c echo.Context) (paste *Paste, ok bool, err error) {
+	lang := c.Param("lang")
+	hash := c.Param("hash")
+
+	paste, err = s.loadPaste(context.Background(), lang, hash)
+	if err != nil {
+		c.Logger().Errorf("Error loading the paste %s: %v", hash, err)
+		return nil, false, c.String(http.StatusInternalServerError, "Error checking file")
+	}
+	if paste == nil {
+		return nil, false, c.String(http.StatusNotFound, "Code not found")
+	}
+	if !s.unlockPaste(c, paste) {
+		return nil, false, lockedPaste(c, paste)
+	}
+	return paste, true, nil
 }
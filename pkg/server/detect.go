@@ -0,0 +1,165 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// autoLanguage asks the server to detect the language of a paste
+const autoLanguage = "auto"
+
+// fallbackLanguage is used when the language cannot be detected
+const fallbackLanguage = "text"
+
+// detectSize is how much of the code the modelines and the classifier look at
+const detectSize = 64 << 10
+
+var (
+	vimModeline   = regexp.MustCompile(`(?:vi|vim|ex):.*?\b(?:ft|filetype|syntax)=([\w+-]+)`)
+	emacsModeline = regexp.MustCompile(`-\*-\s*(?:.*?mode:\s*)?([\w+-]+)\s*(?:;.*?)?-\*-`)
+)
+
+// languageRule is a pattern of the classifier, the language with the highest sum of matching weights wins
+type languageRule struct {
+	language string
+	pattern  *regexp.Regexp
+	weight   int
+}
+
+var languageRules = []languageRule{
+	{"go", regexp.MustCompile(`(?m)^package \w+\s*$`), 3},
+	{"go", regexp.MustCompile(`(?m)^func (\(\w+ \*?\w+\) )?\w+\(`), 3},
+	{"go", regexp.MustCompile(`\w+ := `), 1},
+	{"go", regexp.MustCompile(`\bfmt\.\w+\(`), 2},
+	{"python", regexp.MustCompile(`(?m)^\s*def \w+\(.*\)( -> .+)?:\s*$`), 3},
+	{"python", regexp.MustCompile(`(?m)^(from [\w.]+ )?import [\w.]+( as \w+)?\s*$`), 1},
+	{"python", regexp.MustCompile(`\bself\.\w+`), 2},
+	{"python", regexp.MustCompile(`(?m)^\s*(elif .*|else|try|except.*|class \w+.*):\s*$`), 2},
+	{"python", regexp.MustCompile(`(?m)^if __name__ == ['"]__main__['"]:`), 3},
+	{"bash", regexp.MustCompile(`(?m)^\s*(fi|done|esac|then)\s*$`), 3},
+	{"bash", regexp.MustCompile(`\$\{\w+[^}]*\}|\$\(\w`), 1},
+	{"bash", regexp.MustCompile(`(?m)^\s*(echo|export|set -\w+|source) `), 2},
+	{"c", regexp.MustCompile(`#include <\w+\.h>`), 3},
+	{"c", regexp.MustCompile(`\b(printf|malloc|free|sizeof)\(`), 1},
+	{"c", regexp.MustCompile(`(?m)^(int|void|char|static \w+) \*?\w+\(.*\)\s*\{?\s*$`), 1},
+	{"cpp", regexp.MustCompile(`#include <(iostream|vector|string|map|memory|algorithm)>`), 4},
+	{"cpp", regexp.MustCompile(`\bstd::\w+`), 3},
+	{"cpp", regexp.MustCompile(`(?m)^\s*(template\s*<|namespace \w+|using namespace )`), 3},
+	{"java", regexp.MustCompile(`\bpublic (final )?(class|interface|enum) \w+`), 3},
+	{"java", regexp.MustCompile(`\bSystem\.(out|err)\.print`), 3},
+	{"java", regexp.MustCompile(`(?m)^import java\.`), 3},
+	{"java", regexp.MustCompile(`@Override\b`), 2},
+	{"javascript", regexp.MustCompile(`\bconsole\.log\(`), 3},
+	{"javascript", regexp.MustCompile(`(?m)^\s*(const|let|var) \w+ = `), 2},
+	{"javascript", regexp.MustCompile(`\bfunction\s*\w*\s*\(`), 2},
+	{"javascript", regexp.MustCompile(`\brequire\(['"]|\bexport (default|const|function)\b`), 2},
+	{"javascript", regexp.MustCompile(`\) => \{?`), 1},
+	{"rust", regexp.MustCompile(`(?m)^\s*(pub )?fn \w+(<.*>)?\(`), 3},
+	{"rust", regexp.MustCompile(`\blet mut \w+`), 3},
+	{"rust", regexp.MustCompile(`\b(println|vec|format)!\(`), 3},
+	{"rust", regexp.MustCompile(`(?m)^\s*(use std::|impl\b|#\[derive\()`), 3},
+	{"php", regexp.MustCompile(`<\?php`), 6},
+	{"php", regexp.MustCompile(`\$\w+\s*=\s*.+;`), 1},
+	{"html", regexp.MustCompile(`(?i)<!DOCTYPE html>|<html[\s>]`), 6},
+	{"html", regexp.MustCompile(`(?i)<(div|body|head|span|script|a href)[\s>]`), 2},
+	{"xml", regexp.MustCompile(`^\s*<\?xml `), 6},
+	{"yaml", regexp.MustCompile(`(?m)^---\s*$`), 2},
+	{"yaml", regexp.MustCompile(`(?m)^[\w-]+:( [^{;]*)?$`), 1},
+	{"yaml", regexp.MustCompile(`(?m)^\s+- [\w"']`), 1},
+}
+
+// detectLanguage detects the language of the code from the file name hint, the shebang, a vim or emacs
+// modeline and a classifier of the content, in this order
+func (r *LanguageRegistry) detectLanguage(filename, code string) string {
+	if l := r.ByFilename(filename); l != nil {
+		return l.Name
+	}
+	head := code[:min(len(code), detectSize)]
+	if language := r.languageOfShebang(head); language != "" {
+		return language
+	}
+	if language := r.languageOfModeline(head); language != "" {
+		return language
+	}
+	trimmed := bytes.TrimSpace([]byte(head))
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid([]byte(code)) && r.Get("json") != nil {
+		return "json"
+	}
+	if l := r.Get(classifyLanguage(head)); l != nil {
+		return l.Name
+	}
+	return fallbackLanguage
+}
+
+// lookupName returns the name of the language of a name or an alias, empty if it is unknown
+func (r *LanguageRegistry) lookupName(name string) string {
+	if l := r.Lookup(name); l != nil {
+		return l.Name
+	}
+	return ""
+}
+
+func (r *LanguageRegistry) languageOfShebang(code string) string {
+	line, ok := strings.CutPrefix(strings.SplitN(code, "\n", 2)[0], "#!")
+	if !ok {
+		return ""
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	interpreter := path.Base(fields[0])
+	if interpreter == "env" {
+		// #!/usr/bin/env -S python3 -u
+		interpreter = ""
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "-") {
+				interpreter = field
+				break
+			}
+		}
+	}
+	return r.lookupName(strings.TrimRight(interpreter, "0123456789."))
+}
+
+// languageOfModeline looks for a modeline in the first and the last lines
+func (r *LanguageRegistry) languageOfModeline(code string) string {
+	lines := strings.Split(code, "\n")
+	if len(lines) > 10 {
+		lines = append(lines[:5], lines[len(lines)-5:]...)
+	}
+	for _, line := range lines {
+		for _, re := range []*regexp.Regexp{vimModeline, emacsModeline} {
+			if m := re.FindStringSubmatch(line); m != nil {
+				if language := r.lookupName(m[1]); language != "" {
+					return language
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// minClassifierScore keeps a few loose matches, e.g. a "Note: " line looking like yaml, from deciding the language
+const minClassifierScore = 2
+
+func classifyLanguage(code string) string {
+	scores := make(map[string]int)
+	best, bestScore := fallbackLanguage, 0
+	for _, rule := range languageRules {
+		if !rule.pattern.MatchString(code) {
+			continue
+		}
+		scores[rule.language] += rule.weight
+		if score := scores[rule.language]; score > bestScore || (score == bestScore && rule.language < best) {
+			best, bestScore = rule.language, score
+		}
+	}
+	if bestScore < minClassifierScore {
+		return fallbackLanguage
+	}
+	return best
+}
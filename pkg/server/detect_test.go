@@ -0,0 +1,42 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_detectLanguage(t *testing.T) {
+	languages, err := NewLanguageRegistry(defaultLanguages)
+	assert.NoError(t, err)
+	cases := []struct {
+		name     string
+		filename string
+		code     string
+		want     string
+	}{
+		{"file name", "main.rs", "print", "rust"},
+		{"file name alias", "include/util.h", "", "c"},
+		{"file base name", "build/Dockerfile", "FROM alpine", "dockerfile"},
+		{"shebang", "", "#!/bin/sh\nls", "bash"},
+		{"env shebang", "", "#!/usr/bin/env -S python3 -u\nprint(1)", "python"},
+		{"vim modeline", "", "x = 1\n# vim: set ft=yml:\n", "yaml"},
+		{"emacs modeline", "", "// -*- mode: c++; indent-tabs-mode: nil -*-\nint x;", "cpp"},
+		{"json", "", ` {"a": [1, 2]}`, "json"},
+		{"go", "", "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(1)\n}\n", "go"},
+		{"python", "", "import os\n\ndef main():\n    print(os.getcwd())\n\nif __name__ == '__main__':\n    main()\n", "python"},
+		{"bash", "", "for f in *.txt; do\n  echo \"$f\"\ndone\n", "bash"},
+		{"cpp", "", "#include <iostream>\nint main() { std::cout << 1; }\n", "cpp"},
+		{"java", "", "public class Main {\n  public static void main(String[] args) {\n    System.out.println(1);\n  }\n}\n", "java"},
+		{"javascript", "", "const x = require('x');\nconsole.log(x);\n", "javascript"},
+		{"rust", "", "fn main() {\n    let mut v = vec![1];\n    println!(\"{:?}\", v);\n}\n", "rust"},
+		{"php", "", "<?php echo 1;", "php"},
+		{"html", "", "<!DOCTYPE html>\n<html><body></body></html>", "html"},
+		{"xml", "", "<?xml version=\"1.0\"?>\n<a/>", "xml"},
+		{"yaml", "", "---\nname: app\nitems:\n  - one\n", "yaml"},
+		{"plain text", "", "Note: the build failed again.\n", "text"},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, languages.detectLanguage(c.filename, c.code), c.name)
+	}
+}
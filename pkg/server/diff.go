@@ -0,0 +1,274 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	diffEqual  = ' '
+	diffDelete = '-'
+	diffInsert = '+'
+)
+
+// maxDiffEdits bounds the work of the diff, the lines are replaced as a whole when they differ more
+const maxDiffEdits = 2000
+
+// diffContext is the count of unchanged lines around the changes of a hunk
+const diffContext = 3
+
+// diffLine is a line of a diff, OldLine and NewLine are the 1-based line numbers, 0 if the line is missing
+type diffLine struct {
+	Op      byte
+	Text    string
+	OldLine int
+	NewLine int
+}
+
+// Sign is the prefix of the line in a unified diff
+func (l diffLine) Sign() string {
+	return string(l.Op)
+}
+
+// Content is the text of the line without the line break
+func (l diffLine) Content() string {
+	return strings.TrimSuffix(l.Text, "\n")
+}
+
+// Class is the CSS class of the line
+func (l diffLine) Class() string {
+	switch l.Op {
+	case diffDelete:
+		return "diff-delete"
+	case diffInsert:
+		return "diff-insert"
+	}
+	return ""
+}
+
+// diffRow is a row of the side-by-side view, a side is nil if the line is missing
+type diffRow struct {
+	Old *diffLine
+	New *diffLine
+}
+
+// diffHunk is a group of changes with their context
+type diffHunk struct {
+	Header string
+	Lines  []diffLine
+}
+
+// splitLines splits the text after the line breaks, a missing break at the end is added
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(text, "\n")+"\n", "\n")
+	return lines[:len(lines)-1]
+}
+
+// diffLines compares the lines of a and b with the Myers algorithm
+func diffLines(a, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var lines []diffLine
+	for i := 0; i < prefix; i++ {
+		lines = append(lines, diffLine{Op: diffEqual, Text: a[i], OldLine: i + 1, NewLine: i + 1})
+	}
+	for _, l := range myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		if l.OldLine > 0 {
+			l.OldLine += prefix
+		}
+		if l.NewLine > 0 {
+			l.NewLine += prefix
+		}
+		lines = append(lines, l)
+	}
+	for i := suffix; i > 0; i-- {
+		lines = append(lines, diffLine{Op: diffEqual, Text: a[len(a)-i], OldLine: len(a) - i + 1, NewLine: len(b) - i + 1})
+	}
+	return lines
+}
+
+func myers(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxDiffEdits)
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	// trace keeps the furthest x of every diagonal k in [-d, d] after the step d
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		done := false
+		for k := -d; k <= d && !done; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			done = x >= n && y >= m
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		if done {
+			return backtrack(a, b, trace)
+		}
+	}
+
+	// too many changes, replace all lines
+	var lines []diffLine
+	for i, line := range a {
+		lines = append(lines, diffLine{Op: diffDelete, Text: line, OldLine: i + 1})
+	}
+	for i, line := range b {
+		lines = append(lines, diffLine{Op: diffInsert, Text: line, NewLine: i + 1})
+	}
+	return lines
+}
+
+func backtrack(a, b []string, trace [][]int) []diffLine {
+	var lines []diffLine
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		k := x - y
+		prevX, prevY := 0, 0
+		if d > 0 {
+			prev := trace[d-1]
+			at := func(k int) int { return prev[k+d-1] }
+			prevK := k - 1
+			if k == -d || (k != d && at(k-1) < at(k+1)) {
+				prevK = k + 1
+			}
+			prevX = at(prevK)
+			prevY = prevX - prevK
+		}
+		for x > prevX && y > prevY {
+			x--
+			y--
+			lines = append(lines, diffLine{Op: diffEqual, Text: a[x], OldLine: x + 1, NewLine: y + 1})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			y--
+			lines = append(lines, diffLine{Op: diffInsert, Text: b[y], NewLine: y + 1})
+		} else {
+			x--
+			lines = append(lines, diffLine{Op: diffDelete, Text: a[x], OldLine: x + 1})
+		}
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	return lines
+}
+
+// diffHunks groups the changes of the lines with their context
+func diffHunks(lines []diffLine) []diffHunk {
+	var hunks []diffHunk
+	for i := 0; i < len(lines); {
+		if lines[i].Op == diffEqual {
+			i++
+			continue
+		}
+		start := max(i-diffContext, 0)
+		// extend the hunk while the next change is close enough to share the context
+		end := i
+		for j := i; j < len(lines); j++ {
+			if lines[j].Op != diffEqual {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		end = min(end+diffContext, len(lines))
+		hunks = append(hunks, diffHunk{Header: hunkHeader(lines, start, end), Lines: lines[start:end]})
+		i = end
+	}
+	return hunks
+}
+
+func hunkHeader(lines []diffLine, start, end int) string {
+	count := func(lines []diffLine, line func(diffLine) int) int {
+		n := 0
+		for _, l := range lines {
+			if line(l) > 0 {
+				n++
+			}
+		}
+		return n
+	}
+	oldLine := func(l diffLine) int { return l.OldLine }
+	newLine := func(l diffLine) int { return l.NewLine }
+	oldBefore, oldCount := count(lines[:start], oldLine), count(lines[start:end], oldLine)
+	newBefore, newCount := count(lines[:start], newLine), count(lines[start:end], newLine)
+	// an empty range starts at the line before it
+	oldStart, newStart := oldBefore, newBefore
+	if oldCount > 0 {
+		oldStart++
+	}
+	if newCount > 0 {
+		newStart++
+	}
+	return fmt.Sprintf("@@ -%d,%d +%d,%d @@", oldStart, oldCount, newStart, newCount)
+}
+
+// unifiedDiff formats the lines like diff -u
+func unifiedDiff(oldName, newName string, lines []diffLine) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	for _, hunk := range diffHunks(lines) {
+		sb.WriteString(hunk.Header + "\n")
+		for _, l := range hunk.Lines {
+			sb.WriteByte(l.Op)
+			sb.WriteString(l.Text)
+			if !strings.HasSuffix(l.Text, "\n") {
+				sb.WriteString("\n")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// sideBySide pairs the deleted lines with the inserted lines which follow them
+func sideBySide(lines []diffLine) []diffRow {
+	var rows []diffRow
+	for i := 0; i < len(lines); {
+		if lines[i].Op == diffEqual {
+			rows = append(rows, diffRow{Old: &lines[i], New: &lines[i]})
+			i++
+			continue
+		}
+		var deleted, inserted []*diffLine
+		for ; i < len(lines) && lines[i].Op == diffDelete; i++ {
+			deleted = append(deleted, &lines[i])
+		}
+		for ; i < len(lines) && lines[i].Op == diffInsert; i++ {
+			inserted = append(inserted, &lines[i])
+		}
+		for j := 0; j < max(len(deleted), len(inserted)); j++ {
+			row := diffRow{}
+			if j < len(deleted) {
+				row.Old = deleted[j]
+			}
+			if j < len(inserted) {
+				row.New = inserted[j]
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_diffLines(t *testing.T) {
+	old := splitLines("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n")
+	new := splitLines("a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn")
+	lines := diffLines(old, new)
+
+	// applying the diff to the old lines gives the new lines
+	var gotOld, gotNew []string
+	for _, l := range lines {
+		if l.Op != diffInsert {
+			gotOld = append(gotOld, l.Text)
+			assert.Equal(t, len(gotOld), l.OldLine)
+		}
+		if l.Op != diffDelete {
+			gotNew = append(gotNew, l.Text)
+			assert.Equal(t, len(gotNew), l.NewLine)
+		}
+	}
+	assert.Equal(t, old, gotOld)
+	assert.Equal(t, new, gotNew)
+
+	assert.Equal(t, `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -11,3 +11,4 @@
+ k
+ l
+ m
++n
+`, unifiedDiff("old", "new", lines))
+
+	rows := sideBySide(lines)
+	assert.Len(t, rows, 14)
+	assert.Equal(t, "b\n", rows[1].Old.Text)
+	assert.Equal(t, "B\n", rows[1].New.Text)
+	assert.Nil(t, rows[13].Old)
+
+	assert.Equal(t, "--- a\n+++ b\n", unifiedDiff("a", "b", diffLines(old, old)))
+	assert.Equal(t, "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+x\n+y\n", unifiedDiff("a", "b", diffLines(nil, splitLines("x\ny\n"))))
+	assert.Equal(t, "--- a\n+++ b\n@@ -1,1 +0,0 @@\n-x\n", unifiedDiff("a", "b", diffLines(splitLines("x"), nil)))
+}
+
+func Test_myers(t *testing.T) {
+	// the shortest edit script of the classic example has 5 edits
+	lines := myers(strings.Split("ABCABBA", ""), strings.Split("CBABAC", ""))
+	edits := 0
+	for _, l := range lines {
+		if l.Op != diffEqual {
+			edits++
+		}
+	}
+	assert.Equal(t, 5, edits)
+
+	// too many changes replace all lines
+	var a, b []string
+	for i := 0; i < maxDiffEdits; i++ {
+		a = append(a, fmt.Sprintf("a%d\n", i))
+		b = append(b, fmt.Sprintf("b%d\n", i))
+	}
+	lines = myers(a, b)
+	assert.Len(t, lines, 2*maxDiffEdits)
+	assert.Equal(t, byte(diffDelete), lines[0].Op)
+	assert.Equal(t, byte(diffInsert), lines[maxDiffEdits].Op)
+}
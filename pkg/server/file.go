@@ -2,43 +2,62 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
-	"time"
 
 	"github.com/graydovee/fileManager/pkg/config"
 	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/webhook"
 	"github.com/labstack/echo/v4"
 )
 
 const downloadPath = "download"
 
 type FilerServer struct {
-	cfg   *config.Config
-	store store.Store
+	cfg       *config.Config
+	store     store.Store
+	retention *store.Retention
+	naming    NamingStrategy
+	hooks     *webhook.Dispatcher
+
+	uploadLocks pathLocker
 }
 
-func NewFileServer(cfg *config.Config, st store.Store) *FilerServer {
+func NewFileServer(cfg *config.Config, st store.Store, hooks *webhook.Dispatcher) *FilerServer {
 	return &FilerServer{
 		cfg:   cfg,
 		store: st,
+		hooks: hooks,
 	}
 }
 
 func (f *FilerServer) Setup(e *echo.Echo) error {
+	naming, err := NewNamingStrategy(f.cfg.Upload.Naming)
+	if err != nil {
+		return err
+	}
+	f.naming = naming
+
 	e.GET("/", f.handleHelpPage)
 	e.POST("/upload", f.uploadFileHandlerByForm)
 	e.PUT("/upload", f.uploadFileHandlerByStream)
+	e.PUT("/upload/*", f.uploadFileHandlerByStream)
 	e.GET(fmt.Sprintf("/%s", downloadPath), f.downloadFileHandler)
 	e.GET(fmt.Sprintf("/%s/", downloadPath), f.downloadFileHandler)
 	e.GET(fmt.Sprintf("/%s/*", downloadPath), f.downloadFileHandler)
 	e.DELETE("/delete/*", f.deleteFileHandler)
+	e.GET("/stats", f.statsHandler)
+	e.POST("/restore/*", f.restoreFileHandler)
+	f.setupTrash(e)
+	f.setupQuota(e)
 
-	return nil
+	return f.setupRetention()
 }
 
 func (f *FilerServer) handleHelpPage(c echo.Context) error {
@@ -58,50 +77,70 @@ func (f *FilerServer) uploadFileHandlerByForm(c echo.Context) error {
 		return c.String(http.StatusBadRequest, "Unsupported content type")
 	}
 
-	// Handle form file upload
-	formFile, header, err := c.Request().FormFile("file")
-	if err != nil {
-		c.Logger().Errorf("Error retrieving the file: %s", err.Error())
-		return c.String(http.StatusInternalServerError, "Error retrieving the file")
+	limit, ok := f.limitUpload(c)
+	if !ok {
+		return f.uploadTooLarge(c)
 	}
-	defer formFile.Close()
 
-	return f.saveFile(formFile, header.Filename, c)
+	// Handle form file upload, the parts are streamed to the store as they arrive
+	return f.saveForm(c, limit)
 }
 
 func (f *FilerServer) uploadFileHandlerByStream(c echo.Context) error {
 	c.Logger().Printf("File uploaded by stream")
 
 	// Handle direct file upload
-	file := c.Request().Body
 	fileName := c.Request().Header.Get("X-Filename")
-	if fileName == "" {
+	if fileName == "" && c.Param("*") == "" && c.Request().Header.Get(pathHeader) == "" {
 		c.Logger().Errorf("Error: X-Filename header is missing")
 		return c.String(http.StatusBadRequest, "X-Filename header is missing")
 	}
 
-	return f.saveFile(file, fileName, c)
+	limit, ok := f.limitUpload(c)
+	if !ok {
+		return f.uploadTooLarge(c)
+	}
+
+	return f.saveFile(limit, fileName, c)
 }
 
-func (f *FilerServer) saveFile(file io.ReadCloser, filename string, c echo.Context) error {
-	// Generate unique filename using timestamp and original filename
-	newFileName := strings.ReplaceAll(GetTimeStamp(), "-", "") + "-" + filename
+func (f *FilerServer) saveFile(file *uploadLimiter, filename string, c echo.Context) error {
+	opts, err := f.parseUploadOptions(c)
+	if err != nil {
+		c.Logger().Errorf("Error parsing the upload options: %s", err.Error())
+		return c.String(http.StatusBadRequest, err.Error())
+	}
 
-	// Create directory structure based on current year and month
-	now := time.Now()
-	yearMonthPath := fmt.Sprintf("%d/%02d", now.Year(), now.Month())
-	filePath := fmt.Sprintf("%s/%s", yearMonthPath, newFileName)
+	name, filePath, err := newUploadPaths(opts).resolve(filename, "")
+	if err != nil {
+		c.Logger().Errorf("Error parsing the upload path: %s", err.Error())
+		return c.String(http.StatusBadRequest, err.Error())
+	}
 
-	// Upload to Store
-	err := f.store.UploadFile(context.Background(), file, filePath)
+	expected, err := parseDigests(c.Request().Header)
 	if err != nil {
-		c.Logger().Errorf("Error uploading the file %s to store: %s", filename, err.Error())
-		return c.String(http.StatusInternalServerError, "Error uploading the file to the store")
+		c.Logger().Errorf("Error parsing the checksums: %s", err.Error())
+		return c.String(http.StatusBadRequest, err.Error())
 	}
 
-	c.Logger().Printf("File %s uploaded successfully to store: %s", filename, filePath)
+	result := f.putFile(c, opts, file, name, filePath, expected)
+	if file.exceeded {
+		return f.uploadTooLarge(c)
+	}
+	return f.uploadResponse(c, opts, result)
+}
+
+// uploadResponse answers an upload of a single file with the download commands
+func (f *FilerServer) uploadResponse(c echo.Context, opts *uploadOptions, result *uploadResult) error {
+	if result.Error != "" {
+		return c.String(result.Status, result.Error)
+	}
+	filePath, filename := result.Path, path.Base(result.Name)
+	if result.ETag != "" {
+		c.Response().Header().Set("ETag", result.ETag)
+	}
 
-	downloadUrl := getDownloadUrl(c.Request().Host, EscapeUrlPath(filePath), f.cfg.EnableTls)
+	downloadUrl := result.URL
 
 	// External download command
 	respData := fmt.Sprintf(`
@@ -120,6 +159,18 @@ Internal download command:
 `, internalDownloadUrl, escapeFileName(filename))
 	}
 
+	if result.SHA256 != "" {
+		respData += fmt.Sprintf(`
+SHA-256: %s
+`, result.SHA256)
+	}
+
+	if expiresAt, ok := opts.metadata[store.MetaExpiresAt]; ok {
+		respData += fmt.Sprintf(`
+Expires at: %s
+`, expiresAt)
+	}
+
 	return c.String(http.StatusOK, respData)
 }
 
@@ -128,6 +179,16 @@ func (f *FilerServer) downloadFileHandler(c echo.Context) error {
 
 	c.Logger().Printf("Download file: %s", file)
 
+	if reservedPath(file) {
+		return c.String(http.StatusNotFound, "File not found")
+	}
+	if _, ok := c.QueryParams()["versions"]; ok {
+		return f.listVersionsHandler(c, file)
+	}
+	if versionID := c.QueryParam("version"); versionID != "" {
+		return f.downloadVersionHandler(c, file, versionID)
+	}
+
 	meta, err := f.store.FileMeta(context.Background(), strings.TrimSuffix(file, "/"))
 	if err != nil {
 		c.Logger().Errorf("Error checking the file %s: %s", file, err.Error())
@@ -135,31 +196,74 @@ func (f *FilerServer) downloadFileHandler(c echo.Context) error {
 	}
 
 	if meta == nil {
+		if ok, err := f.checksumSidecar(c, file); ok || err != nil {
+			if err != nil {
+				c.Logger().Errorf("Error checking the file %s: %s", file, err.Error())
+				return c.String(http.StatusInternalServerError, "Error checking the file")
+			}
+			return nil
+		}
+
 		// file list page
 		fileMetas, err := f.store.List(context.Background(), file)
 		if err != nil {
 			c.Logger().Errorf("Error listing the file %s: %s", file, err.Error())
 			return c.String(http.StatusInternalServerError, "Error listing the file")
 		}
+		if len(fileMetas) == 0 && strings.Trim(file, "/") != "" {
+			return c.String(http.StatusNotFound, "File not found")
+		}
+		fileMetas = slices.DeleteFunc(fileMetas, func(meta *store.FileMeta) bool {
+			return reservedPath(path.Join(file, meta.Name))
+		})
 
 		return c.Render(http.StatusOK, "list.html", map[string]interface{}{
 			"DownloadEndpoint": getDownloadUrl(c.Request().Host, file, f.cfg.EnableTls),
 			"DeleteEndpoint":   getDeleteUrl(c.Request().Host, file, f.cfg.EnableTls),
 			"BasePath":         downloadPath,
 			"Files":            fileMetas,
+			"ExpiresAt":        f.expiresAt(file, fileMetas),
+			"TrashEnabled":     f.trashStore() != nil,
 		})
 	}
 
 	// download file
 
+	offset, length, partial, err := parseRange(c.Request().Header.Get("Range"), meta.Size)
+	if err != nil {
+		c.Response().Header().Set("Content-Range", fmt.Sprintf("bytes */%d", meta.Size))
+		return c.String(http.StatusRequestedRangeNotSatisfiable, "Invalid range")
+	}
+
 	// Set headers
 	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filepath.Base(file)))
 	c.Response().Header().Set("Content-Type", "application/octet-stream")
-	c.Response().Header().Set("Content-Length", fmt.Sprintf("%d", meta.Size))
-	c.Response().WriteHeader(http.StatusOK)
+	c.Response().Header().Set("Accept-Ranges", "bytes")
+	if meta.ETag != "" {
+		c.Response().Header().Set("ETag", meta.ETag)
+	}
+	setChecksumHeaders(c, meta)
+	if offset == 0 {
+		// the later ranges continue a download which is posted already
+		event := newEvent(c, webhook.EventShareAccess, file)
+		event.Size, event.SHA256 = meta.Size, meta.Metadata[store.MetaSHA256]
+		event.URL = getDownloadUrl(c.Request().Host, EscapeUrlPath(file), f.cfg.EnableTls)
+		event.DownloadURL = event.URL
+		f.hooks.Notify(event)
+	}
+	writer := throttleWriter(c, c.Response().Writer)
+	if partial {
+		c.Response().Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, meta.Size))
+		c.Response().Header().Set("Content-Length", fmt.Sprintf("%d", length))
+		c.Response().WriteHeader(http.StatusPartialContent)
+		err = store.DownloadRange(context.Background(), f.store, writer, file, offset, length)
+	} else {
+		c.Response().Header().Set("Content-Length", fmt.Sprintf("%d", meta.Size))
+		c.Response().WriteHeader(http.StatusOK)
 
-	// Stream the file
-	err = f.store.DownloadFile(context.Background(), c.Response().Writer, file)
+		// Stream the file
+		err = f.store.DownloadFile(context.Background(), writer, file)
+	}
 	if err != nil {
 		c.Logger().Errorf("Error downloading the file %s: %s", file, err.Error())
 		return err // You may choose to handle this differently
@@ -173,6 +277,17 @@ func (f *FilerServer) deleteFileHandler(c echo.Context) error {
 
 	c.Logger().Printf("Delete file: %s", file)
 
+	if reservedPath(file) {
+		return c.String(http.StatusForbidden, "Reserved path")
+	}
+
+	event := newEvent(c, webhook.EventDelete, file)
+	if f.hooks != nil {
+		if meta, err := f.store.FileMeta(context.Background(), file); err == nil && meta != nil {
+			event.Size, event.SHA256 = meta.Size, meta.Metadata[store.MetaSHA256]
+		}
+	}
+
 	err := f.store.DeleteFile(context.Background(), file)
 	if err != nil {
 		c.Logger().Errorf("Error deleting the file %s: %s", file, err.Error())
@@ -180,10 +295,100 @@ func (f *FilerServer) deleteFileHandler(c echo.Context) error {
 	}
 
 	c.Logger().Printf("File %s deleted successfully", file)
+	f.hooks.Notify(event)
 
 	return c.String(http.StatusOK, "File deleted successfully")
 }
 
+func (f *FilerServer) listVersionsHandler(c echo.Context, file string) error {
+	versionStore, ok := store.As[*store.VersionStore](f.store)
+	if !ok {
+		return c.String(http.StatusNotFound, "Versioning is not enabled")
+	}
+
+	versions, err := versionStore.ListVersions(context.Background(), file)
+	if err != nil {
+		c.Logger().Errorf("Error listing the versions of %s: %s", file, err.Error())
+		return c.String(http.StatusInternalServerError, "Error listing the versions")
+	}
+	if len(versions) == 0 {
+		return c.String(http.StatusNotFound, "File not found")
+	}
+	return c.JSON(http.StatusOK, versions)
+}
+
+func (f *FilerServer) downloadVersionHandler(c echo.Context, file, versionID string) error {
+	versionStore, ok := store.As[*store.VersionStore](f.store)
+	if !ok {
+		return c.String(http.StatusNotFound, "Versioning is not enabled")
+	}
+
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filepath.Base(file)))
+	c.Response().Header().Set("Content-Type", "application/octet-stream")
+
+	// write the header with the first byte, so that a missing version can still be reported
+	w := &lazyWriter{w: c.Response()}
+	err := versionStore.DownloadVersion(context.Background(), throttleWriter(c, w), file, versionID)
+	if err != nil {
+		if w.written {
+			c.Logger().Errorf("Error downloading the version %s of %s: %s", versionID, file, err.Error())
+			return err
+		}
+		if errors.Is(err, store.ErrVersionNotExist) || errors.Is(err, store.ErrNotExist) {
+			return c.String(http.StatusNotFound, "Version not found")
+		}
+		c.Logger().Errorf("Error downloading the version %s of %s: %s", versionID, file, err.Error())
+		return c.String(http.StatusInternalServerError, "Error downloading the version")
+	}
+	if !w.written {
+		c.Response().WriteHeader(http.StatusOK)
+	}
+	return nil
+}
+
+func (f *FilerServer) restoreFileHandler(c echo.Context) error {
+	file := strings.TrimPrefix(c.Param("*"), "/")
+	versionID := c.QueryParam("version")
+	if versionID == "" {
+		return c.String(http.StatusBadRequest, "version is missing")
+	}
+	if reservedPath(file) {
+		return c.String(http.StatusForbidden, "Reserved path")
+	}
+
+	versionStore, ok := store.As[*store.VersionStore](f.store)
+	if !ok {
+		return c.String(http.StatusNotFound, "Versioning is not enabled")
+	}
+
+	c.Logger().Printf("Restore file %s to version %s", file, versionID)
+
+	if err := versionStore.RestoreVersion(context.Background(), file, versionID); err != nil {
+		if errors.Is(err, store.ErrVersionNotExist) {
+			return c.String(http.StatusNotFound, "Version not found")
+		}
+		c.Logger().Errorf("Error restoring the file %s to version %s: %s", file, versionID, err.Error())
+		return c.String(http.StatusInternalServerError, "Error restoring the file")
+	}
+	f.refreshQuota(c, file)
+
+	return c.String(http.StatusOK, "File restored successfully")
+}
+
+func (f *FilerServer) statsHandler(c echo.Context) error {
+	dedupStore, ok := store.As[*store.DedupStore](f.store)
+	if !ok {
+		return c.String(http.StatusNotFound, "Deduplication is not enabled")
+	}
+
+	stats, err := dedupStore.Stats(context.Background())
+	if err != nil {
+		c.Logger().Errorf("Error collecting dedup stats: %s", err.Error())
+		return c.String(http.StatusInternalServerError, "Error collecting stats")
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
 func getUploadAddress(host string, enableTls bool) string {
 	return getUrl(host, "upload", enableTls)
 }
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestFileServer serves a memory store with the decorators of cfg.Store
+func newTestFileServer(t *testing.T, cfg *config.Config) *echo.Echo {
+	t.Helper()
+	if cfg.Upload.Naming == "" {
+		cfg.Upload.Naming = "timestamp"
+	}
+	cfg.Store.Type = config.StoreTypeMemory
+	st, err := store.New(&cfg.Store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	if err := NewFileServer(cfg, st, nil).Setup(e); err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestInternalFiles(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{}
+	cfg.Upload.Naming = "timestamp"
+	cfg.Store.Type = config.StoreTypeMemory
+	cfg.Store.Dedup = true
+	st, err := store.New(&cfg.Store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	assert.NoError(t, NewFileServer(cfg, st, nil).Setup(e))
+
+	assert.NoError(t, st.UploadFile(ctx, strings.NewReader("content"), "a.txt"))
+	var blobs []string
+	assert.NoError(t, store.Walk(ctx, st, ".blobs", func(key string, _ *store.FileMeta) error {
+		blobs = append(blobs, key)
+		return nil
+	}))
+	assert.NotEmpty(t, blobs)
+
+	// the blobs can neither be downloaded nor deleted
+	for _, key := range blobs {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/download/"+key, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code, key)
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/delete/"+key, nil))
+		assert.Equal(t, http.StatusForbidden, rec.Code, key)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/download/a.txt", nil))
+	assert.Equal(t, "content", rec.Body.String())
+}
+
+func TestFileVersions(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Store.Versioning.Enabled = true
+	e := newTestFileServer(t, cfg)
+	overwrite := http.Header{overwriteHeader: {overwriteAlways}}
+
+	rec := serveTest(e, http.MethodPut, "/upload/a.txt", strings.NewReader("v1"), overwrite)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = serveTest(e, http.MethodGet, "/download/a.txt?versions", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var versions []*store.FileVersion
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &versions))
+	assert.Len(t, versions, 1)
+
+	// the listed ID of the latest version downloads it after it is overwritten
+	rec = serveTest(e, http.MethodPut, "/upload/a.txt", strings.NewReader("v2"), overwrite)
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = serveTest(e, http.MethodGet, "/download/a.txt?version="+versions[0].ID, nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "v1", rec.Body.String())
+	rec = serveTest(e, http.MethodGet, "/download/a.txt", nil, nil)
+	assert.Equal(t, "v2", rec.Body.String())
+}
@@ -1,6 +1,10 @@
 package server
 
 import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -16,3 +20,65 @@ func format(t time.Time) string {
 	s = strings.ReplaceAll(s, ".", "")
 	return s
 }
+
+var errInvalidRange = errors.New("invalid range")
+
+// parseRange parses a single "bytes=start-end" Range header against the file size,
+// ok is false if the header is empty or not a byte range
+func parseRange(header string, size int64) (offset, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		// multiple ranges are not supported, the whole file is served instead
+		return 0, 0, false, nil
+	}
+	startStr, endStr, found := strings.Cut(spec, "-")
+	if !found {
+		return 0, 0, false, errInvalidRange
+	}
+	startStr, endStr = strings.TrimSpace(startStr), strings.TrimSpace(endStr)
+
+	if startStr == "" {
+		// suffix range, the last n bytes, an empty file has none
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false, errInvalidRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false, errInvalidRange
+	}
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false, errInvalidRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true, nil
+}
+
+// lazyWriter writes the 200 status header with the first byte, so errors before any
+// content is written can still be answered with a proper status code
+type lazyWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+var _ io.Writer = (*lazyWriter)(nil)
+
+func (l *lazyWriter) Write(p []byte) (int, error) {
+	if !l.written {
+		l.written = true
+		l.w.WriteHeader(http.StatusOK)
+	}
+	return l.w.Write(p)
+}
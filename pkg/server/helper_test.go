@@ -1,11 +1,26 @@
 package server
 
 import (
+	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
 
+// serveTest serves the request and returns the response
+func serveTest(e *echo.Echo, method, target string, body io.Reader, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	for key, values := range header {
+		req.Header[http.CanonicalHeaderKey(key)] = values
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
 func Test_format(t *testing.T) {
 	ti, err := time.Parse(time.RFC3339Nano, "2024-10-24T01:02:03.456789+08:00")
 	if err != nil {
@@ -14,3 +29,36 @@ func Test_format(t *testing.T) {
 	str := format(ti)
 	assert.Equal(t, "20241024010203456", str)
 }
+
+func Test_parseRange(t *testing.T) {
+	cases := []struct {
+		header         string
+		offset, length int64
+		ok             bool
+		err            bool
+	}{
+		{"", 0, 0, false, false},
+		{"bytes=0-9", 0, 10, true, false},
+		{"bytes=10-", 10, 90, true, false},
+		{"bytes=-20", 80, 20, true, false},
+		{"bytes=-200", 0, 100, true, false},
+		{"bytes=90-200", 90, 10, true, false},
+		{"bytes=0-1,5-6", 0, 0, false, false},
+		{"bytes=100-", 0, 0, false, true},
+		{"bytes=5-1", 0, 0, false, true},
+		{"bytes=abc", 0, 0, false, true},
+	}
+	for _, c := range cases {
+		offset, length, ok, err := parseRange(c.header, 100)
+		assert.Equal(t, c.err, err != nil, c.header)
+		assert.Equal(t, c.ok, ok, c.header)
+		assert.Equal(t, c.offset, offset, c.header)
+		assert.Equal(t, c.length, length, c.header)
+	}
+	// an empty file cannot satisfy any range
+	for _, header := range []string{"bytes=-10", "bytes=0-", "bytes=0-0"} {
+		_, _, ok, err := parseRange(header, 0)
+		assert.ErrorIs(t, err, errInvalidRange, header)
+		assert.False(t, ok, header)
+	}
+}
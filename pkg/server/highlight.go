@@ -0,0 +1,130 @@
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	"github.com/alecthomas/chroma/v2/formatters"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/labstack/echo/v4"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+)
+
+const (
+	// formatHTML shows the paste on the highlighted page
+	formatHTML = "html"
+	// formatANSI answers the paste highlighted with ANSI colors, the default for curl
+	formatANSI = "ansi"
+	// formatSource shows the source of a Markdown paste instead of rendering it
+	formatSource = "source"
+)
+
+const (
+	// htmlStyle is the chroma style of the highlighted page
+	htmlStyle = "github"
+	// ansiStyle is the chroma style of the terminals, most of them are dark
+	ansiStyle = "monokai"
+)
+
+// markdownLanguage is the language whose pastes are rendered
+const markdownLanguage = "markdown"
+
+// maxHighlightSize is the max size of the code which is highlighted, larger pastes are shown as plain text
+const maxHighlightSize = 1 << 20
+
+var htmlFormatter = chromahtml.New(
+	chromahtml.WithLineNumbers(true),
+	chromahtml.LineNumbersInTable(true),
+	chromahtml.WithLinkableLineNumbers(true, "L"),
+	chromahtml.TabWidth(4),
+)
+
+// markdown renders Markdown without raw HTML, which is omitted, and without links to dangerous URLs like
+// javascript:, so the rendered pastes need no further sanitization
+var markdown = goldmark.New(
+	goldmark.WithExtensions(
+		extension.GFM,
+		highlighting.NewHighlighting(
+			highlighting.WithStyle(htmlStyle),
+			highlighting.WithFormatOptions(chromahtml.TabWidth(4)),
+		),
+	),
+)
+
+// pasteFormat returns how the paste is shown, the format query wins over the client detection
+func pasteFormat(c echo.Context) string {
+	switch format := c.QueryParam("format"); format {
+	case formatHTML, formatANSI, formatSource, formatText:
+		return format
+	}
+	if strings.HasPrefix(c.Request().UserAgent(), "curl/") {
+		return formatANSI
+	}
+	return formatHTML
+}
+
+// lexer returns the chroma lexer of the language, the code is analysed if the language is unknown to chroma
+func lexer(language *Language, code string) chroma.Lexer {
+	if len(code) > maxHighlightSize {
+		return lexers.Fallback
+	}
+	l := lexers.Get(language.HighlighterID())
+	if l == nil {
+		l = lexers.Match("paste" + language.Ext())
+	}
+	if l == nil {
+		l = lexers.Analyse(code)
+	}
+	if l == nil {
+		l = lexers.Fallback
+	}
+	return chroma.Coalesce(l)
+}
+
+func highlight(w io.Writer, formatter chroma.Formatter, style string, language *Language, code string) error {
+	iterator, err := lexer(language, code).Tokenise(nil, code)
+	if err != nil {
+		return err
+	}
+	return formatter.Format(w, styles.Get(style), iterator)
+}
+
+// highlightHTML returns the code highlighted with line numbers
+func highlightHTML(language *Language, code string) (template.HTML, error) {
+	buffer := bytes.NewBuffer(nil)
+	if err := highlight(buffer, htmlFormatter, htmlStyle, language, code); err != nil {
+		return "", err
+	}
+	return template.HTML(buffer.String()), nil
+}
+
+// highlightANSI writes the code highlighted with the 256 colors of the terminals
+func highlightANSI(w io.Writer, language *Language, code string) error {
+	return highlight(w, formatters.TTY256, ansiStyle, language, code)
+}
+
+// renderMarkdown returns the sanitized HTML of the Markdown code
+func renderMarkdown(code string) (template.HTML, error) {
+	buffer := bytes.NewBuffer(nil)
+	if err := markdown.Convert([]byte(code), buffer); err != nil {
+		return "", err
+	}
+	return template.HTML(buffer.String()), nil
+}
+
+// pasteDescription is the summary of the paste in the link previews
+func pasteDescription(code string) string {
+	const maxDescription = 200
+	description := strings.Join(strings.Fields(code[:min(len(code), 4*maxDescription)]), " ")
+	if len(description) > maxDescription {
+		description = strings.ToValidUTF8(description[:maxDescription], "") + "…"
+	}
+	return description
+}
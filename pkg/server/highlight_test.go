@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_pasteFormat(t *testing.T) {
+	e := echo.New()
+	for _, c := range []struct {
+		userAgent, query, want string
+	}{
+		{"Mozilla/5.0", "", formatHTML},
+		{"curl/8.5.0", "", formatANSI},
+		{"curl/8.5.0", "?format=html", formatHTML},
+		{"Mozilla/5.0", "?format=ansi", formatANSI},
+		{"Mozilla/5.0", "?format=source", formatSource},
+		{"Mozilla/5.0", "?format=pdf", formatHTML},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/code/go/id"+c.query, nil)
+		req.Header.Set("User-Agent", c.userAgent)
+		assert.Equal(t, c.want, pasteFormat(e.NewContext(req, httptest.NewRecorder())), c.userAgent+c.query)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	languages, err := NewLanguageRegistry(defaultLanguages)
+	assert.NoError(t, err)
+	code := "package main\n\nfunc main() {\n\tprintln(\"<hi>\")\n}\n"
+
+	highlighted, err := highlightHTML(languages.Get("go"), code)
+	assert.NoError(t, err)
+	assert.Contains(t, string(highlighted), `id="L3"`)
+	assert.Contains(t, string(highlighted), "&lt;hi&gt;")
+	assert.Contains(t, string(highlighted), "<span style=")
+
+	colored := bytes.NewBuffer(nil)
+	assert.NoError(t, highlightANSI(colored, languages.Get("go"), code))
+	assert.Contains(t, colored.String(), "\x1b[")
+	assert.Contains(t, colored.String(), "package")
+
+	// a language unknown to chroma is analysed
+	_, err = highlightHTML(&Language{Name: "unknown", Extensions: []string{".unknown"}}, "#!/bin/sh\necho 1\n")
+	assert.NoError(t, err)
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	rendered, err := renderMarkdown(strings.Join([]string{
+		"# Title",
+		"",
+		"| a | b |",
+		"|---|---|",
+		"| 1 | 2 |",
+		"",
+		"<script>alert(1)</script>",
+		"",
+		"[x](javascript:alert(1)) <img src=x onerror=alert(1)>",
+		"",
+		"```go",
+		"package main",
+		"```",
+	}, "\n"))
+	assert.NoError(t, err)
+	html := string(rendered)
+	assert.Contains(t, html, "<h1>Title</h1>")
+	assert.Contains(t, html, "<table>")
+	assert.Contains(t, html, "package")
+	assert.NotContains(t, html, "<script>")
+	assert.NotContains(t, html, "javascript:")
+	assert.NotContains(t, html, "onerror")
+}
+
+func Test_pasteDescription(t *testing.T) {
+	assert.Equal(t, "a b", pasteDescription("a\n\tb\n"))
+	description := pasteDescription(strings.Repeat("界", 300))
+	assert.True(t, strings.HasSuffix(description, "…"))
+	assert.LessOrEqual(t, len(description), 200+len("…"))
+}
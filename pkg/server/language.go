@@ -0,0 +1,218 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Language is a language the code server accepts
+type Language struct {
+	// Name is the name in the paste URLs, e.g. /code/go/<id>
+	Name string `json:"name"`
+	// Extensions are the file extensions with the dot, the first one is the extension of the stored code
+	Extensions []string `json:"extensions"`
+	// Aliases are other names of the language, e.g. used by shebangs and modelines
+	Aliases []string `json:"aliases,omitempty"`
+	// Highlighter is the chroma lexer, the name if empty
+	Highlighter string `json:"highlighter,omitempty"`
+	// MIME is the media type of the code
+	MIME string `json:"mime,omitempty"`
+}
+
+// Ext is the extension of the stored code
+func (l *Language) Ext() string {
+	return l.Extensions[0]
+}
+
+// HighlighterID is the chroma lexer
+func (l *Language) HighlighterID() string {
+	if l.Highlighter != "" {
+		return l.Highlighter
+	}
+	return l.Name
+}
+
+var languageNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+func (l *Language) validate() error {
+	if !languageNamePattern.MatchString(l.Name) {
+		return fmt.Errorf("invalid language name %q", l.Name)
+	}
+	if len(l.Extensions) == 0 {
+		return fmt.Errorf("language %s has no extension", l.Name)
+	}
+	for _, ext := range l.Extensions {
+		if len(ext) < 2 || ext[0] != '.' || strings.ContainsAny(ext, `/\`) {
+			return fmt.Errorf("invalid extension %q of language %s", ext, l.Name)
+		}
+	}
+	return nil
+}
+
+// defaultLanguages are the built-in languages
+var defaultLanguages = []Language{
+	{Name: "text", Extensions: []string{".txt", ".text", ".log"}, Aliases: []string{"plain", "plaintext"}, MIME: "text/plain"},
+	{Name: "bash", Extensions: []string{".sh", ".bash", ".zsh", ".ksh"}, Aliases: []string{"shell", "zsh", "ksh", "sh"}, MIME: "application/x-sh"},
+	{Name: "powershell", Extensions: []string{".ps1", ".psm1"}, Aliases: []string{"pwsh", "ps"}, MIME: "text/plain"},
+	{Name: "batch", Extensions: []string{".bat", ".cmd"}, Aliases: []string{"bat", "dos"}, MIME: "application/x-bat"},
+	{Name: "c", Extensions: []string{".c", ".h"}, MIME: "text/x-c"},
+	{Name: "cpp", Extensions: []string{".cpp", ".cc", ".cxx", ".hpp", ".hh", ".hxx"}, Aliases: []string{"c++"}, MIME: "text/x-c++"},
+	{Name: "csharp", Extensions: []string{".cs"}, Aliases: []string{"c#", "cs"}, MIME: "text/x-csharp"},
+	{Name: "objectivec", Extensions: []string{".m", ".mm"}, Aliases: []string{"objc", "objective-c"}, MIME: "text/x-objectivec"},
+	{Name: "go", Extensions: []string{".go"}, Aliases: []string{"golang"}, MIME: "text/x-go"},
+	{Name: "rust", Extensions: []string{".rs"}, MIME: "text/rust"},
+	{Name: "zig", Extensions: []string{".zig"}, MIME: "text/plain"},
+	{Name: "java", Extensions: []string{".java"}, MIME: "text/x-java"},
+	{Name: "kotlin", Extensions: []string{".kt", ".kts"}, MIME: "text/x-kotlin"},
+	{Name: "scala", Extensions: []string{".scala", ".sc"}, MIME: "text/x-scala"},
+	{Name: "groovy", Extensions: []string{".groovy", ".gradle"}, MIME: "text/x-groovy"},
+	{Name: "swift", Extensions: []string{".swift"}, MIME: "text/x-swift"},
+	{Name: "dart", Extensions: []string{".dart"}, MIME: "application/dart"},
+	{Name: "python", Extensions: []string{".py", ".pyw", ".pyi"}, Aliases: []string{"python2", "python3"}, MIME: "text/x-python"},
+	{Name: "ruby", Extensions: []string{".rb", ".rake", ".gemspec"}, Aliases: []string{"rb"}, MIME: "text/x-ruby"},
+	{Name: "perl", Extensions: []string{".pl", ".pm"}, MIME: "text/x-perl"},
+	{Name: "php", Extensions: []string{".php", ".phtml"}, MIME: "application/x-httpd-php"},
+	{Name: "lua", Extensions: []string{".lua"}, MIME: "text/x-lua"},
+	{Name: "r", Extensions: []string{".r", ".R"}, Aliases: []string{"rscript"}, MIME: "text/x-r"},
+	{Name: "javascript", Extensions: []string{".js", ".mjs", ".cjs", ".jsx"}, Aliases: []string{"node", "nodejs"}, MIME: "text/javascript"},
+	{Name: "typescript", Extensions: []string{".ts", ".tsx", ".mts", ".cts"}, Aliases: []string{"deno"}, MIME: "application/typescript"},
+	{Name: "html", Extensions: []string{".html", ".htm", ".xhtml"}, MIME: "text/html"},
+	{Name: "vue", Extensions: []string{".vue"}, MIME: "text/plain"},
+	{Name: "css", Extensions: []string{".css"}, MIME: "text/css"},
+	{Name: "scss", Extensions: []string{".scss", ".sass"}, MIME: "text/x-scss"},
+	{Name: "less", Extensions: []string{".less"}, Highlighter: "css", MIME: "text/x-less"},
+	{Name: "xml", Extensions: []string{".xml", ".xsd", ".xsl", ".svg", ".plist"}, MIME: "application/xml"},
+	{Name: "json", Extensions: []string{".json", ".jsonc", ".geojson"}, MIME: "application/json"},
+	{Name: "yaml", Extensions: []string{".yaml", ".yml"}, MIME: "application/yaml"},
+	{Name: "toml", Extensions: []string{".toml"}, MIME: "application/toml"},
+	{Name: "ini", Extensions: []string{".ini", ".cfg", ".conf", ".properties"}, Aliases: []string{"dosini"}, MIME: "text/plain"},
+	{Name: "sql", Extensions: []string{".sql", ".ddl"}, Aliases: []string{"mysql", "psql", "postgresql", "sqlite"}, MIME: "application/sql"},
+	{Name: "graphql", Extensions: []string{".graphql", ".gql"}, MIME: "application/graphql"},
+	{Name: "markdown", Extensions: []string{".md", ".markdown", ".mdown"}, Aliases: []string{"md"}, MIME: "text/markdown"},
+	{Name: "diff", Extensions: []string{".diff", ".patch"}, Aliases: []string{"patch", "udiff"}, MIME: "text/x-diff"},
+	{Name: "dockerfile", Extensions: []string{".dockerfile"}, Aliases: []string{"docker", "containerfile"}, MIME: "text/x-dockerfile"},
+	{Name: "makefile", Extensions: []string{".mk", ".make"}, Aliases: []string{"make", "gnumakefile"}, MIME: "text/x-makefile"},
+	{Name: "cmake", Extensions: []string{".cmake"}, Aliases: []string{"cmakelists"}, MIME: "text/x-cmake"},
+	{Name: "nginx", Extensions: []string{".nginx"}, Aliases: []string{"nginxconf"}, MIME: "text/plain"},
+	{Name: "protobuf", Extensions: []string{".proto"}, Aliases: []string{"proto"}, MIME: "text/plain"},
+	{Name: "haskell", Extensions: []string{".hs"}, MIME: "text/x-haskell"},
+	{Name: "elixir", Extensions: []string{".ex", ".exs"}, MIME: "text/x-elixir"},
+	{Name: "erlang", Extensions: []string{".erl", ".hrl"}, MIME: "text/x-erlang"},
+	{Name: "clojure", Extensions: []string{".clj", ".cljs", ".edn"}, MIME: "text/x-clojure"},
+	{Name: "vbnet", Extensions: []string{".vb"}, Aliases: []string{"vb"}, MIME: "text/x-vb"},
+	{Name: "wasm", Extensions: []string{".wat", ".wast"}, Highlighter: "plaintext", MIME: "text/plain"},
+	{Name: "terraform", Extensions: []string{".tf", ".tfvars", ".hcl"}, Aliases: []string{"hcl"}, MIME: "text/plain"},
+	{Name: "latex", Extensions: []string{".tex", ".sty"}, Aliases: []string{"tex"}, MIME: "application/x-tex"},
+	{Name: "csv", Extensions: []string{".csv", ".tsv"}, Highlighter: "plaintext", MIME: "text/csv"},
+}
+
+// LanguageRegistry resolves the languages by name, alias and file extension
+type LanguageRegistry struct {
+	languages  map[string]*Language
+	aliases    map[string]*Language
+	extensions map[string]*Language
+	sorted     []*Language
+}
+
+// NewLanguageRegistry creates a registry of the languages, a language replaces an earlier one of the same name
+func NewLanguageRegistry(languages ...[]Language) (*LanguageRegistry, error) {
+	r := &LanguageRegistry{
+		languages:  make(map[string]*Language),
+		aliases:    make(map[string]*Language),
+		extensions: make(map[string]*Language),
+	}
+	var order []string
+	for _, list := range languages {
+		for i := range list {
+			l := list[i]
+			if err := l.validate(); err != nil {
+				return nil, err
+			}
+			if _, ok := r.languages[l.Name]; !ok {
+				order = append(order, l.Name)
+			}
+			r.languages[l.Name] = &l
+		}
+	}
+	// the aliases and extensions of the later languages win
+	for _, name := range order {
+		l := r.languages[name]
+		for _, alias := range l.Aliases {
+			r.aliases[strings.ToLower(alias)] = l
+		}
+		for _, ext := range l.Extensions {
+			r.extensions[strings.ToLower(ext)] = l
+		}
+		r.sorted = append(r.sorted, l)
+	}
+	sort.Slice(r.sorted, func(i, j int) bool {
+		return r.sorted[i].Name < r.sorted[j].Name
+	})
+	if r.languages[fallbackLanguage] == nil {
+		return nil, fmt.Errorf("the language %s is missing", fallbackLanguage)
+	}
+	return r, nil
+}
+
+// LoadLanguageRegistry creates a registry of the built-in languages and the languages of a JSON file,
+// the languages of the file are added to the built-in ones or replace them
+func LoadLanguageRegistry(file string) (*LanguageRegistry, error) {
+	if file == "" {
+		return NewLanguageRegistry(defaultLanguages)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var languages []Language
+	if err := json.Unmarshal(data, &languages); err != nil {
+		return nil, fmt.Errorf("failed to parse the languages of %s: %w", file, err)
+	}
+	return NewLanguageRegistry(defaultLanguages, languages)
+}
+
+// Get returns the language of the name, nil if it is unknown
+func (r *LanguageRegistry) Get(name string) *Language {
+	return r.languages[name]
+}
+
+// Lookup returns the language of a name, an alias or an extension without the dot, nil if it is unknown
+func (r *LanguageRegistry) Lookup(name string) *Language {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return nil
+	}
+	if l := r.languages[name]; l != nil {
+		return l
+	}
+	if l := r.aliases[name]; l != nil {
+		return l
+	}
+	return r.extensions["."+name]
+}
+
+// ByFilename returns the language of the file from its extension, or from the base name for files
+// like Dockerfile and Makefile, nil if it is unknown
+func (r *LanguageRegistry) ByFilename(filename string) *Language {
+	base := baseName(filename)
+	if ext := path.Ext(base); ext != "" {
+		if l := r.extensions[strings.ToLower(ext)]; l != nil {
+			return l
+		}
+	}
+	name, _, _ := strings.Cut(base, ".")
+	if l := r.languages[strings.ToLower(name)]; l != nil {
+		return l
+	}
+	return r.aliases[strings.ToLower(name)]
+}
+
+// Languages returns the languages sorted by name
+func (r *LanguageRegistry) Languages() []*Language {
+	return r.sorted
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLanguageRegistry(t *testing.T) {
+	r, err := LoadLanguageRegistry("")
+	assert.NoError(t, err)
+	for name, want := range map[string]string{
+		"go": "go", "Golang": "go", "c++": "cpp", "yml": "yaml", "ts": "typescript",
+		"md": "markdown", "patch": "diff", "psql": "sql", "cobol": "",
+	} {
+		got := ""
+		if l := r.Lookup(name); l != nil {
+			got = l.Name
+		}
+		assert.Equal(t, want, got, name)
+	}
+	for filename, want := range map[string]string{
+		"main.GO": "go", "Cargo.toml": "toml", "Dockerfile": "dockerfile", "Dockerfile.prod": "dockerfile",
+		"Makefile": "makefile", "notes": "",
+	} {
+		got := ""
+		if l := r.ByFilename(filename); l != nil {
+			got = l.Name
+		}
+		assert.Equal(t, want, got, filename)
+	}
+	assert.Equal(t, "css", r.Get("less").HighlighterID())
+	assert.Equal(t, "go", r.Get("go").HighlighterID())
+	assert.Equal(t, ".txt", r.Get("text").Ext())
+
+	languages := r.Languages()
+	assert.Len(t, languages, len(defaultLanguages))
+	for i := 1; i < len(languages); i++ {
+		assert.Less(t, languages[i-1].Name, languages[i].Name)
+	}
+
+	// the languages of the file are added or replace the built-in ones
+	file := filepath.Join(t.TempDir(), "languages.json")
+	assert.NoError(t, os.WriteFile(file, []byte(`[
+		{"name": "cobol", "extensions": [".cob", ".cbl"], "mime": "text/x-cobol"},
+		{"name": "go", "extensions": [".go"], "aliases": ["gopher"], "highlighter": "golang"}
+	]`), 0644))
+	r, err = LoadLanguageRegistry(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "cobol", r.ByFilename("HELLO.CBL").Name)
+	assert.Equal(t, "golang", r.Lookup("gopher").HighlighterID())
+	assert.Nil(t, r.Lookup("golang"))
+	assert.Len(t, r.Languages(), len(defaultLanguages)+1)
+
+	for _, invalid := range []string{
+		`[{"name": "Bad Name", "extensions": [".x"]}]`,
+		`[{"name": "x", "extensions": []}]`,
+		`[{"name": "x", "extensions": ["x"]}]`,
+		`{"name": "x"}`,
+	} {
+		assert.NoError(t, os.WriteFile(file, []byte(invalid), 0644))
+		_, err = LoadLanguageRegistry(file)
+		assert.Error(t, err, invalid)
+	}
+}
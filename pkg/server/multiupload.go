@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// relativePathField carries the relative path of the next file part in a folder upload,
+// the file name of a multipart part never contains the directories
+const relativePathField = "relative_path"
+
+// uploadOptionFields are the form fields read by parseUploadOptions, they apply to all files of the form
+var uploadOptionFields = []string{"path", "overwrite", "expires_in", "naming"}
+
+const (
+	// formInlineSize is the size up to which a file part is buffered in memory and uploaded by the
+	// worker pool, larger files are streamed to the store while the form is read
+	formInlineSize = 1 << 20
+	// formValueSize is the max size of a form field
+	formValueSize = 64 << 10
+)
+
+// saveForm streams the parts of a multipart form into the store without buffering them in temp files.
+// The option fields must precede the file parts, a late one is rejected rather than applying to some files only,
+// a relative_path field applies to the file part after it,
+// the checksum headers of a file part apply to the part.
+// A single file is answered with the download commands, multiple files or a folder with the result of every file.
+func (f *FilerServer) saveForm(c echo.Context, limit *uploadLimiter) error {
+	reader, err := c.Request().MultipartReader()
+	if err != nil {
+		c.Logger().Errorf("Error reading the form: %s", err.Error())
+		return c.String(http.StatusBadRequest, "Error reading the form")
+	}
+	form := url.Values{}
+	c.Set(uploadFormKey, form)
+
+	var (
+		opts    *uploadOptions
+		paths   *uploadPaths
+		folder  bool
+		results []*uploadResult
+		wg      sync.WaitGroup
+	)
+	// the workers run the uploads of the buffered files, the streamed files take a slot as well
+	workers := make(chan struct{}, max(f.cfg.Upload.Workers, 1))
+	readError := func(err error) error {
+		wg.Wait()
+		if limit.exceeded {
+			return f.uploadTooLarge(c)
+		}
+		c.Logger().Errorf("Error reading the form: %s", err.Error())
+		return c.String(http.StatusBadRequest, "Error reading the form")
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return readError(err)
+		}
+
+		if part.FileName() == "" {
+			if opts != nil && slices.Contains(uploadOptionFields, part.FormName()) {
+				wg.Wait()
+				c.Logger().Errorf("Error reading the form: the field %s follows a file", part.FormName())
+				return c.String(http.StatusBadRequest, fmt.Sprintf("The field %s must precede the files", part.FormName()))
+			}
+			value, err := io.ReadAll(io.LimitReader(part, formValueSize))
+			if err != nil {
+				return readError(err)
+			}
+			form.Add(part.FormName(), string(value))
+			continue
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		if opts == nil {
+			if opts, err = f.parseUploadOptions(c); err != nil {
+				c.Logger().Errorf("Error parsing the upload options: %s", err.Error())
+				return c.String(http.StatusBadRequest, err.Error())
+			}
+			paths = newUploadPaths(opts)
+		}
+		relativePath := form.Get(relativePathField)
+		form.Del(relativePathField)
+		folder = folder || relativePath != ""
+
+		result := &uploadResult{Name: baseName(part.FileName())}
+		results = append(results, result)
+		name, filePath, err := paths.resolve(part.FileName(), relativePath)
+		if err != nil {
+			result.Status, result.Error = http.StatusBadRequest, err.Error()
+			continue
+		}
+		expected, err := parseDigests(http.Header(part.Header))
+		if err != nil {
+			result.Status, result.Error = http.StatusBadRequest, err.Error()
+			continue
+		}
+
+		buffer := bytes.NewBuffer(nil)
+		n, err := io.CopyN(buffer, part, formInlineSize+1)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return readError(err)
+		}
+
+		workers <- struct{}{}
+		if n <= formInlineSize {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				*result = *f.putFile(c, opts, buffer, name, filePath, expected)
+				<-workers
+			}()
+			continue
+		}
+		*result = *f.putFile(c, opts, io.MultiReader(buffer, part), name, filePath, expected)
+		<-workers
+		if limit.exceeded {
+			wg.Wait()
+			return f.uploadTooLarge(c)
+		}
+	}
+	wg.Wait()
+
+	if len(results) == 0 {
+		c.Logger().Errorf("Error retrieving the file: %s", http.ErrMissingFile.Error())
+		return c.String(http.StatusInternalServerError, "Error retrieving the file")
+	}
+	if len(results) == 1 && !folder {
+		return f.uploadResponse(c, opts, results[0])
+	}
+
+	status := http.StatusOK
+	for _, result := range results {
+		if result.Error != "" {
+			status = http.StatusMultiStatus
+		}
+	}
+	return c.JSON(status, results)
+}
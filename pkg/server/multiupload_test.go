@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_uploadPaths(t *testing.T) {
+	naming, err := NewNamingStrategy("{uuid}-{name}")
+	assert.NoError(t, err)
+	paths := newUploadPaths(&uploadOptions{target: &uploadTarget{}, naming: naming})
+
+	// the files of a folder share the generated folder name, single files are named on upload
+	name, a, err := paths.resolve("a.txt", "docs/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "docs/a.txt", name)
+	prefix, _, _ := strings.Cut(a, "/")
+	assert.True(t, strings.HasSuffix(prefix, "-docs"), a)
+
+	_, b, err := paths.resolve("b.txt", "docs/sub/b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, prefix+"/sub/b.txt", b)
+
+	name, c, err := paths.resolve("C:\\tmp\\c.txt", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "c.txt", name)
+	assert.Equal(t, "", c)
+
+	_, _, err = paths.resolve("a.txt", "../a.txt")
+	assert.Error(t, err)
+
+	// an explicit path is the file, or the directory of a folder upload or if it ends with a slash
+	paths = newUploadPaths(&uploadOptions{target: &uploadTarget{path: "backup"}, naming: naming})
+	_, p, _ := paths.resolve("a.txt", "")
+	assert.Equal(t, "backup", p)
+	_, p, _ = paths.resolve("a.txt", "docs/a.txt")
+	assert.Equal(t, "backup/docs/a.txt", p)
+
+	paths = newUploadPaths(&uploadOptions{target: &uploadTarget{path: "backup", dir: true}, naming: naming})
+	_, p, _ = paths.resolve("a.txt", "")
+	assert.Equal(t, "backup/a.txt", p)
+	_, _, err = paths.resolve("", "")
+	assert.Error(t, err)
+}
+
+func Test_uploadLimiter(t *testing.T) {
+	limit := &uploadLimiter{Reader: strings.NewReader("0123456789"), remain: 4}
+	buffer := make([]byte, 3)
+	n, err := limit.Read(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	n, err = limit.Read(buffer)
+	assert.ErrorIs(t, err, errUploadTooLarge)
+	assert.Equal(t, 1, n)
+	assert.True(t, limit.exceeded)
+
+	limit = &uploadLimiter{Reader: strings.NewReader("0123"), remain: 4}
+	buffer = make([]byte, 10)
+	n, _ = limit.Read(buffer)
+	assert.Equal(t, 4, n)
+	assert.False(t, limit.exceeded)
+}
+
+func TestSaveFormLateOption(t *testing.T) {
+	e := newTestFileServer(t, &config.Config{})
+	upload := func(fields func(w *multipart.Writer)) *httptest.ResponseRecorder {
+		body := bytes.NewBuffer(nil)
+		w := multipart.NewWriter(body)
+		fields(w)
+		assert.NoError(t, w.Close())
+		return serveTest(e, http.MethodPost, "/upload", body, http.Header{echo.HeaderContentType: {w.FormDataContentType()}})
+	}
+	writeFile := func(w *multipart.Writer) {
+		part, err := w.CreateFormFile("file", "a.txt")
+		assert.NoError(t, err)
+		_, _ = part.Write([]byte("a"))
+	}
+
+	rec := upload(func(w *multipart.Writer) {
+		assert.NoError(t, w.WriteField("path", "docs/a.txt"))
+		writeFile(w)
+	})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "a", serveTest(e, http.MethodGet, "/download/docs/a.txt", nil, nil).Body.String())
+
+	// an option after a file would apply to the later files only
+	rec = upload(func(w *multipart.Writer) {
+		writeFile(w)
+		assert.NoError(t, w.WriteField("overwrite", overwriteAlways))
+	})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "overwrite")
+}
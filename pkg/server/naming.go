@@ -0,0 +1,161 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// namingHeader overrides the naming strategy of an upload with a built-in one, the form field naming does the
+// same for form uploads
+const namingHeader = "X-Naming"
+
+// NamingStrategy generates the path of an upload which has no explicit path
+type NamingStrategy interface {
+	Name(input *NamingInput) (string, error)
+}
+
+// NamingInput is what the path of an upload is generated from
+type NamingInput struct {
+	Filename string
+	Time     time.Time
+	// SHA256 is the hex digest of the content, it is only computed if the strategy needs it
+	SHA256 string
+}
+
+// contentNaming is implemented by the strategies which need the digest of the content
+type contentNaming interface {
+	HashContent() bool
+}
+
+// namingStrategies are the built-in strategies, they are all path templates
+var namingStrategies = map[string]string{
+	"timestamp": "{yyyy}/{mm}/{timestamp}-{name}",
+	"original":  "{yyyy}/{mm}/{name}",
+	"uuid":      "{yyyy}/{mm}/{uuid}{ext}",
+	"shortid":   "{shortid}{ext}",
+	"hash":      "{hash}{ext}",
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z]+)\}`)
+
+// placeholders of path templates
+var placeholders = map[string]func(input *NamingInput) string{
+	"yyyy":      func(input *NamingInput) string { return input.Time.Format("2006") },
+	"mm":        func(input *NamingInput) string { return input.Time.Format("01") },
+	"dd":        func(input *NamingInput) string { return input.Time.Format("02") },
+	"hh":        func(input *NamingInput) string { return input.Time.Format("15") },
+	"timestamp": func(input *NamingInput) string { return format(input.Time) },
+	"uuid":      func(input *NamingInput) string { return newUUID() },
+	"shortid":   func(input *NamingInput) string { return newShortID(8) },
+	"hash":      func(input *NamingInput) string { return input.SHA256 },
+	"name":      func(input *NamingInput) string { return baseName(input.Filename) },
+	"base": func(input *NamingInput) string {
+		name := baseName(input.Filename)
+		return strings.TrimSuffix(name, path.Ext(name))
+	},
+	"ext": func(input *NamingInput) string { return path.Ext(baseName(input.Filename)) },
+}
+
+// NewNamingStrategy returns the built-in strategy with the name, or a template strategy if s is a path
+// template like {yyyy}/{mm}/{dd}/{uuid}-{name}
+func NewNamingStrategy(s string) (NamingStrategy, error) {
+	if template, ok := namingStrategies[s]; ok {
+		s = template
+	}
+	if !strings.Contains(s, "{") {
+		return nil, fmt.Errorf("unknown naming strategy %q", s)
+	}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(s, -1) {
+		if _, ok := placeholders[match[1]]; !ok {
+			return nil, fmt.Errorf("unknown placeholder %s in naming template %q", match[0], s)
+		}
+	}
+	return &templateNaming{template: s}, nil
+}
+
+// templateNaming replaces the placeholders of a path template
+type templateNaming struct {
+	template string
+}
+
+func (t *templateNaming) Name(input *NamingInput) (string, error) {
+	return placeholderPattern.ReplaceAllStringFunc(t.template, func(s string) string {
+		return placeholders[s[1:len(s)-1]](input)
+	}), nil
+}
+
+func (t *templateNaming) HashContent() bool {
+	return strings.Contains(t.template, "{hash}")
+}
+
+// namingStrategy returns the strategy of the request, falling back to the configured one. Only the server
+// configures path templates, the requests choose among the built-in strategies.
+func (f *FilerServer) namingStrategy(naming string) (NamingStrategy, error) {
+	if naming == "" {
+		return f.naming, nil
+	}
+	if _, ok := namingStrategies[naming]; !ok {
+		return nil, fmt.Errorf("unknown naming strategy %q", naming)
+	}
+	return NewNamingStrategy(naming)
+}
+
+// spoolWithHash copies reader to a temp file and returns it with the hex SHA-256 of the content,
+// the caller must close and remove the file
+func spoolWithHash(reader io.Reader) (*os.File, string, error) {
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), reader); err != nil {
+		closeAndRemove(tmp)
+		return nil, "", fmt.Errorf("failed to spool upload: %w", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		closeAndRemove(tmp)
+		return nil, "", fmt.Errorf("failed to seek temp file: %w", err)
+	}
+	return tmp, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func closeAndRemove(f *os.File) {
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+}
+
+// baseName returns the last element of a client file name, which may be a Windows path
+func baseName(filename string) string {
+	return path.Base(strings.ReplaceAll(filename, "\\", "/"))
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+const shortIDAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// newShortID returns a random alphanumeric id of length n
+func newShortID(n int) string {
+	id := make([]byte, n)
+	max := big.NewInt(int64(len(shortIDAlphabet)))
+	for i := range id {
+		v, _ := rand.Int(rand.Reader, max)
+		id[i] = shortIDAlphabet[v.Int64()]
+	}
+	return string(id)
+}
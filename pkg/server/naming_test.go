@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamingStrategy(t *testing.T) {
+	input := &NamingInput{
+		Filename: "C:\\Users\\me\\report.tar.gz",
+		Time:     time.Date(2024, 10, 24, 1, 2, 3, 456000000, time.UTC),
+		SHA256:   "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}
+	cases := []struct {
+		naming string
+		want   string
+	}{
+		{"timestamp", `^2024/10/20241024010203456-report\.tar\.gz$`},
+		{"original", `^2024/10/report\.tar\.gz$`},
+		{"uuid", `^2024/10/[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}\.gz$`},
+		{"shortid", `^[0-9a-zA-Z]{8}\.gz$`},
+		{"hash", `^9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08\.gz$`},
+		{"{yyyy}/{mm}/{dd}/{hh}/{base}{ext}", `^2024/10/24/01/report\.tar\.gz$`},
+	}
+	for _, c := range cases {
+		naming, err := NewNamingStrategy(c.naming)
+		assert.NoError(t, err, c.naming)
+		name, err := naming.Name(input)
+		assert.NoError(t, err, c.naming)
+		assert.Regexp(t, regexp.MustCompile(c.want), name, c.naming)
+	}
+
+	hash, _ := NewNamingStrategy("hash")
+	assert.True(t, hash.(contentNaming).HashContent())
+	uuid, _ := NewNamingStrategy("uuid")
+	assert.False(t, uuid.(contentNaming).HashContent())
+
+	for _, naming := range []string{"random", "{yyyy}/{unknown}"} {
+		_, err := NewNamingStrategy(naming)
+		assert.Error(t, err, naming)
+	}
+}
+
+func TestUploadNaming(t *testing.T) {
+	e := newTestFileServer(t, &config.Config{})
+	upload := func(naming, overwrite string) *httptest.ResponseRecorder {
+		header := http.Header{"X-Filename": {"a.txt"}, namingHeader: {naming}}
+		if overwrite != "" {
+			header.Set(overwriteHeader, overwrite)
+		}
+		return serveTest(e, http.MethodPut, "/upload", strings.NewReader("a"), header)
+	}
+
+	// the requests cannot choose a path template
+	for _, naming := range []string{"pastes/{name}", "{yyyy}/{name}", ".meta/{name}"} {
+		assert.Equal(t, http.StatusBadRequest, upload(naming, "").Code, naming)
+	}
+
+	// the generated paths are overwritten unless the request asks otherwise
+	assert.Equal(t, http.StatusOK, upload("original", "").Code)
+	assert.Equal(t, http.StatusOK, upload("original", "").Code)
+	assert.Equal(t, http.StatusConflict, upload("original", overwriteNever).Code)
+
+	// a content-addressed path which exists holds the same content
+	first := upload("hash", overwriteNever)
+	assert.Equal(t, http.StatusOK, first.Code, first.Body.String())
+	second := upload("hash", overwriteNever)
+	assert.Equal(t, http.StatusOK, second.Code, second.Body.String())
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	rec := serveTest(e, http.MethodGet, "/download/ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb.txt", nil, nil)
+	assert.Equal(t, "a", rec.Body.String())
+
+	// a template generating a directory is rejected when naming
+	e = newTestFileServer(t, &config.Config{Upload: config.UploadConfig{Naming: "{yyyy}/"}})
+	assert.Equal(t, http.StatusBadRequest, upload("", "").Code)
+}
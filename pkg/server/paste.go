@@ -0,0 +1,281 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/store"
+)
+
+// The pastes are kept in the internal tree store.PasteDir, the file endpoints do not serve them
+var (
+	// pasteDir keeps the code of the pastes as .pastes/code/<lang>/<id><ext>
+	pasteDir = path.Join(store.PasteDir, "code")
+	// pasteRecordDir keeps the JSON record of every paste as .pastes/records/<id>.json
+	pasteRecordDir = path.Join(store.PasteDir, "records")
+)
+
+const (
+	// pasteFilesDir keeps the files of the multi-file pastes as .pastes/code/files/<id>/<name>
+	pasteFilesDir = "files"
+	// legacyPasteDir kept the code of the pastes as code/<lang>/<id><ext> before the records were introduced
+	legacyPasteDir = "code"
+)
+
+// PasteFile is a file of a paste
+type PasteFile struct {
+	Name     string `json:"name"`
+	Language string `json:"language"`
+	Size     int64  `json:"size"`
+	// Key is the store key of the file
+	Key string `json:"key"`
+}
+
+// Paste is the metadata record of a paste, the display page and the listings are derived from it
+type Paste struct {
+	ID        string     `json:"id"`
+	Title     string     `json:"title,omitempty"`
+	Language  string     `json:"language"`
+	Created   time.Time  `json:"created"`
+	Author    string     `json:"author,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	Size      int64      `json:"size"`
+	// Key is the store key of the code
+	Key string `json:"key"`
+	// Parent is the ID of the paste this one is a revision or a fork of
+	Parent string `json:"parent,omitempty"`
+	// Root is the ID of the first paste of the revision chain, a fork starts a new chain
+	Root string `json:"root,omitempty"`
+	// Revision is the number of the paste in its chain, starting at 1
+	Revision int `json:"revision,omitempty"`
+	// Private pastes are unlisted, they are only found by their URL
+	Private bool `json:"private,omitempty"`
+	// Files are the files of a multi-file paste, Language and Key are the ones of the first file
+	Files []PasteFile `json:"files,omitempty"`
+	// Password protects the paste, its files are encrypted with the key of the password
+	Password *PastePassword `json:"password,omitempty"`
+	// Encrypted pastes are encrypted end-to-end, the single file is the sealed paste the server cannot read
+	Encrypted bool `json:"encrypted,omitempty"`
+}
+
+// PasteFiles returns the files of the paste, the file of a single-file paste is named after its key
+func (p *Paste) PasteFiles() []PasteFile {
+	if len(p.Files) > 0 {
+		return p.Files
+	}
+	return []PasteFile{{Name: path.Base(p.Key), Language: p.Language, Size: p.Size, Key: p.Key}}
+}
+
+// File returns the file of the name, the first file if the name is empty, nil if the paste has no such file
+func (p *Paste) File(name string) *PasteFile {
+	files := p.PasteFiles()
+	if name == "" {
+		return &files[0]
+	}
+	for i := range files {
+		if files[i].Name == name {
+			return &files[i]
+		}
+	}
+	return nil
+}
+
+// Languages returns the distinct languages of the files of the paste
+func (p *Paste) Languages() []string {
+	var languages []string
+	for _, file := range p.PasteFiles() {
+		if !slices.Contains(languages, file.Language) {
+			languages = append(languages, file.Language)
+		}
+	}
+	return languages
+}
+
+// Searchable reports whether the code of the paste may be indexed, the private, protected and encrypted
+// pastes are not
+func (p *Paste) Searchable() bool {
+	return !p.Private && p.Password == nil && !p.Encrypted
+}
+
+// OwnedBy reports whether the paste was created by the owner, the pastes without an author have no owner
+func (p *Paste) OwnedBy(owner string) bool {
+	return owner != "" && p.Author == owner
+}
+
+// VisibleTo reports whether the paste may be linked from the other pastes for the viewer, the private pastes
+// only are for their owner
+func (p *Paste) VisibleTo(viewer string) bool {
+	return !p.Private || p.OwnedBy(viewer)
+}
+
+// ChainRoot is the ID of the first paste of the revision chain
+func (p *Paste) ChainRoot() string {
+	if p.Root == "" {
+		return p.ID
+	}
+	return p.Root
+}
+
+// Forked reports whether the paste is a fork of its parent
+func (p *Paste) Forked() bool {
+	return p.Parent != "" && p.ChainRoot() == p.ID
+}
+
+// Rev is the number of the paste in its chain
+func (p *Paste) Rev() int {
+	return max(p.Revision, 1)
+}
+
+// Expired reports whether the paste is expired but not deleted yet
+func (p *Paste) Expired() bool {
+	return p.ExpiresAt != nil && !p.ExpiresAt.After(time.Now())
+}
+
+// Path is the path of the display page
+func (p *Paste) Path() string {
+	return fmt.Sprintf("/code/%s/%s", p.Language, p.ID)
+}
+
+func pasteKey(language, id, ext string) string {
+	return path.Join(pasteDir, language, id+ext)
+}
+
+func pasteFileKey(id, name string) string {
+	return path.Join(pasteDir, pasteFilesDir, id, name)
+}
+
+func pasteRecordKey(id string) string {
+	return path.Join(pasteRecordDir, id+".json")
+}
+
+// pasteMetadata expires the files of a paste with the paste
+func pasteMetadata(p *Paste) map[string]string {
+	if p.ExpiresAt == nil {
+		return nil
+	}
+	return map[string]string{store.MetaExpiresAt: p.ExpiresAt.UTC().Format(time.RFC3339)}
+}
+
+// savePasteRecord writes the record of the paste, its code must be saved before
+func (s *CodeServer) savePasteRecord(ctx context.Context, p *Paste) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err := store.UploadFileWithMetadata(ctx, s.store, bytes.NewReader(data), pasteRecordKey(p.ID), pasteMetadata(p)); err != nil {
+		return err
+	}
+	s.catalog.add(p)
+	return nil
+}
+
+// readPasteRecord returns nil if the paste has no record
+func (s *CodeServer) readPasteRecord(ctx context.Context, id string) (*Paste, error) {
+	buffer := bytes.NewBuffer(nil)
+	if err := s.store.DownloadFile(ctx, buffer, pasteRecordKey(id)); err != nil {
+		if errors.Is(err, store.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	p := &Paste{}
+	if err := json.Unmarshal(buffer.Bytes(), p); err != nil {
+		return nil, fmt.Errorf("failed to parse the record of paste %s: %w", id, err)
+	}
+	return p, nil
+}
+
+// loadPaste returns the paste shown at /code/<language>/<id>, nil if it does not exist or is expired.
+// The pastes created before the records were introduced are derived from their code file.
+func (s *CodeServer) loadPaste(ctx context.Context, language, id string) (*Paste, error) {
+	if strings.ContainsAny(id, `/\`) {
+		return nil, nil
+	}
+	p, err := s.readPasteRecord(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		if p, err = s.legacyPaste(ctx, language, id); err != nil || p == nil {
+			return nil, err
+		}
+	}
+	if p.Language != language || p.Expired() {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// legacyPaste derives the paste from its code file, nil if it does not exist
+func (s *CodeServer) legacyPaste(ctx context.Context, language, id string) (*Paste, error) {
+	lang := s.languages.Get(language)
+	if lang == nil {
+		return nil, nil
+	}
+	key := path.Join(legacyPasteDir, language, id+lang.Ext())
+	meta, err := s.store.FileMeta(ctx, key)
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	return &Paste{ID: id, Language: language, Created: meta.ModTime, Size: meta.Size, Key: key}, nil
+}
+
+// loadPasteByID returns the paste of the ID whatever its language, nil if it does not exist or is expired
+func (s *CodeServer) loadPasteByID(ctx context.Context, id string) (*Paste, error) {
+	if strings.ContainsAny(id, `/\`) || id == "" {
+		return nil, nil
+	}
+	p, err := s.readPasteRecord(ctx, id)
+	if err != nil || p != nil {
+		if p != nil && p.Expired() {
+			return nil, nil
+		}
+		return p, err
+	}
+	// the language of a paste without a record is only known from its path
+	for _, language := range s.languages.Languages() {
+		if p, err := s.legacyPaste(ctx, language.Name, id); err != nil || p != nil {
+			return p, err
+		}
+	}
+	return nil, nil
+}
+
+// pasteRevisions returns the pastes of the revision chain of the paste the viewer may see, the oldest first.
+// The private revisions are left out unless the viewer owns them.
+func (s *CodeServer) pasteRevisions(ctx context.Context, p *Paste, viewer string) ([]*Paste, error) {
+	catalog, err := s.loadCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var revisions []*Paste
+	for _, r := range catalog.chain(p.ChainRoot()) {
+		if r.ID == p.ID || r.VisibleTo(viewer) {
+			revisions = append(revisions, r)
+		}
+	}
+	if len(revisions) == 0 {
+		revisions = append(revisions, p)
+	}
+	return revisions, nil
+}
+
+// listPastes returns the records of the pastes which are not expired, the newest first
+func (s *CodeServer) listPastes(ctx context.Context, limit int) ([]*Paste, error) {
+	catalog, err := s.loadCatalog(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pastes := catalog.list()
+	if limit > 0 && len(pastes) > limit {
+		pastes = pastes[:limit]
+	}
+	return pastes, nil
+}
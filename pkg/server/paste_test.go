@@ -0,0 +1,218 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCodeServer is a code server on a memory store with metadata, like the store of the server
+type testCodeServer struct {
+	*CodeServer
+	t *testing.T
+	e *echo.Echo
+}
+
+func newTestCodeServer(t *testing.T, cfg *config.Config) *testCodeServer {
+	t.Helper()
+	s := NewCodeServer(cfg, store.NewMetaStore(store.NewMemStore()), nil)
+	e := echo.New()
+	if err := s.Setup(e); err != nil {
+		t.Fatal(err)
+	}
+	return &testCodeServer{CodeServer: s, t: t, e: e}
+}
+
+// get serves a GET request of path with the header
+func (s *testCodeServer) get(path string, header http.Header) *httptest.ResponseRecorder {
+	return serveTest(s.e, http.MethodGet, path, nil, header)
+}
+
+// createPaste creates a paste of the code with PUT /code, the query and the header set its options. The
+// created paste is returned, or nil if the status is not 201 Created.
+func (s *testCodeServer) createPaste(code, query string, header http.Header) (*pasteResult, int) {
+	h := http.Header{"Accept": {echo.MIMEApplicationJSON}}
+	for key, values := range header {
+		h[key] = values
+	}
+	return s.pasteResult(serveTest(s.e, http.MethodPut, "/code?"+query, strings.NewReader(code), h))
+}
+
+// postPaste creates a paste of the JSON request body with POST /code, like createPaste
+func (s *testCodeServer) postPaste(body string) (*pasteResult, int) {
+	header := http.Header{"Accept": {echo.MIMEApplicationJSON}, echo.HeaderContentType: {echo.MIMEApplicationJSON}}
+	return s.pasteResult(serveTest(s.e, http.MethodPost, "/code", strings.NewReader(body), header))
+}
+
+func (s *testCodeServer) pasteResult(rec *httptest.ResponseRecorder) (*pasteResult, int) {
+	if rec.Code != http.StatusCreated {
+		return nil, rec.Code
+	}
+	result := &pasteResult{Paste: &Paste{}}
+	assert.NoError(s.t, json.Unmarshal(rec.Body.Bytes(), result))
+	return result, rec.Code
+}
+
+func TestPasteRecords(t *testing.T) {
+	ctx := context.Background()
+	s := newTestCodeServer(t, &config.Config{})
+	st := s.store
+
+	// a paste without a record is derived from its code file
+	assert.NoError(t, st.UploadFile(ctx, strings.NewReader("package main"), "code/go/20240101000000000-abcdef12.go"))
+	p, err := s.loadPaste(ctx, "go", "20240101000000000-abcdef12")
+	assert.NoError(t, err)
+	if assert.NotNil(t, p) {
+		assert.Equal(t, "code/go/20240101000000000-abcdef12.go", p.Key)
+		assert.Equal(t, int64(12), p.Size)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	for _, p := range []*Paste{
+		{ID: "old", Title: "old", Language: "go", Created: time.Now().Add(-time.Hour), Key: "code/go/old.go"},
+		{ID: "new", Title: "new", Language: "go", Created: time.Now(), Key: "code/go/new.go"},
+		{ID: "expired", Language: "go", Created: time.Now(), ExpiresAt: &past, Key: "code/go/expired.go"},
+	} {
+		assert.NoError(t, s.savePasteRecord(ctx, p))
+	}
+
+	p, err = s.loadPaste(ctx, "go", "new")
+	assert.NoError(t, err)
+	if assert.NotNil(t, p) {
+		assert.Equal(t, "new", p.Title)
+	}
+	for _, id := range []string{"expired", "missing", "../new"} {
+		p, err = s.loadPaste(ctx, "go", id)
+		assert.NoError(t, err)
+		assert.Nil(t, p, id)
+	}
+	p, err = s.loadPaste(ctx, "python", "new")
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+
+	// the expired record is kept for the retention sweep but not listed
+	meta, err := st.FileMeta(ctx, pasteRecordKey("expired"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, meta.Metadata[store.MetaExpiresAt])
+
+	pastes, err := s.listPastes(ctx, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, pastes, 2) {
+		assert.Equal(t, "new", pastes[0].ID)
+		assert.Equal(t, "old", pastes[1].ID)
+	}
+	pastes, err = s.listPastes(ctx, 1)
+	assert.NoError(t, err)
+	assert.Len(t, pastes, 1)
+
+	// the retention sweeps the pastes in the internal tree like the other files
+	retention, err := store.NewRetentionFromConfig(&config.RetentionConfig{})
+	assert.NoError(t, err)
+	deleted, err := retention.Sweep(ctx, st)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	meta, err = st.FileMeta(ctx, pasteRecordKey("expired"))
+	assert.NoError(t, err)
+	assert.Nil(t, meta)
+}
+
+func TestCreatePaste(t *testing.T) {
+	s := newTestCodeServer(t, &config.Config{})
+
+	p, status := s.createPaste("package main", "lang=go&title=main&expires_in=1h", http.Header{authTokenHeader: {"secret"}})
+	if !assert.Equal(t, http.StatusCreated, status) {
+		return
+	}
+	assert.Equal(t, "main", p.Title)
+	assert.NotEmpty(t, p.Author)
+	assert.NotNil(t, p.ExpiresAt)
+	assert.Equal(t, "package main", s.get(p.Path()+"/raw", nil).Body.String())
+
+	// the record is saved with the code
+	record, err := s.loadPaste(context.Background(), "go", p.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, record) {
+		assert.Equal(t, p.Paste, record)
+	}
+
+	for _, query := range []string{"lang=go&expires_in=soon", "lang=cobol"} {
+		_, status = s.createPaste("package main", query, nil)
+		assert.Equal(t, http.StatusBadRequest, status, query)
+	}
+}
+
+// listCountingStore counts the listings of the store
+type listCountingStore struct {
+	store.Store
+	lists int
+}
+
+func (s *listCountingStore) List(ctx context.Context, dir string) ([]*store.FileMeta, error) {
+	s.lists++
+	return s.Store.List(ctx, dir)
+}
+
+func TestPasteCatalog(t *testing.T) {
+	ctx := context.Background()
+	st := &listCountingStore{Store: store.NewMetaStore(store.NewMemStore())}
+	s := NewCodeServer(&config.Config{}, st, nil)
+	assert.NoError(t, s.Setup(echo.New()))
+
+	past := time.Now().Add(-time.Minute)
+	root := &Paste{ID: "root", Language: "go", Created: time.Now().Add(-time.Hour), Key: "code/go/root.go"}
+	assert.NoError(t, s.savePasteRecord(ctx, root))
+	for _, p := range []*Paste{
+		{ID: "rev3", Language: "go", Created: time.Now(), Key: "code/go/rev3.go", Root: "root", Revision: 3},
+		{ID: "rev2", Language: "go", Created: time.Now().Add(-time.Minute), Key: "code/go/rev2.go", Root: "root", Revision: 2},
+		{ID: "expired", Language: "go", Created: time.Now(), ExpiresAt: &past, Key: "code/go/expired.go", Root: "root"},
+		{ID: "other", Language: "go", Created: time.Now(), Key: "code/go/other.go"},
+	} {
+		assert.NoError(t, s.savePasteRecord(ctx, p))
+	}
+
+	// the records are listed once, the pages read the catalog
+	for i := 0; i < 3; i++ {
+		revisions, err := s.pasteRevisions(ctx, root, "")
+		assert.NoError(t, err)
+		var ids []string
+		for _, p := range revisions {
+			ids = append(ids, p.ID)
+		}
+		assert.Equal(t, []string{"root", "rev2", "rev3"}, ids)
+		pastes, err := s.listPastes(ctx, 0)
+		assert.NoError(t, err)
+		assert.Len(t, pastes, 4)
+	}
+	assert.Equal(t, 1, st.lists)
+
+	// another server loads the catalog from the records
+	s2 := NewCodeServer(&config.Config{}, st, nil)
+	assert.NoError(t, s2.Setup(echo.New()))
+	revisions, err := s2.pasteRevisions(ctx, &Paste{ID: "rev2", Root: "root"}, "")
+	assert.NoError(t, err)
+	assert.Len(t, revisions, 3)
+
+	// the catalog catches up with the records deleted and saved behind its back once it is old
+	assert.NoError(t, st.DeleteFile(ctx, pasteRecordKey("other")))
+	assert.NoError(t, s2.savePasteRecord(ctx, &Paste{ID: "new", Language: "go", Created: time.Now(), Key: "code/go/new.go"}))
+	pastes, err := s.listPastes(ctx, 0)
+	assert.NoError(t, err)
+	assert.Len(t, pastes, 4)
+	s.catalog.synced = time.Now().Add(-catalogSyncInterval)
+	pastes, err = s.listPastes(ctx, 0)
+	assert.NoError(t, err)
+	var ids []string
+	for _, p := range pastes {
+		ids = append(ids, p.ID)
+	}
+	assert.ElementsMatch(t, []string{"root", "rev2", "rev3", "new"}, ids)
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// catalogSyncInterval is how often the catalog is compared with the records of the store
+const catalogSyncInterval = time.Minute
+
+// pasteCatalog keeps the records of the pastes in memory, so that the lists and the revision chains do not
+// read every record of the store. It is loaded from the store the first time, the records saved by the
+// server are added to it. The records deleted behind its back, e.g. by a retention rule, and the ones saved
+// by the other servers of the store are caught up with every catalogSyncInterval.
+type pasteCatalog struct {
+	mu sync.RWMutex
+	// loading serializes the syncs of the catalog
+	loading sync.Mutex
+	// synced is the time of the last sync, zero until the catalog is loaded
+	synced time.Time
+	pastes map[string]*Paste
+	// added is when the pastes were added, the ones added during a sync are newer than its listing
+	added map[string]time.Time
+	// chains maps the root of a revision chain to its pastes, the oldest first
+	chains map[string][]*Paste
+}
+
+func newPasteCatalog() *pasteCatalog {
+	return &pasteCatalog{
+		pastes: make(map[string]*Paste),
+		added:  make(map[string]time.Time),
+		chains: make(map[string][]*Paste),
+	}
+}
+
+// add adds the record of the paste or replaces it
+func (x *pasteCatalog) add(p *Paste) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.put(p)
+}
+
+func (x *pasteCatalog) put(p *Paste) {
+	x.remove(p.ID)
+	record := *p
+	root := record.ChainRoot()
+	chain := append(x.chains[root], &record)
+	sort.SliceStable(chain, func(i, j int) bool {
+		return chain[i].Created.Before(chain[j].Created)
+	})
+	x.pastes[record.ID] = &record
+	x.added[record.ID] = time.Now()
+	x.chains[root] = chain
+}
+
+func (x *pasteCatalog) remove(id string) {
+	p, ok := x.pastes[id]
+	if !ok {
+		return
+	}
+	delete(x.pastes, id)
+	delete(x.added, id)
+	root := p.ChainRoot()
+	chain := slices.DeleteFunc(x.chains[root], func(c *Paste) bool { return c.ID == id })
+	if len(chain) == 0 {
+		delete(x.chains, root)
+	} else {
+		x.chains[root] = chain
+	}
+}
+
+// list returns the pastes which are not expired, the newest first. The expired pastes are dropped, the
+// retention deletes their records from the store.
+func (x *pasteCatalog) list() []*Paste {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	pastes := make([]*Paste, 0, len(x.pastes))
+	for id, p := range x.pastes {
+		if p.Expired() {
+			x.remove(id)
+			continue
+		}
+		pastes = append(pastes, p)
+	}
+	sort.Slice(pastes, func(i, j int) bool {
+		return pastes[i].Created.After(pastes[j].Created)
+	})
+	return pastes
+}
+
+// chain returns the pastes of the revision chain which are not expired, the oldest first
+func (x *pasteCatalog) chain(root string) []*Paste {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	var pastes []*Paste
+	for _, p := range x.chains[root] {
+		if !p.Expired() {
+			pastes = append(pastes, p)
+		}
+	}
+	return pastes
+}
+
+// loadCatalog returns the catalog, it is loaded from the records of the store the first time and synced
+// with them once it is older than catalogSyncInterval. The others read the catalog as it is during a sync.
+func (s *CodeServer) loadCatalog(ctx context.Context) (*pasteCatalog, error) {
+	s.catalog.mu.RLock()
+	synced := s.catalog.synced
+	s.catalog.mu.RUnlock()
+	if synced.IsZero() {
+		s.catalog.loading.Lock()
+	} else if time.Since(synced) < catalogSyncInterval || !s.catalog.loading.TryLock() {
+		return s.catalog, nil
+	}
+	defer s.catalog.loading.Unlock()
+
+	s.catalog.mu.RLock()
+	synced = s.catalog.synced
+	s.catalog.mu.RUnlock()
+	if !synced.IsZero() && time.Since(synced) < catalogSyncInterval {
+		return s.catalog, nil
+	}
+	if err := s.syncCatalog(ctx); err != nil {
+		if synced.IsZero() {
+			return nil, err
+		}
+		// the catalog of the last sync is still good to read
+		log.Printf("Error syncing the paste catalog: %s\n", err.Error())
+	}
+	return s.catalog, nil
+}
+
+// syncCatalog adds the records of the store missing from the catalog and drops the pastes whose record is
+// gone. The records are written once, the ones in the catalog are not read again.
+func (s *CodeServer) syncCatalog(ctx context.Context) error {
+	start := time.Now()
+	metas, err := s.store.List(ctx, pasteRecordDir)
+	if err != nil {
+		return err
+	}
+	s.catalog.mu.RLock()
+	known := make(map[string]bool, len(s.catalog.pastes))
+	for id := range s.catalog.pastes {
+		known[id] = true
+	}
+	s.catalog.mu.RUnlock()
+
+	stored := make(map[string]bool, len(metas))
+	var pastes []*Paste
+	for _, meta := range metas {
+		id, ok := strings.CutSuffix(meta.Name, ".json")
+		if meta.IsDir || !ok {
+			continue
+		}
+		stored[id] = true
+		if known[id] {
+			continue
+		}
+		p, err := s.readPasteRecord(ctx, id)
+		if err != nil {
+			return err
+		}
+		if p != nil && !p.Expired() {
+			pastes = append(pastes, p)
+		}
+	}
+
+	s.catalog.mu.Lock()
+	defer s.catalog.mu.Unlock()
+	for id, added := range s.catalog.added {
+		// the records saved during the sync may be missing from the listing
+		if !stored[id] && added.Before(start) {
+			s.catalog.remove(id)
+		}
+	}
+	for _, p := range pastes {
+		// the records saved during the sync are newer
+		if _, ok := s.catalog.pastes[p.ID]; !ok {
+			s.catalog.put(p)
+		}
+	}
+	s.catalog.synced = time.Now()
+	return nil
+}
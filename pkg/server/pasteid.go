@@ -0,0 +1,121 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/store"
+)
+
+const (
+	// defaultPasteIDLength gives 62^8 IDs, too many to guess the pastes
+	defaultPasteIDLength = 8
+	minPasteIDLength     = 4
+	maxPasteIDLength     = 32
+	// pasteIDAttempts bounds the retries of the random IDs which are used already
+	pasteIDAttempts = 10
+)
+
+// pasteHashDir maps the content hashes of the pastes to their IDs as .pastes/hashes/<sha256>, it is used to
+// reuse the ID of an identical paste
+var pasteHashDir = path.Join(store.PasteDir, "hashes")
+
+// validatePasteIDLength checks the configured length of the paste IDs, 0 is the default length
+func validatePasteIDLength(n int) error {
+	if n != 0 && (n < minPasteIDLength || n > maxPasteIDLength) {
+		return fmt.Errorf("the paste id length must be between %d and %d", minPasteIDLength, maxPasteIDLength)
+	}
+	return nil
+}
+
+// newPasteID returns a random base62 ID which is not used by a paste, keys are the store keys of the
+// files of the paste of an ID. The pastes created before the random IDs keep their timestamp IDs.
+func (s *CodeServer) newPasteID(ctx context.Context, keys func(id string) []string) (string, error) {
+	length := s.cfg.Code.IDLength
+	if length == 0 {
+		length = defaultPasteIDLength
+	}
+	for i := 0; i < pasteIDAttempts; i++ {
+		id := newShortID(length)
+		used, err := s.pasteIDUsed(ctx, id, keys(id))
+		if err != nil {
+			return "", err
+		}
+		if !used {
+			return id, nil
+		}
+	}
+	return "", errors.New("no unused paste id found, the id length is too short")
+}
+
+// pasteIDUsed reports whether a paste has the ID, expired or not, or its files exist
+func (s *CodeServer) pasteIDUsed(ctx context.Context, id string, keys []string) (bool, error) {
+	for _, key := range append([]string{pasteRecordKey(id)}, keys...) {
+		meta, err := s.store.FileMeta(ctx, key)
+		if err != nil {
+			return false, err
+		}
+		if meta != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func pasteHashKey(hash string) string {
+	return path.Join(pasteHashDir, hash)
+}
+
+// pasteHash is the hash of what makes pastes identical: the title, the files, the parent, the private flag,
+// the expiry and the author, so that nobody gets the ID of the paste of another author, which the author may
+// revise
+func pasteHash(p *Paste, files []PasteFile, fork bool, codes []string) string {
+	h := sha256.New()
+	write := func(values ...string) {
+		for _, v := range values {
+			// the length prefixes keep the boundaries of the values
+			fmt.Fprintf(h, "%d:%s", len(v), v)
+		}
+	}
+	expiresAt := ""
+	if p.ExpiresAt != nil {
+		expiresAt = p.ExpiresAt.UTC().Format(time.RFC3339Nano)
+	}
+	write(p.Title, p.Parent, fmt.Sprint(fork), fmt.Sprint(p.Private), expiresAt, p.Author)
+	for i, file := range files {
+		write(file.Name, file.Language, codes[i])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// duplicatePaste returns the paste of the hash, nil if there is none or it does not expire with the new paste.
+// The expiry of a paste counts from its creation, so only the pastes which never expire are reused in practice.
+func (s *CodeServer) duplicatePaste(ctx context.Context, hash string, p *Paste) (*Paste, error) {
+	buffer := bytes.NewBuffer(nil)
+	if err := s.store.DownloadFile(ctx, buffer, pasteHashKey(hash)); err != nil {
+		if errors.Is(err, store.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	duplicate, err := s.loadPasteByID(ctx, strings.TrimSpace(buffer.String()))
+	if err != nil || duplicate == nil {
+		return nil, err
+	}
+	if (duplicate.ExpiresAt == nil) != (p.ExpiresAt == nil) || (p.ExpiresAt != nil && !duplicate.ExpiresAt.Equal(*p.ExpiresAt)) {
+		return nil, nil
+	}
+	return duplicate, nil
+}
+
+// savePasteHash maps the hash to the paste, the mapping expires with the paste
+func (s *CodeServer) savePasteHash(ctx context.Context, hash string, p *Paste) error {
+	return store.UploadFileWithMetadata(ctx, s.store, strings.NewReader(p.ID), pasteHashKey(hash), pasteMetadata(p))
+}
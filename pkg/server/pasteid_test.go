@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_validatePasteIDLength(t *testing.T) {
+	assert.NoError(t, validatePasteIDLength(0))
+	assert.NoError(t, validatePasteIDLength(12))
+	assert.Error(t, validatePasteIDLength(3))
+	assert.Error(t, validatePasteIDLength(33))
+	assert.Error(t, NewCodeServer(&config.Config{Code: config.CodeConfig{IDLength: 2}}, store.NewMemStore(), nil).Setup(echo.New()))
+}
+
+func TestPasteIDs(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.Config{Code: config.CodeConfig{IDLength: 12}}
+	s := newTestCodeServer(t, cfg)
+	st := s.store
+
+	create := func(code, query string) string {
+		result, status := s.createPaste(code, "lang=go&"+query, nil)
+		if !assert.Equal(t, http.StatusCreated, status) {
+			return ""
+		}
+		return result.URL
+	}
+
+	url := create("package main\n", "")
+	assert.Regexp(t, regexp.MustCompile(`/code/go/[0-9a-zA-Z]{12}$`), url)
+	assert.NotEqual(t, url, create("package main\n", ""))
+
+	id := url[strings.LastIndex(url, "/")+1:]
+	used, err := s.pasteIDUsed(ctx, id, nil)
+	assert.NoError(t, err)
+	assert.True(t, used)
+	used, err = s.pasteIDUsed(ctx, "unused", []string{pasteKey("go", "unused", ".go")})
+	assert.NoError(t, err)
+	assert.False(t, used)
+	// the files of a paste without a record use the ID too
+	assert.NoError(t, st.UploadFile(ctx, strings.NewReader("x"), pasteKey("go", "legacy", ".go")))
+	used, err = s.pasteIDUsed(ctx, "legacy", []string{pasteKey("go", "legacy", ".go")})
+	assert.NoError(t, err)
+	assert.True(t, used)
+
+	// the timestamp IDs of the older pastes keep working
+	old := &Paste{ID: "20240301120000000-1a2b3c4d", Language: "go", Key: pasteKey("go", "20240301120000000-1a2b3c4d", ".go"), Size: 1}
+	assert.NoError(t, st.UploadFile(ctx, strings.NewReader("x"), old.Key))
+	assert.NoError(t, s.savePasteRecord(ctx, old))
+	assert.Equal(t, "x", s.get(old.Path()+"/raw", nil).Body.String())
+
+	// the identical pastes share the ID with the dedup
+	cfg.Code.Dedup = true
+	url = create("package dedup\n", "title=a")
+	assert.Equal(t, url, create("package dedup\n", "title=a"))
+	assert.NotEqual(t, url, create("package dedup\n", "title=b"))
+	assert.NotEqual(t, url, create("package dedup\n", "title=a&private=1"))
+	// the identical pastes of other authors are not shared
+	alice, _ := s.createPaste("package authors\n", "lang=go", http.Header{authTokenHeader: {"alice"}})
+	again, _ := s.createPaste("package authors\n", "lang=go", http.Header{authTokenHeader: {"alice"}})
+	bob, _ := s.createPaste("package authors\n", "lang=go", http.Header{authTokenHeader: {"bob"}})
+	anonymous, _ := s.createPaste("package authors\n", "lang=go", nil)
+	assert.Equal(t, alice.ID, again.ID)
+	assert.NotEqual(t, alice.ID, bob.ID)
+	assert.NotEqual(t, alice.ID, anonymous.ID)
+	// a paste is only reused if it expires with the new one
+	expiring := create("package expiring\n", "expires_in=1h")
+	assert.NotEqual(t, expiring, create("package expiring\n", "expires_in=1h"))
+	assert.NotEqual(t, expiring, create("package expiring\n", "expires_in=2h"))
+	permanent := create("package expiring\n", "")
+	assert.NotEqual(t, expiring, permanent)
+	assert.Equal(t, permanent, create("package expiring\n", ""))
+	assert.NotEqual(t, permanent, create("package expiring\n", "expires_in=1h"))
+}
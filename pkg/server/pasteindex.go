@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/graydovee/fileManager/pkg/store"
+)
+
+const (
+	// minWordLength skips the words too short to search for
+	minWordLength = 2
+	// maxWordLength skips the long tokens like hashes and base64 data
+	maxWordLength = 64
+)
+
+// pasteIndex is an inverted index of the words of the titles, file names and code of the listed pastes.
+// It is kept in memory and built from the store on the first search, the pastes created by the server
+// are added to it.
+type pasteIndex struct {
+	mu sync.RWMutex
+	// building serializes the builds of the index
+	building sync.Mutex
+	built    bool
+	// words maps a word to the IDs of the pastes containing it
+	words map[string]map[string]struct{}
+}
+
+func newPasteIndex() *pasteIndex {
+	return &pasteIndex{words: make(map[string]map[string]struct{})}
+}
+
+// add indexes the words of the texts of the paste
+func (x *pasteIndex) add(id string, texts ...string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, text := range texts {
+		for _, word := range indexWords(text) {
+			ids := x.words[word]
+			if ids == nil {
+				ids = make(map[string]struct{})
+				x.words[word] = ids
+			}
+			ids[id] = struct{}{}
+		}
+	}
+}
+
+// search returns the IDs of the pastes containing all words of the query, nil if it has no word
+func (x *pasteIndex) search(query string) map[string]struct{} {
+	words := indexWords(query)
+	if len(words) == 0 {
+		return nil
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	result := make(map[string]struct{})
+	for id := range x.words[words[0]] {
+		result[id] = struct{}{}
+	}
+	for _, word := range words[1:] {
+		ids := x.words[word]
+		for id := range result {
+			if _, ok := ids[id]; !ok {
+				delete(result, id)
+			}
+		}
+	}
+	return result
+}
+
+// indexWords returns the distinct lower case words of the text, the letters and digits between the other
+// characters
+func indexWords(text string) []string {
+	seen := make(map[string]struct{})
+	var words []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		if n := len(word); n < minWordLength || n > maxWordLength {
+			continue
+		}
+		word = strings.ToLower(word)
+		if _, ok := seen[word]; !ok {
+			seen[word] = struct{}{}
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// indexPaste adds the paste to the index unless it is not searchable
+func (s *CodeServer) indexPaste(p *Paste, codes []string) {
+	if !p.Searchable() {
+		return
+	}
+	texts := append([]string{p.Title}, codes...)
+	if len(p.Files) > 0 {
+		for _, file := range p.Files {
+			texts = append(texts, file.Name)
+		}
+	}
+	s.index.add(p.ID, texts...)
+}
+
+// searchIndex returns the index, it is built from the pastes of the store the first time
+func (s *CodeServer) searchIndex(ctx context.Context) (*pasteIndex, error) {
+	s.index.mu.RLock()
+	built := s.index.built
+	s.index.mu.RUnlock()
+	if built {
+		return s.index, nil
+	}
+
+	s.index.building.Lock()
+	defer s.index.building.Unlock()
+	if s.index.built {
+		return s.index, nil
+	}
+	pastes, err := s.listPastes(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pastes {
+		if !p.Searchable() {
+			continue
+		}
+		var codes []string
+		for _, file := range p.PasteFiles() {
+			buffer := bytes.NewBuffer(nil)
+			if err := s.store.DownloadFile(ctx, buffer, file.Key); err != nil {
+				if errors.Is(err, store.ErrNotExist) {
+					continue
+				}
+				return nil, err
+			}
+			codes = append(codes, buffer.String())
+		}
+		s.indexPaste(p, codes)
+	}
+	s.index.mu.Lock()
+	s.index.built = true
+	s.index.mu.Unlock()
+	return s.index, nil
+}
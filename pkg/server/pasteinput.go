@@ -0,0 +1,180 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxPasteFiles is the max count of files of a paste
+const maxPasteFiles = 50
+
+// pasteInput is a file of a new paste
+type pasteInput struct {
+	// Name is the file name, the language is detected from it if it is missing
+	Name     string `json:"name,omitempty"`
+	Language string `json:"language,omitempty"`
+	Content  string `json:"content"`
+}
+
+// pasteRequest creates a paste, it is the JSON body of POST /code, e.g.
+// {"title": "repro", "files": [{"name": "main.go", "content": "..."}, {"name": "go.mod", "content": "..."}]}.
+// The options missing in the body are taken from the headers, the form or the query.
+type pasteRequest struct {
+	Title     string       `json:"title,omitempty"`
+	ExpiresIn string       `json:"expiresIn,omitempty"`
+	Parent    string       `json:"parent,omitempty"`
+	Fork      bool         `json:"fork,omitempty"`
+	Private   bool         `json:"private,omitempty"`
+	Password  string       `json:"password,omitempty"`
+	Encrypted bool         `json:"encrypted,omitempty"`
+	Files     []pasteInput `json:"files"`
+}
+
+// withOptions completes the request with the options of the headers, the form or the query
+func (r *pasteRequest) withOptions(c echo.Context) {
+	if r.Title == "" {
+		r.Title = pasteOption(c, "X-Title", "title")
+	}
+	r.Title = strings.TrimSpace(r.Title)
+	if r.ExpiresIn == "" {
+		r.ExpiresIn = pasteOption(c, expiresInHeader, "expires_in")
+	}
+	if r.Parent == "" {
+		r.Parent = pasteOption(c, parentHeader, "parent")
+	}
+	r.Fork = r.Fork || pasteFlag(c, forkHeader, "fork")
+	r.Private = r.Private || pasteFlag(c, privateHeader, "private")
+	if r.Password == "" {
+		r.Password = requestPassword(c)
+	}
+	r.Encrypted = r.Encrypted || pasteFlag(c, encryptedHeader, "encrypted")
+}
+
+// pasteOption returns the option from the header, or else from the form or the query
+func pasteOption(c echo.Context, header, field string) string {
+	if v := c.Request().Header.Get(header); v != "" {
+		return v
+	}
+	if c.Request().Form != nil {
+		return c.Request().Form.Get(field)
+	}
+	return c.QueryParam(field)
+}
+
+// pasteFlag returns whether the option is set to anything but false
+func pasteFlag(c echo.Context, header, field string) bool {
+	switch strings.ToLower(pasteOption(c, header, field)) {
+	case "", "0", "false", "off":
+		return false
+	}
+	return true
+}
+
+// readPasteRequest reads the paste of a form with the fields filename, language and code repeated for every
+// file, of a multipart upload of the files, or of a JSON body
+func readPasteRequest(c echo.Context) (*pasteRequest, int, error) {
+	req := c.Request()
+	body := throttleReader(c, req.Body)
+	// the multipart encoding and the JSON escaping make the body larger than the code
+	req.Body = http.MaxBytesReader(c.Response(), struct {
+		io.Reader
+		io.Closer
+	}{body, req.Body}, 2*maxPasteSize)
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get(echo.HeaderContentType))
+	paste := &pasteRequest{}
+	switch mediaType {
+	case echo.MIMEApplicationJSON:
+		if err := json.NewDecoder(req.Body).Decode(paste); err != nil {
+			c.Logger().Errorf("Error parsing the paste: %v", err)
+			return nil, http.StatusBadRequest, errors.New("Invalid JSON")
+		}
+	case echo.MIMEMultipartForm:
+		if err := req.ParseMultipartForm(maxPasteSize); err != nil {
+			c.Logger().Errorf("Error parsing form: %v", err)
+			return nil, http.StatusBadRequest, errors.New("Invalid form data")
+		}
+		defer req.MultipartForm.RemoveAll()
+		for _, header := range req.MultipartForm.File["file"] {
+			if header.Size > maxPasteSize {
+				return nil, http.StatusRequestEntityTooLarge, errors.New("Code is too large")
+			}
+			file, err := header.Open()
+			if err != nil {
+				return nil, http.StatusBadRequest, errors.New("Error reading the code")
+			}
+			content, err := io.ReadAll(file)
+			file.Close()
+			if err != nil {
+				return nil, http.StatusBadRequest, errors.New("Error reading the code")
+			}
+			paste.Files = append(paste.Files, pasteInput{Name: header.Filename, Content: string(content)})
+		}
+	default:
+		if err := req.ParseForm(); err != nil {
+			c.Logger().Errorf("Error parsing form: %v", err)
+			return nil, http.StatusBadRequest, errors.New("Invalid form data")
+		}
+	}
+	// the code fields of the form and of a multipart upload without files
+	if req.Form != nil {
+		codes, names, languages := req.Form["code"], req.Form["filename"], req.Form["language"]
+		for i, code := range codes {
+			// the empty files of the form are skipped
+			if code == "" {
+				continue
+			}
+			input := pasteInput{Content: code}
+			if i < len(names) {
+				input.Name = names[i]
+			}
+			if i < len(languages) {
+				input.Language = languages[i]
+			}
+			paste.Files = append(paste.Files, input)
+		}
+	}
+	paste.withOptions(c)
+	return paste, http.StatusOK, nil
+}
+
+// cleanPasteFileName returns the base name of the file, an error if it cannot be a file name
+func cleanPasteFileName(name string) (string, error) {
+	name = baseName(strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." || name == "/" || len(name) > 255 {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	return name, nil
+}
+
+// pasteLanguage returns the language of a file of a new paste, it is detected from the name and the code if
+// it is empty or auto. The file of the parent of the same name, or the single file of the parent of a single
+// file paste, keeps its language.
+func (s *CodeServer) pasteLanguage(req *pasteRequest, parent *Paste, input *pasteInput, name string) *Language {
+	language := input.Language
+	if language == "" || language == autoLanguage {
+		if parent != nil {
+			if file := parent.File(name); file != nil && name != "" {
+				language = file.Language
+			} else if len(req.Files) == 1 && len(parent.PasteFiles()) == 1 {
+				language = parent.Language
+			}
+		}
+	}
+	if language == "" || language == autoLanguage {
+		hint := name
+		if hint == "" && len(req.Files) == 1 && path.Ext(req.Title) != "" {
+			hint = req.Title
+		}
+		language = s.languages.detectLanguage(hint, input.Content)
+	}
+	return s.languages.Lookup(language)
+}
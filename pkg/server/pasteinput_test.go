@@ -0,0 +1,110 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_readPasteRequest(t *testing.T) {
+	e := echo.New()
+	form := url.Values{
+		"title":    {"repro"},
+		"filename": {"main.go", "", "notes"},
+		"language": {"auto", "auto", "markdown"},
+		"code":     {"package main\n", "", "# Notes\n"},
+	}
+	req := httptest.NewRequest(http.MethodPost, "/code", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	paste, status, err := readPasteRequest(e.NewContext(req, httptest.NewRecorder()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "repro", paste.Title)
+	// the empty file of the form is skipped
+	assert.Equal(t, []pasteInput{
+		{Name: "main.go", Language: "auto", Content: "package main\n"},
+		{Name: "notes", Language: "markdown", Content: "# Notes\n"},
+	}, paste.Files)
+
+	req = httptest.NewRequest(http.MethodPost, "/code", strings.NewReader(`{"files": [{"content": "x"}]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set(forkHeader, "1")
+	req.Header.Set(parentHeader, "p")
+	paste, _, err = readPasteRequest(e.NewContext(req, httptest.NewRecorder()))
+	assert.NoError(t, err)
+	assert.True(t, paste.Fork)
+	assert.Equal(t, "p", paste.Parent)
+
+	req = httptest.NewRequest(http.MethodPost, "/code", strings.NewReader(`{"files": [`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	_, status, err = readPasteRequest(e.NewContext(req, httptest.NewRecorder()))
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func Test_cleanPasteFileName(t *testing.T) {
+	for name, want := range map[string]string{
+		"main.go":           "main.go",
+		" dir/main.go ":     "main.go",
+		`C:\src\Dockerfile`: "Dockerfile",
+		"..":                "",
+		".":                 "",
+		"dir/":              "dir",
+	} {
+		got, err := cleanPasteFileName(name)
+		if want == "" {
+			assert.Error(t, err, name)
+		} else {
+			assert.Equal(t, want, got, name)
+		}
+	}
+}
+
+func TestMultiFilePaste(t *testing.T) {
+	s := newTestCodeServer(t, &config.Config{})
+
+	first, _ := s.postPaste(`{"files": [{"name": "main.go", "content": "package main\n"}, {"content": "#!/usr/bin/env python3\nprint(1)\n"}]}`)
+	if !assert.NotNil(t, first) {
+		return
+	}
+	assert.Equal(t, "go", first.Language)
+	assert.Equal(t, int64(len("package main\n")+len("#!/usr/bin/env python3\nprint(1)\n")), first.Size)
+	if assert.Len(t, first.Files, 2) {
+		// the unnamed file is named after its language
+		assert.Equal(t, "file2.py", first.Files[1].Name)
+		assert.Equal(t, "python", first.Files[1].Language)
+		assert.Equal(t, pasteFileKey(first.ID, "main.go"), first.Key)
+	}
+	assert.Nil(t, first.File("missing.go"))
+
+	assert.Equal(t, "#!/usr/bin/env python3\nprint(1)\n", s.get(first.Path()+"/raw?file=file2.py", nil).Body.String())
+	assert.Equal(t, http.StatusNotFound, s.get(first.Path()+"?file=missing.go", nil).Code)
+	rec := s.get(first.Path()+"?format=ansi", nil)
+	assert.Contains(t, rec.Body.String(), "==> main.go <==")
+	assert.Contains(t, rec.Body.String(), "==> file2.py <==")
+
+	for _, body := range []string{
+		`{"files": []}`,
+		`{"files": [{"name": "a.go", "content": "x"}, {"name": "dir/a.go", "content": "y"}]}`,
+		`{"files": [{"name": "..", "content": "x"}]}`,
+		`{"files": [{"name": "a.go", "content": ""}]}`,
+	} {
+		_, status := s.postPaste(body)
+		assert.Equal(t, http.StatusBadRequest, status, body)
+	}
+
+	// the files are compared by name, the unchanged files are left out
+	second, _ := s.postPaste(`{"parent": "` + first.ID + `", "fork": true, "files": [{"name": "main.go", "content": "package main\n"}, {"name": "go.mod", "content": "module repro\n"}]}`)
+	if !assert.NotNil(t, second) {
+		return
+	}
+	assert.Equal(t, "go", second.Files[0].Language)
+	assert.Equal(t, "--- /dev/null\n+++ b/go.mod\n@@ -0,0 +1,1 @@\n+module repro\n--- a/file2.py\n+++ /dev/null\n@@ -1,2 +0,0 @@\n-#!/usr/bin/env python3\n-print(1)\n",
+		s.get(second.Path()+"/diff?format=text", nil).Body.String())
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// privateHeader creates an unlisted paste, which is hidden from the list and the search, the form field
+// private does the same
+const privateHeader = "X-Private"
+
+// pastePageSize is the count of pastes of a page of the list
+const pastePageSize = 50
+
+// ownerMe filters the pastes of the token of the request, including the private ones
+const ownerMe = "me"
+
+// pasteFilter selects the pastes of the list
+type pasteFilter struct {
+	// Query are the words the pastes contain, searched in the index
+	Query    string
+	Language string
+	Since    time.Time
+	Until    time.Time
+	// Owner is the owner of the author token
+	Owner string
+	// Private lists the private pastes too, only for their owner
+	Private bool
+}
+
+// match reports whether the paste is selected, the query is matched with the index before
+func (f *pasteFilter) match(p *Paste) bool {
+	if p.Private && !f.Private {
+		return false
+	}
+	if f.Owner != "" && p.Author != f.Owner {
+		return false
+	}
+	if !f.Since.IsZero() && p.Created.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && p.Created.After(f.Until) {
+		return false
+	}
+	if f.Language != "" {
+		for _, file := range p.PasteFiles() {
+			if file.Language == f.Language {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// parsePasteFilter reads the filter of the q, lang, since, until and owner query, the dates are
+// 2006-01-02 or RFC 3339 times
+func (s *CodeServer) parsePasteFilter(c echo.Context) (*pasteFilter, error) {
+	filter := &pasteFilter{Query: strings.TrimSpace(c.QueryParam("q"))}
+	if name := c.QueryParam("lang"); name != "" {
+		language := s.languages.Lookup(name)
+		if language == nil {
+			return nil, errors.New("Language not supported")
+		}
+		filter.Language = language.Name
+	}
+	var err error
+	if filter.Since, err = parseListDate(c.QueryParam("since"), false); err != nil {
+		return nil, err
+	}
+	if filter.Until, err = parseListDate(c.QueryParam("until"), true); err != nil {
+		return nil, err
+	}
+	filter.Owner = strings.TrimSpace(c.QueryParam("owner"))
+	if filter.Owner == ownerMe {
+		if filter.Owner = requestOwner(c); filter.Owner == "" {
+			return nil, errors.New("The owner me needs an auth token")
+		}
+	}
+	filter.Private = filter.Owner != "" && filter.Owner == requestOwner(c)
+	return filter, nil
+}
+
+// parseListDate parses a date of the filter, a day ends at its last instant if end is set
+func parseListDate(value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	if end {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+	return t, nil
+}
+
+// findPastes returns the pastes of the filter, the newest first, at most limit if it is positive
+func (s *CodeServer) findPastes(ctx context.Context, filter *pasteFilter, limit int) ([]*Paste, error) {
+	var matches map[string]struct{}
+	if filter.Query != "" {
+		index, err := s.searchIndex(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if matches = index.search(filter.Query); matches == nil {
+			// the query has no word to search for
+			matches = map[string]struct{}{}
+		}
+	}
+	pastes, err := s.listPastes(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	var found []*Paste
+	for _, p := range pastes {
+		if _, ok := matches[p.ID]; (matches == nil || ok) && filter.match(p) {
+			found = append(found, p)
+			if limit > 0 && len(found) == limit {
+				break
+			}
+		}
+	}
+	return found, nil
+}
+
+// pasteList is a page of the list
+type pasteList struct {
+	Pastes []*pasteResult `json:"pastes"`
+	Total  int            `json:"total"`
+	Page   int            `json:"page"`
+}
+
+// handlePasteList lists the pastes which are not private, the newest first, as a page or as JSON if it is
+// accepted or the format query is json
+func (s *CodeServer) handlePasteList(c echo.Context) error {
+	filter, err := s.parsePasteFilter(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	pastes, err := s.findPastes(context.Background(), filter, 0)
+	if err != nil {
+		c.Logger().Errorf("Error listing the pastes: %v", err)
+		return c.String(http.StatusInternalServerError, "Error listing the pastes")
+	}
+
+	page := 1
+	if n, err := strconv.Atoi(c.QueryParam("page")); err == nil && n > 1 {
+		page = n
+	}
+	total := len(pastes)
+	start := min((page-1)*pastePageSize, total)
+	pastes = pastes[start:min(start+pastePageSize, total)]
+
+	if c.QueryParam("format") == "json" || strings.Contains(c.Request().Header.Get("Accept"), "application/json") {
+		list := pasteList{Pastes: make([]*pasteResult, 0, len(pastes)), Total: total, Page: page}
+		for _, p := range pastes {
+			list.Pastes = append(list.Pastes, s.newPasteResult(c, p))
+		}
+		return c.JSON(http.StatusOK, list)
+	}
+
+	pageURL := func(page int) string {
+		query := c.QueryParams()
+		query.Set("page", strconv.Itoa(page))
+		return "?" + query.Encode()
+	}
+	data := map[string]interface{}{
+		"Pastes":    pastes,
+		"Total":     total,
+		"Page":      page,
+		"Languages": s.languages.Languages(),
+		"Query":     filter.Query,
+		"Language":  filter.Language,
+		"Since":     c.QueryParam("since"),
+		"Until":     c.QueryParam("until"),
+		"Owner":     c.QueryParam("owner"),
+	}
+	if page > 1 {
+		data["PrevURL"] = pageURL(page - 1)
+	}
+	if start+pastePageSize < total {
+		data["NextURL"] = pageURL(page + 1)
+	}
+	return c.Render(http.StatusOK, "codelist.html", data)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_indexWords(t *testing.T) {
+	assert.Equal(t, []string{"func", "main", "println", "hello_world"}, indexWords("func main() { println(\"Hello_World\") } // MAIN a"))
+	assert.Equal(t, []string{"你好"}, indexWords("你好, x"))
+	assert.Empty(t, indexWords("a + b"))
+}
+
+func Test_pasteIndex(t *testing.T) {
+	index := newPasteIndex()
+	index.add("1", "package main", "func main() {}")
+	index.add("2", "package util")
+	assert.Equal(t, map[string]struct{}{"1": {}, "2": {}}, index.search("package"))
+	assert.Equal(t, map[string]struct{}{"1": {}}, index.search("MAIN package"))
+	assert.Empty(t, index.search("main util"))
+	assert.Nil(t, index.search("+"))
+}
+
+func Test_parseListDate(t *testing.T) {
+	day, err := parseListDate("2024-03-01", false)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), day)
+	end, err := parseListDate("2024-03-01", true)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 3, 1, 23, 59, 59, 999999999, time.UTC), end)
+	_, err = parseListDate("yesterday", false)
+	assert.Error(t, err)
+}
+
+func TestPasteList(t *testing.T) {
+	s := newTestCodeServer(t, &config.Config{})
+
+	create := func(lang, code, token string, private bool) {
+		header := http.Header{authTokenHeader: {token}}
+		if private {
+			header.Set(privateHeader, "1")
+		}
+		_, status := s.createPaste(code, "lang="+lang, header)
+		assert.Equal(t, http.StatusCreated, status)
+	}
+	list := func(query, token string) (*pasteList, int) {
+		rec := s.get("/code/list?format=json&"+query, http.Header{authTokenHeader: {token}})
+		if rec.Code != http.StatusOK {
+			return nil, rec.Code
+		}
+		l := &pasteList{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), l))
+		return l, rec.Code
+	}
+	languagesOf := func(l *pasteList) []string {
+		var languages []string
+		for _, p := range l.Pastes {
+			languages = append(languages, p.Language)
+		}
+		return languages
+	}
+
+	create("go", "package main\n\nfunc main() {}\n", "alice", false)
+	create("python", "def main():\n    pass\n", "bob", false)
+	create("bash", "echo secret main\n", "alice", true)
+
+	l, _ := list("", "")
+	assert.Equal(t, 2, l.Total)
+	assert.ElementsMatch(t, []string{"go", "python"}, languagesOf(l))
+	l, _ = list("lang=py", "")
+	assert.Equal(t, []string{"python"}, languagesOf(l))
+	l, _ = list("q=MAIN+func", "")
+	assert.Equal(t, []string{"go"}, languagesOf(l))
+	// the private pastes are not indexed
+	l, _ = list("q=secret", "alice")
+	assert.Empty(t, l.Pastes)
+	l, _ = list("owner=me", "alice")
+	assert.ElementsMatch(t, []string{"go", "bash"}, languagesOf(l))
+	l, _ = list("owner="+store.TokenOwner("alice"), "")
+	assert.Equal(t, []string{"go"}, languagesOf(l))
+	l, _ = list("until=2000-01-01", "")
+	assert.Empty(t, l.Pastes)
+	l, _ = list("since="+time.Now().UTC().Format(time.DateOnly), "")
+	assert.Equal(t, 2, l.Total)
+
+	for _, query := range []string{"lang=cobol", "since=yesterday", "owner=me"} {
+		_, status := list(query, "")
+		assert.Equal(t, http.StatusBadRequest, status, query)
+	}
+
+	// the index is built from the store
+	s2 := NewCodeServer(&config.Config{}, s.store, nil)
+	assert.NoError(t, s2.Setup(echo.New()))
+	found, err := s2.findPastes(context.Background(), &pasteFilter{Query: "pass"}, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "python", found[0].Language)
+	}
+}
+
+// jsonRenderer renders the data of the templates as JSON
+type jsonRenderer struct{}
+
+func (jsonRenderer) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
+	return json.NewEncoder(w).Encode(data)
+}
+
+func TestPastesHiddenFromFiles(t *testing.T) {
+	cfg := &config.Config{Upload: config.UploadConfig{Naming: "timestamp"}, Code: config.CodeConfig{Dedup: true}}
+	st := store.NewMetaStore(store.NewMemStore())
+	e := echo.New()
+	e.Renderer = jsonRenderer{}
+	assert.NoError(t, NewFileServer(cfg, st, nil).Setup(e))
+	s := NewCodeServer(cfg, st, nil)
+	assert.NoError(t, s.Setup(e))
+	code := &testCodeServer{CodeServer: s, t: t, e: e}
+
+	private, status := code.createPaste("echo private\n", "lang=bash", http.Header{privateHeader: {"1"}})
+	assert.Equal(t, http.StatusCreated, status)
+	protected, status := code.createPaste("echo protected\n", "lang=bash", http.Header{passwordHeader: {"hunter2"}})
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, http.StatusOK, serveTest(e, http.MethodPut, "/upload/docs/a.txt", strings.NewReader("a"), nil).Code)
+
+	// the file listing shows neither the code nor the records
+	rec := serveTest(e, http.MethodGet, "/download/", nil, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"docs"`)
+	assert.NotContains(t, rec.Body.String(), `"`+store.PasteDir+`"`)
+	for _, p := range []*pasteResult{private, protected} {
+		for _, key := range []string{store.PasteDir + "/", pasteDir + "/", pasteDir + "/bash/", pasteRecordDir + "/", pasteHashDir + "/", p.Key, pasteRecordKey(p.ID), "/" + pasteRecordKey(p.ID) + "?versions"} {
+			rec = serveTest(e, http.MethodGet, "/download/"+key, nil, nil)
+			assert.Equal(t, http.StatusNotFound, rec.Code, key)
+			assert.NotContains(t, rec.Body.String(), p.ID, key)
+		}
+		assert.Equal(t, http.StatusForbidden, serveTest(e, http.MethodDelete, "/delete/"+pasteRecordKey(p.ID), nil, nil).Code)
+		rec = serveTest(e, http.MethodPut, "/upload/"+pasteRecordKey(p.ID), strings.NewReader("{}"), http.Header{overwriteHeader: {overwriteAlways}})
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, http.StatusOK, code.get(p.Path()+"/raw", http.Header{passwordHeader: {"hunter2"}}).Code)
+	}
+
+	// the paths which are not pastes are the users' own
+	for _, key := range []string{"code/go/a.go", "pastes/a.json", "paste-hashes/a"} {
+		assert.Equal(t, http.StatusOK, serveTest(e, http.MethodPut, "/upload/"+key, strings.NewReader("a"), nil).Code, key)
+		assert.Equal(t, "a", serveTest(e, http.MethodGet, "/download/"+key, nil, nil).Body.String(), key)
+	}
+}
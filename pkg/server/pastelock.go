@@ -0,0 +1,335 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/pastecrypt"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/time/rate"
+)
+
+// encryptedHeader marks a new paste as end-to-end encrypted, the form field encrypted does the same
+const encryptedHeader = "X-Encrypted"
+
+// passwordHeader sets the password of a new paste and unlocks a protected paste, the form field password
+// does the same
+const passwordHeader = "X-Paste-Password"
+
+// the Argon2id parameters of the new passwords, RFC 9106 recommends them if much memory is not available
+const (
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2SaltLen = 16
+)
+
+// pasteKeyContext keeps the unlocked key of a protected paste in the request
+const pasteKeyContext = "pasteKey:"
+
+// unlockThrottledContext marks a request whose password was not tried, the client tried too many
+const unlockThrottledContext = "unlockThrottled"
+
+// every password attempt derives a key with 64 MiB of Argon2id, the attempts of every client are limited to
+// unlockBurst at once and then one per unlockInterval. The attempts are not limited per paste, the clients
+// would lock out the owner of the paste.
+const (
+	unlockInterval = 6 * time.Second
+	unlockBurst    = 5
+)
+
+// maxDerivations bounds the Argon2id derivations running at once, of the new passwords and the attempts, so
+// that they take at most maxDerivations * 64 MiB
+const maxDerivations = 4
+
+func newUnlockLimiters() *clientLimiters {
+	return newClientLimiters(func() *rate.Limiter {
+		return rate.NewLimiter(rate.Every(unlockInterval), unlockBurst)
+	})
+}
+
+// allowUnlock reports whether the request may try a password of the paste, it is counted against the clients
+// of the request
+func (s *CodeServer) allowUnlock(c echo.Context) bool {
+	if allowAll(s.unlocks, requestClients(c)) {
+		return true
+	}
+	c.Set(unlockThrottledContext, true)
+	c.Response().Header().Set("Retry-After", fmt.Sprintf("%.0f", unlockInterval.Seconds()))
+	return false
+}
+
+// derive runs the Argon2id derivation once less than maxDerivations run, the error of the context is returned
+// if it is done before
+func (s *CodeServer) derive(ctx context.Context, derive func() error) error {
+	select {
+	case s.derivations <- struct{}{}:
+		defer func() { <-s.derivations }()
+		return derive()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// PastePassword protects a paste with a password. The files are encrypted with AES-256-GCM with the key
+// derived from the password by Argon2id, only the hash of the key is kept, so neither the record nor the
+// stored files reveal the code.
+type PastePassword struct {
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	// KeyHash is the SHA-256 of the derived key, it checks the password
+	KeyHash []byte `json:"keyHash"`
+}
+
+// newPastePassword returns the protection of the password and the key of the files
+func newPastePassword(password string) (*PastePassword, []byte, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+	p := &PastePassword{Salt: salt, Time: argon2Time, Memory: argon2Memory, Threads: argon2Threads}
+	key := p.key(password)
+	hash := sha256.Sum256(key)
+	p.KeyHash = hash[:]
+	return p, key, nil
+}
+
+func (p *PastePassword) key(password string) []byte {
+	return argon2.IDKey([]byte(password), p.Salt, p.Time, p.Memory, p.Threads, 32)
+}
+
+// check reports whether the key is the one of the password
+func (p *PastePassword) check(key []byte) bool {
+	hash := sha256.Sum256(key)
+	return subtle.ConstantTimeCompare(hash[:], p.KeyHash) == 1
+}
+
+// unlock returns the key of the password, nil if the password is wrong
+func (p *PastePassword) unlock(password string) []byte {
+	if key := p.key(password); p.check(key) {
+		return key
+	}
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptCode encrypts the code of a protected paste, the nonce is prepended
+func encryptCode(key []byte, code string) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, []byte(code), nil), nil
+}
+
+// decryptCode decrypts the code of a protected paste
+func decryptCode(key, data []byte) (string, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("the encrypted code is truncated")
+	}
+	code, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	return string(code), err
+}
+
+// requestPassword returns the password of the header or the form, never of the query which is logged
+func requestPassword(c echo.Context) string {
+	if password := c.Request().Header.Get(passwordHeader); password != "" {
+		return password
+	}
+	if c.Request().Form != nil {
+		return c.Request().Form.Get("password")
+	}
+	return ""
+}
+
+func unlockCookieName(p *Paste) string {
+	return "paste-" + p.ID
+}
+
+// unlockPaste reports whether the request may read the paste. The key of a protected paste is derived from
+// the password header or taken from the cookie of the unlock page, and kept in the request for readCode.
+func (s *CodeServer) unlockPaste(c echo.Context, p *Paste) bool {
+	if p.Password == nil || c.Get(pasteKeyContext+p.ID) != nil {
+		return true
+	}
+	var key []byte
+	if password := c.Request().Header.Get(passwordHeader); password != "" {
+		if !s.allowUnlock(c) {
+			return false
+		}
+		unlock := func() error {
+			key = p.Password.unlock(password)
+			return nil
+		}
+		if err := s.derive(c.Request().Context(), unlock); err != nil {
+			return false
+		}
+	} else if cookie, err := c.Cookie(unlockCookieName(p)); err == nil {
+		if cookieKey, err := base64.RawURLEncoding.DecodeString(cookie.Value); err == nil && p.Password.check(cookieKey) {
+			key = cookieKey
+		}
+	}
+	if key == nil {
+		return false
+	}
+	c.Set(pasteKeyContext+p.ID, key)
+	return true
+}
+
+// lockedPaste answers the request of a protected paste without the password, the page asks for it
+func lockedPaste(c echo.Context, p *Paste) error {
+	if c.Get(unlockThrottledContext) != nil {
+		return c.String(http.StatusTooManyRequests, "Too many password attempts")
+	}
+	if c.Path() == "/code/:lang/:hash" && pasteFormat(c) == formatHTML {
+		return c.Render(http.StatusUnauthorized, "codepassword.html", map[string]interface{}{
+			"Paste": p,
+			"File":  c.QueryParam("file"),
+		})
+	}
+	return c.String(http.StatusUnauthorized, "Password required")
+}
+
+// handleUnlock checks the password of the unlock page and keeps the key in a cookie for the session
+func (s *CodeServer) handleUnlock(c echo.Context) error {
+	paste, err := s.loadPaste(context.Background(), c.Param("lang"), c.Param("hash"))
+	if err != nil {
+		c.Logger().Errorf("Error loading the paste %s: %v", c.Param("hash"), err)
+		return c.String(http.StatusInternalServerError, "Error checking file")
+	}
+	if paste == nil {
+		return c.String(http.StatusNotFound, "Code not found")
+	}
+	target := paste.Path()
+	if file := c.FormValue("file"); file != "" {
+		target += "?file=" + url.QueryEscape(file)
+	}
+	if paste.Password == nil {
+		return c.Redirect(http.StatusSeeOther, target)
+	}
+	if !s.allowUnlock(c) {
+		return c.Render(http.StatusTooManyRequests, "codepassword.html", map[string]interface{}{
+			"Paste": paste,
+			"File":  c.FormValue("file"),
+			"Error": "尝试次数过多, 请稍后再试",
+		})
+	}
+	var key []byte
+	password := c.FormValue("password")
+	unlock := func() error {
+		key = paste.Password.unlock(password)
+		return nil
+	}
+	if err := s.derive(c.Request().Context(), unlock); err != nil {
+		return c.String(http.StatusServiceUnavailable, "Request canceled")
+	}
+	if key == nil {
+		return c.Render(http.StatusUnauthorized, "codepassword.html", map[string]interface{}{
+			"Paste": paste,
+			"File":  c.FormValue("file"),
+			"Error": "密码错误",
+		})
+	}
+	cookie := &http.Cookie{
+		Name:     unlockCookieName(paste),
+		Value:    base64.RawURLEncoding.EncodeToString(key),
+		Path:     "/code/",
+		HttpOnly: true,
+		Secure:   s.cfg.EnableTls,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if paste.ExpiresAt != nil {
+		cookie.Expires = *paste.ExpiresAt
+	}
+	c.SetCookie(cookie)
+	return c.Redirect(http.StatusSeeOther, target)
+}
+
+// copyCode writes a file of the paste, the code of a protected paste is decrypted with the key unlocked by
+// unlockPaste
+func (s *CodeServer) copyCode(c echo.Context, w io.Writer, p *Paste, file *PasteFile) error {
+	if p.Password == nil {
+		return s.store.DownloadFile(context.Background(), w, file.Key)
+	}
+	key, _ := c.Get(pasteKeyContext + p.ID).([]byte)
+	if key == nil {
+		return errors.New("the paste is locked")
+	}
+	buffer := bytes.NewBuffer(nil)
+	if err := s.store.DownloadFile(context.Background(), buffer, file.Key); err != nil {
+		return err
+	}
+	code, err := decryptCode(key, buffer.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, code)
+	return err
+}
+
+// readCode returns the code of a file of a paste
+func (s *CodeServer) readCode(c echo.Context, p *Paste, file *PasteFile) (string, error) {
+	buffer := bytes.NewBuffer(nil)
+	if err := s.copyCode(c, throttleWriter(c, buffer), p, file); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// checkEncryptedPaste checks a new end-to-end encrypted paste, it is a single sealed file whose names and
+// languages are sealed too
+func checkEncryptedPaste(req *pasteRequest) error {
+	if len(req.Files) != 1 {
+		return errors.New("An encrypted paste is a single sealed file")
+	}
+	if req.Parent != "" {
+		return errors.New("Encrypted pastes cannot be revised")
+	}
+	if err := pastecrypt.Valid(req.Files[0].Content); err != nil {
+		return errors.New("Invalid encrypted paste")
+	}
+	return nil
+}
+
+// showEncryptedPaste answers the sealed paste, the page decrypts it in the browser with the key of the URL
+// fragment, the terminal gets the sealed paste for the paste command
+func showEncryptedPaste(c echo.Context, p *Paste, sealed string) error {
+	if pasteFormat(c) != formatHTML {
+		return c.String(http.StatusOK, sealed)
+	}
+	return c.Render(http.StatusOK, "codecrypt.html", map[string]interface{}{
+		"Paste":  p,
+		"Sealed": strings.TrimSpace(sealed),
+	})
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/graydovee/fileManager/pkg/pastecrypt"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_encryptCode(t *testing.T) {
+	password, key, err := newPastePassword("secret")
+	assert.NoError(t, err)
+	assert.Equal(t, key, password.unlock("secret"))
+	assert.Nil(t, password.unlock("wrong"))
+
+	data, err := encryptCode(key, "TOKEN=1\n")
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "TOKEN")
+	code, err := decryptCode(key, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "TOKEN=1\n", code)
+	_, err = decryptCode(password.unlock("secret")[:16], data)
+	assert.Error(t, err)
+}
+
+func TestProtectedPaste(t *testing.T) {
+	ctx := context.Background()
+	s := newTestCodeServer(t, &config.Config{Code: config.CodeConfig{Dedup: true}})
+
+	password := http.Header{passwordHeader: {"hunter2"}}
+	result, status := s.createPaste("export TOKEN=secret-token\n", "lang=bash", password)
+	if !assert.Equal(t, http.StatusCreated, status) {
+		return
+	}
+	assert.Nil(t, result.Password)
+	assert.True(t, result.Protected)
+
+	// the store keeps the code encrypted
+	paste, err := s.loadPasteByID(ctx, result.ID)
+	assert.NoError(t, err)
+	assert.NotNil(t, paste.Password)
+	stored := bytes.NewBuffer(nil)
+	assert.NoError(t, s.store.DownloadFile(ctx, stored, paste.Key))
+	assert.NotContains(t, stored.String(), "secret-token")
+
+	curl := http.Header{"User-Agent": {"curl/8.5.0"}}
+	for _, path := range []string{paste.Path(), paste.Path() + "/raw", paste.Path() + "/zip", paste.Path() + "/edit"} {
+		assert.Equal(t, http.StatusUnauthorized, s.get(path, curl).Code, path)
+	}
+	assert.Equal(t, http.StatusUnauthorized, s.get(paste.Path()+"/raw", http.Header{passwordHeader: {"wrong"}}).Code)
+	rec := s.get(paste.Path()+"/raw", password)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "export TOKEN=secret-token\n", rec.Body.String())
+
+	// the unlock form keeps the key in a cookie
+	form := url.Values{"password": {"hunter2"}, "file": {"x"}}
+	rec = serveTest(s.e, http.MethodPost, paste.Path()+"/unlock", strings.NewReader(form.Encode()),
+		http.Header{echo.HeaderContentType: {echo.MIMEApplicationForm}})
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, paste.Path()+"?file=x", rec.Header().Get("Location"))
+	cookies := rec.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.True(t, cookies[0].HttpOnly)
+	rec = s.get(paste.Path(), http.Header{"User-Agent": {"curl/8.5.0"}, "Cookie": {cookies[0].Name + "=" + cookies[0].Value}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "secret-token")
+	assert.Equal(t, http.StatusUnauthorized, s.get(paste.Path()+"/raw", http.Header{"Cookie": {cookies[0].Name + "=AAAA"}}).Code)
+
+	// the protected pastes are neither searched nor deduplicated
+	index, err := s.searchIndex(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, index.search("secret"))
+	again, _ := s.createPaste("export TOKEN=secret-token\n", "lang=bash", password)
+	if assert.NotNil(t, again) {
+		assert.NotEqual(t, result.ID, again.ID)
+	}
+}
+
+func TestEncryptedPaste(t *testing.T) {
+	s := newTestCodeServer(t, &config.Config{})
+
+	key, err := pastecrypt.NewKey()
+	assert.NoError(t, err)
+	sealed, err := pastecrypt.Seal(key, &pastecrypt.Payload{Files: []pastecrypt.File{{Name: "main.go", Content: "package main\n"}}})
+	assert.NoError(t, err)
+
+	for _, body := range []string{
+		`{"encrypted": true, "files": [{"content": "package main"}]}`,
+		`{"encrypted": true, "files": [{"content": "` + sealed + `"}, {"content": "` + sealed + `"}]}`,
+	} {
+		_, status := s.postPaste(body)
+		assert.Equal(t, http.StatusBadRequest, status)
+	}
+	result, status := s.postPaste(`{"encrypted": true, "files": [{"content": "` + sealed + `"}]}`)
+	if !assert.Equal(t, http.StatusCreated, status) {
+		return
+	}
+	assert.Equal(t, fallbackLanguage, result.Language)
+	path, id := result.Path(), result.ID
+
+	// the server answers the sealed paste and cannot revise or compare it
+	curl := http.Header{"User-Agent": {"curl/8.5.0"}}
+	rec := s.get(path, curl)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, sealed, rec.Body.String())
+	assert.Equal(t, sealed, s.get(path+"/raw", curl).Body.String())
+	assert.Equal(t, http.StatusBadRequest, s.get(path+"/edit", curl).Code)
+	_, status = s.postPaste(`{"parent": "` + id + `", "files": [{"content": "x"}]}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, http.StatusBadRequest, s.get(path+"/diff?from="+id, curl).Code)
+}
+
+func TestUnlockAttempts(t *testing.T) {
+	s := newTestCodeServer(t, &config.Config{})
+	s.e.Renderer = jsonRenderer{}
+	paste, status := s.createPaste("echo secret\n", "lang=bash", http.Header{passwordHeader: {"hunter2"}})
+	if !assert.Equal(t, http.StatusCreated, status) {
+		return
+	}
+
+	for i := 0; i < unlockBurst; i++ {
+		assert.Equal(t, http.StatusUnauthorized, s.get(paste.Path()+"/raw", http.Header{passwordHeader: {"wrong"}}).Code)
+	}
+	// the password is not tried once the attempts are used up, neither by the header nor by the form
+	rec := s.get(paste.Path()+"/raw", http.Header{passwordHeader: {"hunter2"}})
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "6", rec.Header().Get("Retry-After"))
+	form := url.Values{"password": {"hunter2"}}
+	rec = serveTest(s.e, http.MethodPost, paste.Path()+"/unlock", strings.NewReader(form.Encode()),
+		http.Header{echo.HeaderContentType: {echo.MIMEApplicationForm}})
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Empty(t, rec.Result().Cookies())
+
+	// the attempts are counted per client, the others still unlock the paste
+	req := httptest.NewRequest(http.MethodGet, paste.Path()+"/raw", nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	req.Header.Set(passwordHeader, "hunter2")
+	rec = httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestDeriveLimit(t *testing.T) {
+	s := newTestCodeServer(t, &config.Config{})
+	for i := 0; i < maxDerivations; i++ {
+		s.derivations <- struct{}{}
+	}
+
+	// no derivation runs while the slots are taken
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ran := false
+	err := s.derive(ctx, func() error {
+		ran = true
+		return nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, ran)
+
+	<-s.derivations
+	assert.NoError(t, s.derive(context.Background(), func() error {
+		ran = true
+		return nil
+	}))
+	assert.True(t, ran)
+	assert.Len(t, s.derivations, maxDerivations-1)
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/labstack/echo/v4"
+)
+
+// authTokenHeader carries the token the quotas of a client are counted by, Authorization: Bearer works as well.
+// The tokens are not authenticated here, the token quotas rely on a proxy which does.
+const authTokenHeader = "X-Auth-Token"
+
+func (f *FilerServer) setupQuota(e *echo.Echo) {
+	if f.quotaStore() == nil {
+		return
+	}
+	e.GET("/quota", f.quotaHandler)
+	if f.cfg.Store.Quota.AdminToken != "" {
+		e.POST("/quota/rebuild", f.rebuildQuotaHandler)
+	}
+}
+
+func (f *FilerServer) quotaStore() *store.QuotaStore {
+	quotaStore, _ := store.As[*store.QuotaStore](f.store)
+	return quotaStore
+}
+
+// requestToken returns the token of the request, empty if there is none
+func requestToken(c echo.Context) string {
+	token := c.Request().Header.Get(authTokenHeader)
+	if auth := c.Request().Header.Get("Authorization"); token == "" && len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		token = strings.TrimSpace(auth[7:])
+	}
+	return token
+}
+
+// requestOwner returns the owner of the token of the request, empty if there is no token
+func requestOwner(c echo.Context) string {
+	token := requestToken(c)
+	if token == "" {
+		return ""
+	}
+	return store.TokenOwner(token)
+}
+
+// uploadContext attributes the uploads of the request to the owner of its token
+func uploadContext(c echo.Context) context.Context {
+	ctx := context.Background()
+	if owner := requestOwner(c); owner != "" {
+		ctx = store.WithOwner(ctx, owner)
+	}
+	return ctx
+}
+
+// refreshQuota updates the usage of a file changed by a decorator below the quota, e.g. a restore
+func (f *FilerServer) refreshQuota(c echo.Context, file string) {
+	if quotaStore := f.quotaStore(); quotaStore != nil {
+		if err := quotaStore.Refresh(context.Background(), file); err != nil {
+			c.Logger().Errorf("Error refreshing the quota usage of %s: %s", file, err.Error())
+		}
+	}
+}
+
+func (f *FilerServer) quotaHandler(c echo.Context) error {
+	return c.JSON(http.StatusOK, f.quotaStore().Usage(requestOwner(c)))
+}
+
+// rebuildQuotaHandler scans the store for the usage, only the admin token may start the scan
+func (f *FilerServer) rebuildQuotaHandler(c echo.Context) error {
+	if subtle.ConstantTimeCompare([]byte(requestToken(c)), []byte(f.cfg.Store.Quota.AdminToken)) != 1 {
+		return c.String(http.StatusForbidden, "Forbidden")
+	}
+	if err := f.quotaStore().Rebuild(context.Background()); err != nil {
+		c.Logger().Errorf("Error rebuilding the quota usage: %s", err.Error())
+		return c.String(http.StatusInternalServerError, "Error rebuilding the quota usage")
+	}
+	return c.JSON(http.StatusOK, f.quotaStore().Usage(requestOwner(c)))
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebuildQuota(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Store.Quota.MaxBytes = 1 << 20
+	e := newTestFileServer(t, cfg)
+	rec := serveTest(e, http.MethodPost, "/quota/rebuild", nil, http.Header{authTokenHeader: {"admin"}})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	// only the admin token rebuilds the usage
+	cfg = &config.Config{}
+	cfg.Store.Quota.MaxBytes = 1 << 20
+	cfg.Store.Quota.AdminToken = "admin"
+	e = newTestFileServer(t, cfg)
+	for _, header := range []http.Header{nil, {authTokenHeader: {"user"}}, {"Authorization": {"Bearer admi"}}} {
+		rec = serveTest(e, http.MethodPost, "/quota/rebuild", nil, header)
+		assert.Equal(t, http.StatusForbidden, rec.Code, header)
+	}
+	rec = serveTest(e, http.MethodPost, "/quota/rebuild", nil, http.Header{"Authorization": {"Bearer admin"}})
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/labstack/echo/v4"
+)
+
+// expiresInHeader sets the ttl of an upload, the form field expires_in does the same for form uploads
+const expiresInHeader = "X-Expires-In"
+
+func (f *FilerServer) setupRetention() error {
+	retention, err := store.NewRetentionFromConfig(&f.cfg.Store.Retention)
+	if err != nil {
+		return err
+	}
+	f.retention = retention
+
+	if interval := f.cfg.Store.Retention.Interval; interval > 0 {
+		go f.sweepExpired(interval)
+	}
+	return nil
+}
+
+// sweepExpired deletes the expired files periodically
+func (f *FilerServer) sweepExpired(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		deleted, err := f.retention.Sweep(context.Background(), f.store)
+		if err != nil {
+			log.Printf("Error deleting the expired files: %s\n", err.Error())
+		} else if deleted > 0 {
+			log.Printf("%d expired files deleted\n", deleted)
+		}
+	}
+}
+
+// uploadMetadata returns the metadata of the uploaded file from the request
+func (f *FilerServer) uploadMetadata(c echo.Context) (map[string]string, error) {
+	expiresIn := uploadOption(c, expiresInHeader, "expires_in")
+	if expiresIn == "" {
+		return nil, nil
+	}
+
+	ttl, err := config.ParseDuration(expiresIn)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("invalid expires in %q", expiresIn)
+	}
+	return map[string]string{
+		store.MetaExpiresAt: time.Now().Add(ttl).UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// expiresAt returns the expiry time of the listed files by name
+func (f *FilerServer) expiresAt(dir string, metas []*store.FileMeta) map[string]time.Time {
+	expiresAt := make(map[string]time.Time)
+	if f.retention == nil {
+		return expiresAt
+	}
+	for _, meta := range metas {
+		if meta.IsDir {
+			continue
+		}
+		if t, ok := f.retention.ExpiresAt(path.Join(dir, meta.Name), meta); ok {
+			expiresAt[meta.Name] = t
+		}
+	}
+	return expiresAt
+}
@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// parentHeader creates the paste as a revision of the paste of the ID, the form field parent does the same
+	parentHeader = "X-Parent"
+	// forkHeader makes the paste a fork of the parent, which starts a new revision chain
+	forkHeader = "X-Fork"
+)
+
+const (
+	diffViewUnified = "unified"
+	diffViewSplit   = "split"
+)
+
+// formatText answers the diff as plain text, e.g. for patch
+const formatText = "text"
+
+// pasteParent returns the paste of the ID the new paste is a revision or a fork of, nil if the ID is empty.
+// Only the author of a paste may revise it, the others fork it. A paste without an author, e.g. pasted
+// anonymously, can only be forked.
+func (s *CodeServer) pasteParent(c echo.Context, id string, fork bool) (*Paste, bool, int, error) {
+	if id == "" {
+		return nil, false, http.StatusOK, nil
+	}
+	parent, err := s.loadPasteByID(context.Background(), id)
+	if err != nil {
+		c.Logger().Errorf("Error loading the paste %s: %v", id, err)
+		return nil, false, http.StatusInternalServerError, errors.New("Error loading the parent paste")
+	}
+	if parent == nil {
+		return nil, false, http.StatusBadRequest, errors.New("Parent paste not found")
+	}
+	if parent.Encrypted {
+		return nil, false, http.StatusBadRequest, errors.New("Encrypted pastes cannot be revised")
+	}
+	if !fork && !parent.OwnedBy(requestOwner(c)) {
+		return nil, false, http.StatusForbidden, errors.New("Only the author can revise the paste, fork it instead")
+	}
+	return parent, fork, http.StatusOK, nil
+}
+
+// handleEditPage renders the upload page filled with the paste, the new paste is a revision of it, or a fork
+// at /code/:lang/:hash/fork and for the others than the author
+func (s *CodeServer) handleEditPage(c echo.Context) error {
+	paste, ok, err := s.findPaste(c)
+	if !ok {
+		return err
+	}
+	if paste.Encrypted {
+		return c.String(http.StatusBadRequest, "Encrypted pastes cannot be revised")
+	}
+	var files []pasteInput
+	for _, file := range paste.PasteFiles() {
+		code, err := s.readCode(c, paste, &file)
+		if err != nil {
+			c.Logger().Errorf("Failed to download code %s: %v", file.Key, err)
+			return c.String(http.StatusInternalServerError, "Failed to download code")
+		}
+		input := pasteInput{Language: file.Language, Content: code}
+		// the file of a single-file paste is named after its key, it stays unnamed
+		if len(paste.Files) > 0 {
+			input.Name = file.Name
+		}
+		files = append(files, input)
+	}
+	return c.Render(http.StatusOK, "code.html", map[string]interface{}{
+		"Languages": s.languages.Languages(),
+		"Parent":    paste,
+		"Files":     files,
+		// the pastes of somebody else are only forked
+		"Fork": strings.HasSuffix(c.Path(), "/fork") || !paste.OwnedBy(requestOwner(c)),
+	})
+}
+
+// fileDiff is the diff of a file of two pastes, the name of a missing file is /dev/null
+type fileDiff struct {
+	Name    string
+	OldName string
+	NewName string
+	Lines   []diffLine
+	Hunks   []diffHunk
+	Rows    []diffRow
+}
+
+// Changed reports whether the file differs
+func (d *fileDiff) Changed() bool {
+	for _, l := range d.Lines {
+		if l.Op != diffEqual {
+			return true
+		}
+	}
+	return false
+}
+
+// Unified is the unified diff of the file
+func (d *fileDiff) Unified() string {
+	return unifiedDiff(d.OldName, d.NewName, d.Lines)
+}
+
+// diffPastes compares the files of the same name of the pastes, the files of the new paste first and then
+// the removed files. The files of two single-file pastes are compared whatever their names.
+func (s *CodeServer) diffPastes(c echo.Context, from, paste *Paste) ([]*fileDiff, error) {
+	oldFiles, newFiles := from.PasteFiles(), paste.PasteFiles()
+	type pair struct {
+		diff     *fileDiff
+		old, new *PasteFile
+	}
+	var pairs []pair
+	if len(oldFiles) == 1 && len(newFiles) == 1 {
+		pairs = append(pairs, pair{&fileDiff{OldName: from.ID, NewName: paste.ID}, &oldFiles[0], &newFiles[0]})
+	} else {
+		for i := range newFiles {
+			d := &fileDiff{Name: newFiles[i].Name, OldName: "/dev/null", NewName: "b/" + newFiles[i].Name}
+			old := from.File(newFiles[i].Name)
+			if old != nil {
+				d.OldName = "a/" + old.Name
+			}
+			pairs = append(pairs, pair{d, old, &newFiles[i]})
+		}
+		for i := range oldFiles {
+			if paste.File(oldFiles[i].Name) == nil {
+				d := &fileDiff{Name: oldFiles[i].Name, OldName: "a/" + oldFiles[i].Name, NewName: "/dev/null"}
+				pairs = append(pairs, pair{d, &oldFiles[i], nil})
+			}
+		}
+	}
+
+	diffs := make([]*fileDiff, 0, len(pairs))
+	owners := [2]*Paste{from, paste}
+	for _, p := range pairs {
+		var codes [2]string
+		for i, file := range []*PasteFile{p.old, p.new} {
+			if file == nil {
+				continue
+			}
+			code, err := s.readCode(c, owners[i], file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to download code %s: %w", file.Key, err)
+			}
+			codes[i] = code
+		}
+		p.diff.Lines = diffLines(splitLines(codes[0]), splitLines(codes[1]))
+		diffs = append(diffs, p.diff)
+	}
+	return diffs, nil
+}
+
+// handleCodeDiff compares the paste of the from query, by default its parent, with the paste, as a unified
+// or side-by-side page, or as a unified diff for curl. The files of multi-file pastes are compared by name.
+func (s *CodeServer) handleCodeDiff(c echo.Context) error {
+	paste, ok, err := s.findPaste(c)
+	if !ok {
+		return err
+	}
+	fromID := c.QueryParam("from")
+	if fromID == "" {
+		fromID = paste.Parent
+	}
+	if fromID == "" {
+		return c.String(http.StatusBadRequest, "Nothing to compare, the paste has no parent")
+	}
+	from, err := s.loadPasteByID(context.Background(), fromID)
+	if err != nil {
+		c.Logger().Errorf("Error loading the paste %s: %v", fromID, err)
+		return c.String(http.StatusInternalServerError, "Error checking file")
+	}
+	// the parent private to somebody else is not compared by default, like it is not linked
+	if from == nil || (c.QueryParam("from") == "" && !from.VisibleTo(requestOwner(c))) {
+		return c.String(http.StatusNotFound, "Code not found")
+	}
+	if from.Encrypted || paste.Encrypted {
+		return c.String(http.StatusBadRequest, "Encrypted pastes cannot be compared")
+	}
+	if !s.unlockPaste(c, from) {
+		return lockedPaste(c, from)
+	}
+
+	diffs, err := s.diffPastes(c, from, paste)
+	if err != nil {
+		c.Logger().Errorf("Failed to compare %s with %s: %v", from.ID, paste.ID, err)
+		return c.String(http.StatusInternalServerError, "Failed to download code")
+	}
+
+	c.Response().Header().Add("Vary", "User-Agent")
+	if format := pasteFormat(c); format == formatText || format == formatANSI {
+		var sb strings.Builder
+		for _, d := range diffs {
+			// the unchanged files of multi-file pastes are left out like git diff does
+			if len(diffs) > 1 && !d.Changed() {
+				continue
+			}
+			if format == formatANSI {
+				sb.WriteString(colorDiff(d.Unified()))
+			} else {
+				sb.WriteString(d.Unified())
+			}
+		}
+		return c.String(http.StatusOK, sb.String())
+	}
+
+	revisions, err := s.pasteRevisions(context.Background(), paste, requestOwner(c))
+	if err != nil {
+		c.Logger().Errorf("Error listing the revisions of %s: %v", paste.ID, err)
+		return c.String(http.StatusInternalServerError, "Error listing the revisions")
+	}
+	view := diffViewUnified
+	if c.QueryParam("view") == diffViewSplit {
+		view = diffViewSplit
+	}
+	for _, d := range diffs {
+		if view == diffViewSplit {
+			d.Rows = sideBySide(d.Lines)
+		} else {
+			d.Hunks = diffHunks(d.Lines)
+		}
+	}
+	return c.Render(http.StatusOK, "codediff.html", map[string]interface{}{
+		"From":      from,
+		"Paste":     paste,
+		"Revisions": revisions,
+		"View":      view,
+		"Diffs":     diffs,
+	})
+}
+
+// colorDiff colors the removed lines red and the added lines green
+func colorDiff(diff string) string {
+	lines := strings.SplitAfter(diff, "\n")
+	for i, line := range lines {
+		color := ""
+		switch {
+		case i < 2:
+			// the file names
+			color = "\x1b[1m"
+		case strings.HasPrefix(line, "@@"):
+			color = "\x1b[36m"
+		case strings.HasPrefix(line, "-"):
+			color = "\x1b[31m"
+		case strings.HasPrefix(line, "+"):
+			color = "\x1b[32m"
+		}
+		if color != "" && line != "" {
+			lines[i] = color + strings.TrimSuffix(line, "\n") + "\x1b[0m\n"
+		}
+	}
+	return strings.Join(lines, "")
+}
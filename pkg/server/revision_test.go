@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPasteRevisions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestCodeServer(t, &config.Config{})
+	alice := http.Header{authTokenHeader: {"alice"}}
+	bob := http.Header{authTokenHeader: {"bob"}}
+	revise := func(token http.Header, parent string, fork bool) http.Header {
+		header := http.Header{parentHeader: {parent}}
+		if fork {
+			header.Set(forkHeader, "1")
+		}
+		for key, values := range token {
+			header[key] = values
+		}
+		return header
+	}
+
+	first, _ := s.createPaste("package main\n\nfunc main() {}\n", "", alice)
+	if !assert.NotNil(t, first) {
+		return
+	}
+	assert.Equal(t, first.ID, first.ChainRoot())
+	assert.Equal(t, 1, first.Rev())
+
+	// the revision keeps the language of its parent
+	second, _ := s.createPaste("package main\n\nfunc main() {\n\tprintln(1)\n}\n", "", revise(alice, first.ID, false))
+	if assert.NotNil(t, second) {
+		assert.Equal(t, "go", second.Language)
+		assert.Equal(t, first.ID, second.Parent)
+		assert.Equal(t, first.ID, second.Root)
+		assert.Equal(t, 2, second.Rev())
+		assert.False(t, second.Forked())
+	}
+
+	// the others fork the paste
+	_, status := s.createPaste("package other\n", "", revise(bob, first.ID, false))
+	assert.Equal(t, http.StatusForbidden, status)
+	_, status = s.createPaste("package other\n", "", revise(bob, "missing", false))
+	assert.Equal(t, http.StatusBadRequest, status)
+	fork, _ := s.createPaste("package other\n", "", revise(bob, first.ID, true))
+	if assert.NotNil(t, fork) {
+		assert.Equal(t, fork.ID, fork.Root)
+		assert.True(t, fork.Forked())
+	}
+
+	revisions, err := s.pasteRevisions(ctx, second.Paste, "")
+	assert.NoError(t, err)
+	if assert.Len(t, revisions, 2) {
+		assert.Equal(t, first.ID, revisions[0].ID)
+		assert.Equal(t, second.ID, revisions[1].ID)
+	}
+
+	rec := s.get(second.Path()+"/diff?format=text", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "--- "+first.ID+"\n+++ "+second.ID+"\n@@ -1,3 +1,5 @@\n package main\n \n-func main() {}\n+func main() {\n+\tprintln(1)\n+}\n", rec.Body.String())
+	assert.Contains(t, s.get(second.Path()+"/diff?format=ansi", nil).Body.String(), "\x1b[32m+\tprintln(1)\x1b[0m")
+	assert.Equal(t, http.StatusBadRequest, s.get(first.Path()+"/diff?format=text", nil).Code)
+	assert.Equal(t, http.StatusNotFound, s.get(first.Path()+"/diff?format=text&from=missing", nil).Code)
+
+	// the anonymous pastes have no author, they are only forked
+	anonymous, _ := s.createPaste("package anonymous\n", "", nil)
+	if assert.NotNil(t, anonymous) {
+		_, status = s.createPaste("package other\n", "", revise(nil, anonymous.ID, false))
+		assert.Equal(t, http.StatusForbidden, status)
+		_, status = s.createPaste("package other\n", "", revise(bob, anonymous.ID, false))
+		assert.Equal(t, http.StatusForbidden, status)
+	}
+	// and so are the pastes created before the records
+	assert.NoError(t, s.store.UploadFile(ctx, strings.NewReader("print(1)\n"), "code/python/legacy.py"))
+	_, status = s.createPaste("print(2)\n", "", revise(nil, "legacy", false))
+	assert.Equal(t, http.StatusForbidden, status)
+	revision, _ := s.createPaste("print(2)\n", "", revise(nil, "legacy", true))
+	if assert.NotNil(t, revision) {
+		assert.Equal(t, "python", revision.Language)
+		assert.Equal(t, "legacy", revision.Parent)
+		assert.True(t, revision.Forked())
+	}
+}
+
+func TestPrivateRevisions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestCodeServer(t, &config.Config{})
+	alice := http.Header{authTokenHeader: {"alice"}}
+	bob := http.Header{authTokenHeader: {"bob"}}
+
+	first, _ := s.createPaste("print(1)\n", "lang=python", alice)
+	if !assert.NotNil(t, first) {
+		return
+	}
+	private, _ := s.createPaste("print(2)\n", "private=1", http.Header{authTokenHeader: {"alice"}, parentHeader: {first.ID}})
+	if !assert.NotNil(t, private) {
+		return
+	}
+	assert.True(t, private.Private)
+
+	// the private revision is only listed for its owner
+	revisions, err := s.pasteRevisions(ctx, first.Paste, first.Author)
+	assert.NoError(t, err)
+	assert.Len(t, revisions, 2)
+	revisions, err = s.pasteRevisions(ctx, first.Paste, "")
+	assert.NoError(t, err)
+	if assert.Len(t, revisions, 1) {
+		assert.Equal(t, first.ID, revisions[0].ID)
+	}
+	// the paste itself is listed whoever views it
+	revisions, err = s.pasteRevisions(ctx, private.Paste, "")
+	assert.NoError(t, err)
+	assert.Len(t, revisions, 2)
+
+	// the fork of a private paste does not lead the others to it
+	fork, _ := s.createPaste("print(3)\n", "", http.Header{authTokenHeader: {"bob"}, parentHeader: {private.ID}, forkHeader: {"1"}})
+	if !assert.NotNil(t, fork) {
+		return
+	}
+	assert.Equal(t, http.StatusNotFound, s.get(fork.Path()+"/diff?format=text", bob).Code)
+	assert.Equal(t, http.StatusOK, s.get(fork.Path()+"/diff?format=text", alice).Code)
+	assert.Equal(t, http.StatusOK, s.get(fork.Path()+"/diff?format=text&from="+private.ID, bob).Code)
+}
+
+func Test_colorDiff(t *testing.T) {
+	assert.Equal(t, "\x1b[1m--- a\x1b[0m\n\x1b[1m+++ b\x1b[0m\n\x1b[36m@@ -1,1 +1,1 @@\x1b[0m\n\x1b[31m--- x\x1b[0m\n\x1b[32m+y\x1b[0m\n",
+		colorDiff("--- a\n+++ b\n@@ -1,1 +1,1 @@\n--- x\n+y\n"))
+}
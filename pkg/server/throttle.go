@@ -0,0 +1,295 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+// throttleKey is the context key of the Throttle of a request
+const throttleKey = "throttle"
+
+// clientIdleTimeout is how long the buckets of an idle client are kept
+const clientIdleTimeout = 10 * time.Minute
+
+// Throttle limits the request rate of every client and the byte rate of the transfers with token buckets.
+// A request is counted against its IP, and against its token as well if it has one, so that neither random
+// tokens nor many IPs sharing a token get around the limits. The handlers throttle their transfers with
+// throttleReader and throttleWriter.
+type Throttle struct {
+	cfg *config.LimitConfig
+
+	requests  *clientLimiters
+	bandwidth *rate.Limiter
+	clients   *clientLimiters
+}
+
+func NewThrottle(cfg *config.LimitConfig) *Throttle {
+	t := &Throttle{cfg: cfg}
+	if cfg.RequestRate > 0 {
+		burst := cfg.RequestBurst
+		if burst <= 0 {
+			burst = int(math.Ceil(cfg.RequestRate))
+		}
+		t.requests = newClientLimiters(func() *rate.Limiter {
+			return rate.NewLimiter(rate.Limit(cfg.RequestRate), burst)
+		})
+	}
+	if cfg.Bandwidth > 0 {
+		t.bandwidth = newBytesLimiter(cfg.Bandwidth)
+	}
+	if cfg.ClientBandwidth > 0 {
+		t.clients = newClientLimiters(func() *rate.Limiter {
+			return newBytesLimiter(cfg.ClientBandwidth)
+		})
+	}
+	return t
+}
+
+// Middleware rejects the requests over the rate of their client and makes the throttle available to the handlers
+func (t *Throttle) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if t.requests != nil && !t.allow(c) {
+				retryAfter := math.Ceil(1 / t.cfg.RequestRate)
+				c.Response().Header().Set("Retry-After", fmt.Sprintf("%.0f", retryAfter))
+				return c.String(http.StatusTooManyRequests, "Too many requests")
+			}
+			c.Set(throttleKey, t)
+			return next(c)
+		}
+	}
+}
+
+// allow takes a request from the buckets of all clients of the request
+func (t *Throttle) allow(c echo.Context) bool {
+	return allowAll(t.requests, requestClients(c))
+}
+
+// allowAll takes a token from the buckets of all clients, none is taken if one is empty
+func allowAll(limiters *clientLimiters, clients []string) bool {
+	now := time.Now()
+	var reservations []*rate.Reservation
+	for _, client := range clients {
+		r := limiters.get(client).ReserveN(now, 1)
+		reservations = append(reservations, r)
+		if !r.OK() || r.DelayFrom(now) > 0 {
+			for _, r := range reservations {
+				r.CancelAt(now)
+			}
+			return false
+		}
+	}
+	return true
+}
+
+// limiters returns the byte buckets a transfer of the request is counted in
+func (t *Throttle) limiters(c echo.Context) []*rate.Limiter {
+	var limiters []*rate.Limiter
+	if t.bandwidth != nil {
+		limiters = append(limiters, t.bandwidth)
+	}
+	if t.clients != nil {
+		for _, client := range requestClients(c) {
+			limiters = append(limiters, t.clients.get(client))
+		}
+	}
+	if t.cfg.ConnectionBandwidth > 0 {
+		limiters = append(limiters, newBytesLimiter(t.cfg.ConnectionBandwidth))
+	}
+	return limiters
+}
+
+// requestClients identifies the clients of the request, its IP and its token if it has one
+func requestClients(c echo.Context) []string {
+	clients := []string{"ip:" + requestIP(c)}
+	if owner := requestOwner(c); owner != "" {
+		clients = append(clients, "token:"+owner)
+	}
+	return clients
+}
+
+// requestIP returns the IP of the client, the forwarded headers are only used if the server configures an
+// IP extractor of trusted proxies, echo trusts them by default
+func requestIP(c echo.Context) string {
+	if c.Echo().IPExtractor == nil {
+		return echo.ExtractIPDirect()(c.Request())
+	}
+	return c.RealIP()
+}
+
+// NewIPExtractor returns the IP extractor of the server, it trusts the X-Forwarded-For header of the trusted
+// proxies only, the remote address is the client IP if there are none
+func NewIPExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect(), nil
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range trustedProxies {
+		cidr := proxy
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipRange, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
+// newBytesLimiter returns a bucket of bytesPerSecond which holds the bytes of one second
+func newBytesLimiter(bytesPerSecond int64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(min(bytesPerSecond, math.MaxInt32)))
+}
+
+// clientLimiters keeps a bucket per client, the buckets of idle clients are dropped
+type clientLimiters struct {
+	newLimiter func() *rate.Limiter
+
+	mu        sync.Mutex
+	limiters  map[string]*clientLimiter
+	lastPurge time.Time
+}
+
+type clientLimiter struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+func newClientLimiters(newLimiter func() *rate.Limiter) *clientLimiters {
+	return &clientLimiters{
+		newLimiter: newLimiter,
+		limiters:   make(map[string]*clientLimiter),
+		lastPurge:  time.Now(),
+	}
+}
+
+func (l *clientLimiters) get(client string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastPurge) > clientIdleTimeout {
+		for key, limiter := range l.limiters {
+			if now.Sub(limiter.lastSeen) > clientIdleTimeout {
+				delete(l.limiters, key)
+			}
+		}
+		l.lastPurge = now
+	}
+
+	limiter, ok := l.limiters[client]
+	if !ok {
+		limiter = &clientLimiter{Limiter: l.newLimiter()}
+		l.limiters[client] = limiter
+	}
+	limiter.lastSeen = now
+	return limiter.Limiter
+}
+
+// byteThrottle waits for the bytes of a transfer in all of its buckets
+type byteThrottle struct {
+	ctx      context.Context
+	limiters []*rate.Limiter
+	// chunk is the smallest burst, the most bytes which can be waited for at once
+	chunk int
+}
+
+func newByteThrottle(c echo.Context) *byteThrottle {
+	t, ok := c.Get(throttleKey).(*Throttle)
+	if !ok {
+		return nil
+	}
+	limiters := t.limiters(c)
+	if len(limiters) == 0 {
+		return nil
+	}
+	b := &byteThrottle{ctx: c.Request().Context(), limiters: limiters, chunk: math.MaxInt}
+	for _, limiter := range limiters {
+		b.chunk = min(b.chunk, limiter.Burst())
+	}
+	return b
+}
+
+func (b *byteThrottle) wait(n int) error {
+	for _, limiter := range b.limiters {
+		if err := limiter.WaitN(b.ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type throttledReader struct {
+	io.Reader
+	throttle *byteThrottle
+}
+
+// throttleReader limits the byte rate of reading r to the bandwidth of the request
+func throttleReader(c echo.Context, r io.Reader) io.Reader {
+	throttle := newByteThrottle(c)
+	if throttle == nil {
+		return r
+	}
+	return &throttledReader{Reader: r, throttle: throttle}
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > r.throttle.chunk {
+		p = p[:r.throttle.chunk]
+	}
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		if err := r.throttle.wait(n); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+type throttledWriter struct {
+	io.Writer
+	throttle *byteThrottle
+}
+
+// throttleWriter limits the byte rate of writing to w to the bandwidth of the request
+func throttleWriter(c echo.Context, w io.Writer) io.Writer {
+	throttle := newByteThrottle(c)
+	if throttle == nil {
+		return w
+	}
+	return &throttledWriter{Writer: w, throttle: throttle}
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), w.throttle.chunk)]
+		if err := w.throttle.wait(len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.Writer.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThrottleRequests(t *testing.T) {
+	e := echo.New()
+	e.Use(NewThrottle(&config.LimitConfig{RequestRate: 0.5, RequestBurst: 2}).Middleware())
+	e.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})
+
+	get := func(remoteAddr, token, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set(authTokenHeader, token)
+		}
+		if forwardedFor != "" {
+			req.Header.Set(echo.HeaderXForwardedFor, forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusOK, get("192.0.2.1:1234", "", "").Code)
+	assert.Equal(t, http.StatusOK, get("192.0.2.1:1234", "", "").Code)
+	rec := get("192.0.2.1:1234", "", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+
+	// neither a random token nor a forged X-Forwarded-For gets a new bucket
+	assert.Equal(t, http.StatusTooManyRequests, get("192.0.2.1:1234", "random", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, get("192.0.2.1:1234", "", "198.51.100.1").Code)
+
+	// a token is counted on every IP it is used from
+	assert.Equal(t, http.StatusOK, get("192.0.2.2:1234", "secret", "").Code)
+	assert.Equal(t, http.StatusOK, get("192.0.2.3:1234", "secret", "").Code)
+	assert.Equal(t, http.StatusTooManyRequests, get("192.0.2.4:1234", "secret", "").Code)
+	// the rejected request is not counted against the IP
+	assert.Equal(t, http.StatusOK, get("192.0.2.4:1234", "", "").Code)
+	assert.Equal(t, http.StatusOK, get("192.0.2.4:1234", "", "").Code)
+}
+
+func TestNewIPExtractor(t *testing.T) {
+	_, err := NewIPExtractor([]string{"proxy"})
+	assert.Error(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set(echo.HeaderXForwardedFor, "198.51.100.1")
+	direct, err := NewIPExtractor(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", direct(req))
+
+	for _, proxies := range [][]string{{"10.0.0.1"}, {"10.0.0.0/8", "::1"}} {
+		extractor, err := NewIPExtractor(proxies)
+		assert.NoError(t, err)
+		assert.Equal(t, "198.51.100.1", extractor(req), proxies)
+	}
+	// the other private addresses are not trusted
+	extractor, err := NewIPExtractor([]string{"10.0.0.2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", extractor(req))
+}
+
+func TestThrottleBandwidth(t *testing.T) {
+	e := echo.New()
+	throttle := NewThrottle(&config.LimitConfig{Bandwidth: 100 << 10, ConnectionBandwidth: 200 << 10})
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+
+	// without the middleware the transfers are not throttled
+	assert.Equal(t, io.Writer(io.Discard), throttleWriter(c, io.Discard))
+	c.Set(throttleKey, throttle)
+
+	// the burst of one second passes at once, the rest at the smallest rate
+	start := time.Now()
+	buffer := bytes.NewBuffer(nil)
+	n, err := throttleWriter(c, buffer).Write(make([]byte, 150<<10))
+	assert.NoError(t, err)
+	assert.Equal(t, 150<<10, n)
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond)
+
+	// the global bucket is shared with the next transfer
+	start = time.Now()
+	data, err := io.ReadAll(throttleReader(c, strings.NewReader(strings.Repeat("x", 20<<10))))
+	assert.NoError(t, err)
+	assert.Len(t, data, 20<<10)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/labstack/echo/v4"
+)
+
+// trashPurgeInterval is how often the expired trash entries are purged
+const trashPurgeInterval = time.Hour
+
+func (f *FilerServer) setupTrash(e *echo.Echo) {
+	trashStore, ok := store.As[*store.TrashStore](f.store)
+	if !ok {
+		return
+	}
+
+	e.GET("/trash", f.trashPageHandler)
+	e.POST("/trash/restore/:id", f.restoreTrashHandler)
+	e.DELETE("/trash/:id", f.deleteTrashHandler)
+	e.DELETE("/trash", f.emptyTrashHandler)
+
+	if retention := f.cfg.Store.Trash.Retention; retention > 0 {
+		go purgeTrash(trashStore, retention)
+	}
+}
+
+// purgeTrash deletes the expired trash entries periodically
+func purgeTrash(trashStore *store.TrashStore, retention time.Duration) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := trashStore.Purge(context.Background(), retention)
+		if err != nil {
+			log.Printf("Error purging the trash: %s\n", err.Error())
+		} else if purged > 0 {
+			log.Printf("%d files purged from the trash\n", purged)
+		}
+		<-ticker.C
+	}
+}
+
+func (f *FilerServer) trashStore() *store.TrashStore {
+	trashStore, _ := store.As[*store.TrashStore](f.store)
+	return trashStore
+}
+
+func (f *FilerServer) trashPageHandler(c echo.Context) error {
+	entries, err := f.trashStore().ListTrash(context.Background())
+	if err != nil {
+		c.Logger().Errorf("Error listing the trash: %s", err.Error())
+		return c.String(http.StatusInternalServerError, "Error listing the trash")
+	}
+
+	if strings.Contains(c.Request().Header.Get("Accept"), "application/json") {
+		return c.JSON(http.StatusOK, entries)
+	}
+
+	var expiresAt map[string]time.Time
+	if retention := f.cfg.Store.Trash.Retention; retention > 0 {
+		expiresAt = make(map[string]time.Time, len(entries))
+		for _, entry := range entries {
+			expiresAt[entry.ID] = entry.DeletedAt.Add(retention)
+		}
+	}
+	return c.Render(http.StatusOK, "trash.html", map[string]interface{}{
+		"Entries":          entries,
+		"ExpiresAt":        expiresAt,
+		"DownloadEndpoint": getDownloadUrl(c.Request().Host, "", f.cfg.EnableTls),
+	})
+}
+
+func (f *FilerServer) restoreTrashHandler(c echo.Context) error {
+	id := c.Param("id")
+
+	entry, err := f.trashStore().RestoreTrash(context.Background(), id)
+	if err != nil {
+		if errors.Is(err, store.ErrTrashNotExist) {
+			return c.String(http.StatusNotFound, "Trash entry not found")
+		}
+		if errors.Is(err, store.ErrExist) {
+			return c.String(http.StatusConflict, "A file with the same path exists")
+		}
+		c.Logger().Errorf("Error restoring the trash entry %s: %s", id, err.Error())
+		return c.String(http.StatusInternalServerError, "Error restoring the file")
+	}
+
+	f.refreshQuota(c, entry.Path)
+	c.Logger().Printf("File %s restored from trash", entry.Path)
+	return c.String(http.StatusOK, "File restored successfully")
+}
+
+func (f *FilerServer) deleteTrashHandler(c echo.Context) error {
+	id := c.Param("id")
+
+	if err := f.trashStore().DeleteTrash(context.Background(), id); err != nil {
+		if errors.Is(err, store.ErrTrashNotExist) {
+			return c.String(http.StatusNotFound, "Trash entry not found")
+		}
+		c.Logger().Errorf("Error deleting the trash entry %s: %s", id, err.Error())
+		return c.String(http.StatusInternalServerError, "Error deleting the file")
+	}
+
+	return c.String(http.StatusOK, "File deleted permanently")
+}
+
+func (f *FilerServer) emptyTrashHandler(c echo.Context) error {
+	purged, err := f.trashStore().EmptyTrash(context.Background())
+	if err != nil {
+		c.Logger().Errorf("Error emptying the trash: %s", err.Error())
+		return c.String(http.StatusInternalServerError, "Error emptying the trash")
+	}
+
+	c.Logger().Printf("%d files deleted from trash", purged)
+	return c.String(http.StatusOK, "Trash emptied")
+}
@@ -0,0 +1,445 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/webhook"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// pathHeader sets the target path of an upload, the form field path does the same for form uploads
+	pathHeader = "X-Path"
+	// overwriteHeader sets the overwrite policy of an upload, the form field overwrite does the same
+	overwriteHeader = "X-Overwrite"
+)
+
+// overwrite policies of uploads, they apply to the explicit and the generated paths
+const (
+	overwriteNever   = "never"
+	overwriteAlways  = "always"
+	overwriteIfMatch = "if-match"
+)
+
+var (
+	errUploadTooLarge     = errors.New("upload exceeds the max upload size")
+	errFileExists         = errors.New("file already exists")
+	errPreconditionFailed = errors.New("etag does not match")
+)
+
+// uploadTarget is where an upload is written to
+type uploadTarget struct {
+	// path is empty if the caller did not choose one, it is cleaned by resolve with the names of the files
+	path string
+	// dir is set if path ends with a slash, the files are uploaded into the directory
+	dir       bool
+	overwrite string
+	ifMatch   string
+}
+
+// parseUploadTarget reads the explicit target path and the overwrite policy from the request
+func parseUploadTarget(c echo.Context) (*uploadTarget, error) {
+	target := &uploadTarget{
+		path:      strings.TrimPrefix(c.Param("*"), "/"),
+		overwrite: uploadOption(c, overwriteHeader, "overwrite"),
+		ifMatch:   c.Request().Header.Get("If-Match"),
+	}
+	if target.path == "" {
+		target.path = uploadOption(c, pathHeader, "path")
+	}
+	if p, ok := strings.CutSuffix(target.path, "/"); ok {
+		target.path, target.dir = p, p != ""
+	}
+
+	switch target.overwrite {
+	case "":
+		// the caller-chosen paths are kept by default, the generated paths are written like before
+		target.overwrite = overwriteAlways
+		if target.path != "" {
+			target.overwrite = overwriteNever
+		}
+	case overwriteNever, overwriteAlways:
+	case overwriteIfMatch:
+		if target.ifMatch == "" {
+			return nil, fmt.Errorf("If-Match header is required by overwrite policy %s", overwriteIfMatch)
+		}
+	default:
+		return nil, fmt.Errorf("invalid overwrite policy %q", target.overwrite)
+	}
+	return target, nil
+}
+
+// uploadFormKey is the context key of the fields read so far from a streamed upload form
+const uploadFormKey = "uploadForm"
+
+// uploadOption returns the header of the request, or the form field of a form upload
+func uploadOption(c echo.Context, header, field string) string {
+	if v := c.Request().Header.Get(header); v != "" {
+		return v
+	}
+	if form, ok := c.Get(uploadFormKey).(url.Values); ok {
+		return form.Get(field)
+	}
+	return ""
+}
+
+// cleanUploadPath cleans and validates the final path of an upload, it must be a file path inside the store
+func cleanUploadPath(p string) (string, error) {
+	p = strings.ReplaceAll(p, "\\", "/")
+	if strings.HasSuffix(p, "/") {
+		return "", fmt.Errorf("invalid path %q, it is a directory", p)
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("invalid path %q", p)
+		}
+	}
+	cleaned := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if cleaned == "" || reservedPath(cleaned) {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	return cleaned, nil
+}
+
+// reservedPath reports whether the path belongs to the internal trees of the store, the pastes included, the
+// file endpoints neither serve nor change it
+func reservedPath(p string) bool {
+	return store.IsInternalKey(p)
+}
+
+// checkOverwrite returns errFileExists or errPreconditionFailed if the policy of target forbids writing filePath
+func (f *FilerServer) checkOverwrite(ctx context.Context, filePath string, target *uploadTarget) error {
+	if target.overwrite == overwriteAlways {
+		return nil
+	}
+	meta, err := f.store.FileMeta(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	switch target.overwrite {
+	case overwriteNever:
+		if meta != nil {
+			return errFileExists
+		}
+	case overwriteIfMatch:
+		if meta == nil || !etagMatch(target.ifMatch, meta.ETag) {
+			return errPreconditionFailed
+		}
+	}
+	return nil
+}
+
+// uploadOptions are the options of an upload request, they apply to all files of the request
+type uploadOptions struct {
+	target   *uploadTarget
+	naming   NamingStrategy
+	metadata map[string]string
+}
+
+func (f *FilerServer) parseUploadOptions(c echo.Context) (*uploadOptions, error) {
+	metadata, err := f.uploadMetadata(c)
+	if err != nil {
+		return nil, err
+	}
+	target, err := parseUploadTarget(c)
+	if err != nil {
+		return nil, err
+	}
+	naming, err := f.namingStrategy(uploadOption(c, namingHeader, "naming"))
+	if err != nil {
+		return nil, err
+	}
+	return &uploadOptions{target: target, naming: naming, metadata: metadata}, nil
+}
+
+// uploadResult is the outcome of uploading one file
+type uploadResult struct {
+	Name   string `json:"name"`
+	Path   string `json:"path,omitempty"`
+	URL    string `json:"url,omitempty"`
+	ETag   string `json:"etag,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// putFile uploads one file to filePath, or to the path generated by the naming strategy if filePath is empty.
+// The overwrite policy applies to the generated paths as well, but a content-addressed path which exists holds
+// the same content already and is not written again. The errors are reported in the result.
+func (f *FilerServer) putFile(c echo.Context, opts *uploadOptions, reader io.Reader, filename, filePath string, expected *digests) *uploadResult {
+	ctx := uploadContext(c)
+	result := &uploadResult{Name: filename}
+	content := newDigestReader(reader, expected)
+	reader = content
+	fail := func(status int, message string, err error) *uploadResult {
+		if err != nil {
+			c.Logger().Errorf("%s %s: %s", message, filename, err.Error())
+		}
+		result.Status, result.Error = status, message
+		return result
+	}
+
+	var contentAddressed bool
+	if filePath == "" {
+		input := &NamingInput{Filename: filename, Time: time.Now()}
+		if cn, ok := opts.naming.(contentNaming); ok && cn.HashContent() {
+			contentAddressed = true
+			tmp, sum, err := spoolWithHash(reader)
+			if content.mismatch {
+				return fail(http.StatusBadRequest, "Checksum mismatch", nil)
+			}
+			if err != nil {
+				return fail(http.StatusInternalServerError, "Error reading the file", err)
+			}
+			defer closeAndRemove(tmp)
+			reader, input.SHA256 = tmp, sum
+		}
+
+		name, err := opts.naming.Name(input)
+		if err == nil {
+			filePath, err = cleanUploadPath(name)
+		}
+		if err != nil {
+			return fail(http.StatusBadRequest, err.Error(), nil)
+		}
+	}
+
+	if opts.target.overwrite != overwriteAlways || contentAddressed {
+		unlock := f.uploadLocks.lock(filePath)
+		defer unlock()
+	}
+	if contentAddressed {
+		meta, err := f.store.FileMeta(ctx, filePath)
+		if err != nil {
+			return fail(http.StatusInternalServerError, "Error checking the file", err)
+		}
+		if meta != nil {
+			c.Logger().Printf("File %s is stored already: %s", filename, filePath)
+			return f.uploaded(c, opts, result, filePath, content.SHA256())
+		}
+	} else if err := f.checkOverwrite(ctx, filePath, opts.target); err != nil {
+		switch {
+		case errors.Is(err, errFileExists):
+			return fail(http.StatusConflict, "File already exists", nil)
+		case errors.Is(err, errPreconditionFailed):
+			return fail(http.StatusPreconditionFailed, "File does not match If-Match", nil)
+		}
+		return fail(http.StatusInternalServerError, "Error checking the file", err)
+	}
+
+	var existed bool
+	if expected != nil || f.quotaStore() != nil {
+		meta, err := f.store.FileMeta(ctx, filePath)
+		if err != nil {
+			return fail(http.StatusInternalServerError, "Error checking the file", err)
+		}
+		existed = meta != nil
+	}
+
+	// Upload to Store
+	if err := f.store.UploadFile(ctx, reader, filePath); err != nil {
+		rejected := content.mismatch || errors.Is(err, store.ErrFileTooLarge) || errors.Is(err, store.ErrQuotaExceeded)
+		if rejected && !existed {
+			// the stores drop a failed upload, a partial object of a new file is removed in case one is left
+			if err := f.store.DeleteFile(ctx, filePath); err != nil {
+				c.Logger().Errorf("Error deleting the rejected file %s: %s", filePath, err.Error())
+			}
+		}
+		switch {
+		case content.mismatch:
+			return fail(http.StatusBadRequest, "Checksum mismatch", nil)
+		case errors.Is(err, store.ErrFileTooLarge):
+			return fail(http.StatusRequestEntityTooLarge, "File too large", nil)
+		case errors.Is(err, store.ErrQuotaExceeded):
+			return fail(http.StatusInsufficientStorage, "Quota exceeded", nil)
+		}
+		return fail(http.StatusInternalServerError, "Error uploading the file to the store", err)
+	}
+	c.Logger().Printf("File %s uploaded successfully to store: %s", filename, filePath)
+	return f.uploaded(c, opts, result, filePath, content.SHA256())
+}
+
+// uploaded saves the metadata of the uploaded file and completes its result
+func (f *FilerServer) uploaded(c echo.Context, opts *uploadOptions, result *uploadResult, filePath, digest string) *uploadResult {
+	ctx := uploadContext(c)
+	result.SHA256 = digest
+	metadata := map[string]string{store.MetaSHA256: result.SHA256}
+	for k, v := range opts.metadata {
+		metadata[k] = v
+	}
+	if err := store.SetMetadata(ctx, f.store, filePath, metadata); err != nil {
+		c.Logger().Errorf("Error saving the metadata of %s: %s", filePath, err.Error())
+	}
+
+	result.Status = http.StatusOK
+	result.Path = filePath
+	result.URL = getDownloadUrl(c.Request().Host, EscapeUrlPath(filePath), f.cfg.EnableTls)
+	event := newEvent(c, webhook.EventUpload, filePath)
+	event.SHA256, event.URL, event.DownloadURL = result.SHA256, result.URL, result.URL
+	if meta, err := f.store.FileMeta(ctx, filePath); err == nil && meta != nil {
+		result.ETag = meta.ETag
+		event.Size = meta.Size
+	}
+	f.hooks.Notify(event)
+	return result
+}
+
+// uploadPaths decides where the files of an upload request are written to
+type uploadPaths struct {
+	opts *uploadOptions
+	// folders are the generated names of the top folders of a folder upload
+	folders map[string]string
+	now     time.Time
+}
+
+func newUploadPaths(opts *uploadOptions) *uploadPaths {
+	return &uploadPaths{opts: opts, folders: make(map[string]string), now: time.Now()}
+}
+
+// resolve returns the display name and the target path of a file, an empty path means the file is named by
+// the naming strategy. Files of a folder upload keep their relative paths, below the explicit target directory
+// or else below the name the strategy generates for their top folder.
+func (u *uploadPaths) resolve(filename, relativePath string) (string, string, error) {
+	name := baseName(filename)
+	if relativePath != "" {
+		name = strings.TrimLeft(strings.ReplaceAll(relativePath, "\\", "/"), "/")
+		// the top folder may be replaced by a generated name, it must not leave the folder either
+		if slices.Contains(strings.Split(name, "/"), "..") {
+			return "", "", fmt.Errorf("invalid path %q", relativePath)
+		}
+	}
+
+	target := u.opts.target
+	if target.path != "" && relativePath == "" && !target.dir {
+		p, err := cleanUploadPath(target.path)
+		return path.Base(p), p, err
+	}
+	if name == "" || name == "." || name == "/" {
+		return "", "", errors.New("file name is missing")
+	}
+	if target.path != "" {
+		p, err := cleanUploadPath(target.path + "/" + name)
+		return name, p, err
+	}
+
+	folder, rest, ok := strings.Cut(name, "/")
+	if !ok {
+		return name, "", nil
+	}
+	prefix, ok := u.folders[folder]
+	if !ok {
+		if cn, ok := u.opts.naming.(contentNaming); ok && cn.HashContent() {
+			return "", "", errors.New("the naming strategy does not support folder uploads")
+		}
+		var err error
+		if prefix, err = u.opts.naming.Name(&NamingInput{Filename: folder, Time: u.now}); err != nil {
+			return "", "", err
+		}
+		u.folders[folder] = prefix
+	}
+	p, err := cleanUploadPath(prefix + "/" + rest)
+	return name, p, err
+}
+
+// uploadLimiter fails the reads of the request body once more than the max upload size is read
+type uploadLimiter struct {
+	io.Reader
+	io.Closer
+	// remain is negative if the size is unlimited
+	remain   int64
+	exceeded bool
+}
+
+// limitUpload enforces the max upload size on the request body, ok is false if the declared length exceeds it
+func (f *FilerServer) limitUpload(c echo.Context) (limit *uploadLimiter, ok bool) {
+	req := c.Request()
+	limit = &uploadLimiter{Reader: throttleReader(c, req.Body), Closer: req.Body, remain: f.cfg.Upload.MaxSize}
+	if limit.remain <= 0 {
+		limit.remain = -1
+	}
+	req.Body = limit
+	return limit, limit.remain < 0 || req.ContentLength <= limit.remain
+}
+
+func (l *uploadLimiter) Read(p []byte) (int, error) {
+	if l.remain < 0 {
+		return l.Reader.Read(p)
+	}
+	if l.exceeded {
+		return 0, errUploadTooLarge
+	}
+	if int64(len(p)) > l.remain+1 {
+		p = p[:l.remain+1]
+	}
+	n, err := l.Reader.Read(p)
+	if int64(n) > l.remain {
+		l.exceeded = true
+		return int(l.remain), errUploadTooLarge
+	}
+	l.remain -= int64(n)
+	return n, err
+}
+
+func (f *FilerServer) uploadTooLarge(c echo.Context) error {
+	c.Logger().Errorf("Upload exceeds the max upload size of %d bytes", f.cfg.Upload.MaxSize)
+	// the rest of the body is not read, the connection can not be reused
+	c.Response().Header().Set("Connection", "close")
+	return c.String(http.StatusRequestEntityTooLarge, fmt.Sprintf("Upload exceeds the max upload size of %d bytes", f.cfg.Upload.MaxSize))
+}
+
+// etagMatch reports whether the If-Match header matches etag, * matches any existing file
+func etagMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || (candidate != "" && etag != "" && strings.Trim(candidate, `"`) == strings.Trim(etag, `"`)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathLocker serializes the conditional uploads to the same path within this process
+type pathLocker struct {
+	mu    sync.Mutex
+	locks map[string]*pathLock
+}
+
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (l *pathLocker) lock(p string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*pathLock)
+	}
+	lock, ok := l.locks[p]
+	if !ok {
+		lock = &pathLock{}
+		l.locks[p] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(l.locks, p)
+		}
+		l.mu.Unlock()
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_cleanUploadPath(t *testing.T) {
+	cases := []struct {
+		path string
+		want string
+		err  bool
+	}{
+		{"latest/agent-linux-amd64", "latest/agent-linux-amd64", false},
+		{"/latest//agent", "latest/agent", false},
+		{"a\\b.txt", "a/b.txt", false},
+		{"latest/", "", true},
+		{"../etc/passwd", "", true},
+		{"a/../../b", "", true},
+		{"/", "", true},
+		{".meta/a", "", true},
+		{".trash/files/a", "", true},
+		{".pastes/records/a.json", "", true},
+		{"/.pastes/code/go/a.go", "", true},
+		{"pastes/a.json", "pastes/a.json", false},
+		{"/code/go/a.go", "code/go/a.go", false},
+	}
+	for _, c := range cases {
+		got, err := cleanUploadPath(c.path)
+		assert.Equal(t, c.err, err != nil, c.path)
+		assert.Equal(t, c.want, got, c.path)
+	}
+}
+
+func Test_etagMatch(t *testing.T) {
+	assert.True(t, etagMatch(`"abc"`, `"abc"`))
+	assert.True(t, etagMatch(`W/"abc"`, `"abc"`))
+	assert.True(t, etagMatch(`"x", "abc"`, `"abc"`))
+	assert.True(t, etagMatch(`*`, `"abc"`))
+	assert.False(t, etagMatch(`"x"`, `"abc"`))
+	assert.False(t, etagMatch(`""`, ``))
+}
@@ -0,0 +1,18 @@
+package server
+
+import (
+	"github.com/graydovee/fileManager/pkg/webhook"
+	"github.com/labstack/echo/v4"
+)
+
+// newEvent returns a webhook event of key caused by the client of the request
+func newEvent(c echo.Context, eventType, key string) *webhook.Event {
+	return &webhook.Event{
+		Type: eventType,
+		Key:  key,
+		Actor: webhook.Actor{
+			Token: requestOwner(c),
+			IP:    requestIP(c),
+		},
+	}
+}
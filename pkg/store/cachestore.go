@@ -0,0 +1,303 @@
+package store
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var _ Store = (*CacheStore)(nil)
+var _ RangeDownloader = (*CacheStore)(nil)
+var _ VersionedStore = (*CacheStore)(nil)
+
+const cacheFileSuffix = ".cache"
+
+// CacheStore keeps recently downloaded files on local disk in front of a slow store like S3.
+// A cached file is only served if its etag still matches the etag of the inner store,
+// the least recently used files are evicted when the cache grows over maxSize.
+type CacheStore struct {
+	inner   Store
+	dir     string
+	maxSize int64
+
+	mu       sync.Mutex
+	size     int64
+	lru      *list.List
+	entries  map[string]*list.Element
+	inflight map[string]*cacheCall
+}
+
+type cacheEntry struct {
+	key  string
+	etag string
+	file string
+	size int64
+}
+
+// cacheCall is a fetch of one file version shared by all concurrent downloads
+type cacheCall struct {
+	done chan struct{}
+	err  error
+}
+
+// NewCacheStore creates a cache in dir, the cache files left by a previous run are removed
+func NewCacheStore(inner Store, dir string, maxSize int64) (*CacheStore, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	old, err := filepath.Glob(filepath.Join(dir, "*"+cacheFileSuffix+"*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to clean cache directory: %w", err)
+	}
+	for _, file := range old {
+		_ = os.Remove(file)
+	}
+
+	return &CacheStore{
+		inner:    inner,
+		dir:      dir,
+		maxSize:  maxSize,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*cacheCall),
+	}, nil
+}
+
+func (s *CacheStore) Unwrap() Store {
+	return s.inner
+}
+
+func (s *CacheStore) UploadFile(ctx context.Context, reader io.Reader, filePath string) error {
+	s.Invalidate(filePath)
+	defer s.Invalidate(filePath)
+	return s.inner.UploadFile(ctx, reader, filePath)
+}
+
+func (s *CacheStore) DeleteFile(ctx context.Context, filePath string) error {
+	s.Invalidate(filePath)
+	defer s.Invalidate(filePath)
+	return s.inner.DeleteFile(ctx, filePath)
+}
+
+func (s *CacheStore) FileMeta(ctx context.Context, file string) (*FileMeta, error) {
+	return s.inner.FileMeta(ctx, file)
+}
+
+func (s *CacheStore) List(ctx context.Context, dir string) ([]*FileMeta, error) {
+	return s.inner.List(ctx, dir)
+}
+
+func (s *CacheStore) DownloadFile(ctx context.Context, writer io.Writer, key string) error {
+	return s.DownloadRange(ctx, writer, key, 0, -1)
+}
+
+func (s *CacheStore) DownloadRange(ctx context.Context, writer io.Writer, key string, offset, length int64) error {
+	meta, err := s.inner.FileMeta(ctx, key)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return fmt.Errorf("failed to download file %s: %w", key, ErrNotExist)
+	}
+	whole := offset == 0 && (length < 0 || length >= meta.Size)
+	if meta.ETag == "" || meta.Size > s.maxSize || !whole && !s.cached(cleanKey(key), meta.ETag) {
+		// can't validate or too large to keep, bypass the cache. A range of a file which is not cached
+		// is not worth fetching the whole file, like the probes of the encryption header.
+		if offset == 0 && length < 0 {
+			return s.inner.DownloadFile(ctx, writer, key)
+		}
+		return DownloadRange(ctx, s.inner, writer, key, offset, length)
+	}
+
+	file, err := s.open(ctx, cleanKey(key), meta.ETag)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek cache file: %w", err)
+	}
+	var reader io.Reader = file
+	if length >= 0 {
+		reader = io.LimitReader(file, length)
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		return fmt.Errorf("failed to copy cache file: %w", err)
+	}
+	return nil
+}
+
+func (s *CacheStore) VersioningEnabled(ctx context.Context) (bool, error) {
+	v, err := versionedInner(s.inner)
+	if err != nil {
+		return false, nil
+	}
+	return v.VersioningEnabled(ctx)
+}
+
+func (s *CacheStore) ListVersions(ctx context.Context, key string) ([]*FileVersion, error) {
+	v, err := versionedInner(s.inner)
+	if err != nil {
+		return nil, err
+	}
+	return v.ListVersions(ctx, key)
+}
+
+// DownloadVersion is not cached, old versions are rarely downloaded
+func (s *CacheStore) DownloadVersion(ctx context.Context, writer io.Writer, key, versionID string) error {
+	v, err := versionedInner(s.inner)
+	if err != nil {
+		return err
+	}
+	return v.DownloadVersion(ctx, writer, key, versionID)
+}
+
+func (s *CacheStore) RestoreVersion(ctx context.Context, key, versionID string) error {
+	v, err := versionedInner(s.inner)
+	if err != nil {
+		return err
+	}
+	s.Invalidate(key)
+	defer s.Invalidate(key)
+	return v.RestoreVersion(ctx, key, versionID)
+}
+
+func (s *CacheStore) DeleteVersion(ctx context.Context, key, versionID string) error {
+	v, err := versionedInner(s.inner)
+	if err != nil {
+		return err
+	}
+	s.Invalidate(key)
+	return v.DeleteVersion(ctx, key, versionID)
+}
+
+// Invalidate drops the cached copy of key
+func (s *CacheStore) Invalidate(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.entries[cleanKey(key)]; ok {
+		s.removeLocked(elem)
+	}
+}
+
+// cached reports whether the version etag of key is cached
+func (s *CacheStore) cached(key, etag string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.entries[key]
+	return ok && elem.Value.(*cacheEntry).etag == etag
+}
+
+// open returns the cached file of key with etag, the file is fetched from the inner store
+// if it is not cached yet. Concurrent calls for the same version share one fetch.
+func (s *CacheStore) open(ctx context.Context, key, etag string) (*os.File, error) {
+	for {
+		s.mu.Lock()
+		if elem, ok := s.entries[key]; ok {
+			entry := elem.Value.(*cacheEntry)
+			if entry.etag == etag {
+				s.lru.MoveToFront(elem)
+				// open while holding the lock, so the file can't be evicted in between
+				file, err := os.Open(entry.file)
+				s.mu.Unlock()
+				if err != nil {
+					return nil, fmt.Errorf("failed to open cache file: %w", err)
+				}
+				return file, nil
+			}
+			s.removeLocked(elem)
+		}
+
+		callKey := key + "\x00" + etag
+		if call, ok := s.inflight[callKey]; ok {
+			s.mu.Unlock()
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if call.err != nil {
+				return nil, call.err
+			}
+			// the fetched file is in the cache now unless it was invalidated meanwhile
+			continue
+		}
+
+		call := &cacheCall{done: make(chan struct{})}
+		s.inflight[callKey] = call
+		s.mu.Unlock()
+
+		call.err = s.fetch(ctx, key, etag)
+
+		s.mu.Lock()
+		delete(s.inflight, callKey)
+		s.mu.Unlock()
+		close(call.done)
+
+		if call.err != nil {
+			return nil, call.err
+		}
+	}
+}
+
+// fetch downloads key from the inner store into the cache
+func (s *CacheStore) fetch(ctx context.Context, key, etag string) error {
+	tmp, err := os.CreateTemp(s.dir, "*"+cacheFileSuffix+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := s.inner.DownloadFile(ctx, tmp, key); err != nil {
+		return err
+	}
+	stat, err := tmp.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat cache file: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(key + "\x00" + etag))
+	file := filepath.Join(s.dir, hex.EncodeToString(sum[:])+cacheFileSuffix)
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("failed to save cache file: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, ok := s.entries[key]; ok {
+		if elem.Value.(*cacheEntry).file == file {
+			// the same version was cached meanwhile and its file is replaced already
+			s.size -= s.lru.Remove(elem).(*cacheEntry).size
+		} else {
+			s.removeLocked(elem)
+		}
+	}
+	s.entries[key] = s.lru.PushFront(&cacheEntry{
+		key:  key,
+		etag: etag,
+		file: file,
+		size: stat.Size(),
+	})
+	s.size += stat.Size()
+
+	for s.size > s.maxSize && s.lru.Len() > 1 {
+		s.removeLocked(s.lru.Back())
+	}
+	return nil
+}
+
+func (s *CacheStore) removeLocked(elem *list.Element) {
+	entry := s.lru.Remove(elem).(*cacheEntry)
+	delete(s.entries, entry.key)
+	s.size -= entry.size
+	// an open file is still readable after it is removed
+	_ = os.Remove(entry.file)
+}
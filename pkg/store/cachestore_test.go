@@ -0,0 +1,170 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/store/storetest"
+)
+
+// countingStore counts the downloads reaching the wrapped store
+type countingStore struct {
+	store.Store
+	downloads atomic.Int32
+	ranges    atomic.Int32
+	delay     time.Duration
+}
+
+func (c *countingStore) DownloadFile(ctx context.Context, writer io.Writer, key string) error {
+	c.downloads.Add(1)
+	time.Sleep(c.delay)
+	return c.Store.DownloadFile(ctx, writer, key)
+}
+
+func (c *countingStore) DownloadRange(ctx context.Context, writer io.Writer, key string, offset, length int64) error {
+	c.ranges.Add(1)
+	return store.DownloadRange(ctx, c.Store, writer, key, offset, length)
+}
+
+func newTestCacheStore(t *testing.T, maxSize int64) (*store.CacheStore, *countingStore) {
+	inner := &countingStore{Store: store.NewMemStore()}
+	st, err := store.NewCacheStore(inner, t.TempDir(), maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st, inner
+}
+
+func TestCacheStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		st, _ := newTestCacheStore(t, 1<<20)
+		return st
+	})
+}
+
+func TestCacheStoreHit(t *testing.T) {
+	st, inner := newTestCacheStore(t, 1<<20)
+	storetest.Upload(t, st, "a.txt", "content a")
+
+	for i := 0; i < 3; i++ {
+		if got := storetest.Download(t, st, "a.txt"); got != "content a" {
+			t.Fatalf("got %q", got)
+		}
+	}
+	if n := inner.downloads.Load(); n != 1 {
+		t.Fatalf("inner store downloaded %d times, want 1", n)
+	}
+
+	// a change behind the back of the cache is detected by the etag
+	storetest.Upload(t, inner.Store, "a.txt", "changed")
+	if got := storetest.Download(t, st, "a.txt"); got != "changed" {
+		t.Fatalf("got %q after change", got)
+	}
+
+	// uploads through the cache invalidate it
+	storetest.Upload(t, st, "a.txt", "uploaded")
+	if got := storetest.Download(t, st, "a.txt"); got != "uploaded" {
+		t.Fatalf("got %q after upload", got)
+	}
+	if n := inner.downloads.Load(); n != 3 {
+		t.Fatalf("inner store downloaded %d times, want 3", n)
+	}
+}
+
+func TestCacheStoreRange(t *testing.T) {
+	ctx := context.Background()
+	st, inner := newTestCacheStore(t, 1<<20)
+	storetest.Upload(t, st, "a.txt", "0123456789")
+
+	// a range of a file which is not cached is read from the inner store
+	buffer := bytes.NewBuffer(nil)
+	if err := st.DownloadRange(ctx, buffer, "a.txt", 0, 4); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.String() != "0123" {
+		t.Fatalf("got %q", buffer.String())
+	}
+	if n, r := inner.downloads.Load(), inner.ranges.Load(); n != 0 || r != 1 {
+		t.Fatalf("inner store downloaded %d times and %d ranges, want 0 and 1", n, r)
+	}
+
+	// the ranges of a cached file are read from the cache
+	storetest.Download(t, st, "a.txt")
+	buffer.Reset()
+	if err := st.DownloadRange(ctx, buffer, "a.txt", 2, 3); err != nil {
+		t.Fatal(err)
+	}
+	if buffer.String() != "234" {
+		t.Fatalf("got %q", buffer.String())
+	}
+	if n, r := inner.downloads.Load(), inner.ranges.Load(); n != 1 || r != 1 {
+		t.Fatalf("inner store downloaded %d times and %d ranges, want 1 and 1", n, r)
+	}
+}
+
+func TestCacheStoreEviction(t *testing.T) {
+	st, inner := newTestCacheStore(t, 25)
+	storetest.Upload(t, st, "a", strings.Repeat("a", 10))
+	storetest.Upload(t, st, "b", strings.Repeat("b", 10))
+	storetest.Upload(t, st, "c", strings.Repeat("c", 10))
+	storetest.Upload(t, st, "big", strings.Repeat("x", 30))
+
+	storetest.Download(t, st, "a")
+	storetest.Download(t, st, "b")
+	storetest.Download(t, st, "a")
+	// evicts b, the least recently used
+	storetest.Download(t, st, "c")
+	inner.downloads.Store(0)
+
+	storetest.Download(t, st, "a")
+	storetest.Download(t, st, "c")
+	if n := inner.downloads.Load(); n != 0 {
+		t.Fatalf("a and c should be cached, got %d downloads", n)
+	}
+	storetest.Download(t, st, "b")
+	if n := inner.downloads.Load(); n != 1 {
+		t.Fatalf("b should be evicted, got %d downloads", n)
+	}
+
+	// files larger than the cache are never kept
+	storetest.Download(t, st, "big")
+	storetest.Download(t, st, "big")
+	if n := inner.downloads.Load(); n != 3 {
+		t.Fatalf("big should bypass the cache, got %d downloads", n)
+	}
+}
+
+func TestCacheStoreSingleFetch(t *testing.T) {
+	st, inner := newTestCacheStore(t, 1<<20)
+	inner.delay = 50 * time.Millisecond
+	content := strings.Repeat("concurrent", 1000)
+	storetest.Upload(t, st, "shared", content)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buffer := bytes.NewBuffer(nil)
+			if err := st.DownloadFile(context.Background(), buffer, "shared"); err != nil {
+				t.Error(err)
+				return
+			}
+			if buffer.String() != content {
+				t.Error("content mismatch")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := inner.downloads.Load(); n != 1 {
+		t.Fatalf("inner store downloaded %d times, want 1", n)
+	}
+}
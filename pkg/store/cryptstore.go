@@ -0,0 +1,691 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/graydovee/fileManager/pkg/config"
+)
+
+var _ Store = (*EncryptStore)(nil)
+var _ RangeDownloader = (*EncryptStore)(nil)
+var _ VersionedStore = (*EncryptStore)(nil)
+
+// Encrypted file layout:
+//
+//	magic(6) | key id(8) | chunk size(4) | nonce prefix(7) | wrapped data key(60) | chunk...
+//
+// Every file has a random data key which is wrapped by the master key with AES-256-GCM.
+// The content is split into chunks of encryptChunkSize bytes, each chunk is sealed with the
+// data key, the nonce is the nonce prefix followed by the chunk index and a last chunk flag,
+// so that chunks can't be reordered or the file truncated without being detected.
+const (
+	encryptMagic         = "FMENC\x01"
+	encryptChunkSize     = 64 * 1024
+	encryptKeyIDSize     = 8
+	encryptNoncePrefix   = 7
+	encryptWrappedKeyLen = 12 + 32 + 16
+	encryptHeaderSize    = len(encryptMagic) + encryptKeyIDSize + 4 + encryptNoncePrefix + encryptWrappedKeyLen
+	encryptTagSize       = 16
+	encryptFrameSize     = encryptChunkSize + encryptTagSize
+)
+
+// EncryptStore encrypts the files at rest before they are written to the inner store.
+// The sizes reported by FileMeta and List are the plaintext sizes. The files written before the
+// encryption was enabled have no header, they are read as they are until they are overwritten.
+type EncryptStore struct {
+	inner Store
+
+	keyID string
+	key   []byte
+	// keys holds the current and previous master keys by key id
+	keys map[string][]byte
+
+	mu sync.Mutex
+	// probed caches whether the files start with the header, by key and ETag, so listing does not
+	// read every file again
+	probed map[string]probedFile
+}
+
+// probedFile is the result of the header probe of a version of a file
+type probedFile struct {
+	etag      string
+	encrypted bool
+}
+
+// NewEncryptStore wraps inner with the 32 bytes master key, files encrypted with one of
+// previousKeys can still be read
+func NewEncryptStore(inner Store, key []byte, previousKeys ...[]byte) (*EncryptStore, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(key))
+	}
+	e := &EncryptStore{
+		inner:  inner,
+		keyID:  masterKeyID(key),
+		key:    key,
+		keys:   make(map[string][]byte),
+		probed: make(map[string]probedFile),
+	}
+	for _, k := range previousKeys {
+		if len(k) != 32 {
+			return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(k))
+		}
+		e.keys[masterKeyID(k)] = k
+	}
+	e.keys[e.keyID] = key
+	return e, nil
+}
+
+func newEncryptStoreFromConfig(inner Store, cfg *config.EncryptionConfig) (*EncryptStore, error) {
+	key, err := LoadCurrentMasterKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var previousKeys [][]byte
+	for _, file := range cfg.PreviousKeyFiles {
+		previousKey, err := LoadMasterKey(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load previous key %s: %w", file, err)
+		}
+		previousKeys = append(previousKeys, previousKey)
+	}
+	return NewEncryptStore(inner, key, previousKeys...)
+}
+
+// LoadCurrentMasterKey returns the master key configured by Key or KeyFile
+func LoadCurrentMasterKey(cfg *config.EncryptionConfig) ([]byte, error) {
+	if cfg.Key != "" {
+		return ParseMasterKey([]byte(cfg.Key))
+	}
+	return LoadMasterKey(cfg.KeyFile)
+}
+
+// LoadMasterKey reads a master key from file, the file contains either 32 raw bytes or
+// the base64 or hex encoding of them
+func LoadMasterKey(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	return ParseMasterKey(data)
+}
+
+// ParseMasterKey decodes a 32 bytes master key from raw, base64 or hex form
+func ParseMasterKey(data []byte) ([]byte, error) {
+	if len(data) == 32 {
+		return data, nil
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(text); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, errors.New("master key must be 32 bytes in raw, base64 or hex form")
+}
+
+func masterKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return string(sum[:encryptKeyIDSize])
+}
+
+func (e *EncryptStore) Unwrap() Store {
+	return e.inner
+}
+
+func (e *EncryptStore) UploadFile(ctx context.Context, reader io.Reader, filePath string) error {
+	dataKey := make([]byte, 32)
+	noncePrefix := make([]byte, encryptNoncePrefix)
+	if _, err := rand.Read(dataKey); err != nil {
+		return fmt.Errorf("failed to generate data key: %w", err)
+	}
+	if _, err := rand.Read(noncePrefix); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	header, err := e.sealHeader(e.keyID, e.key, dataKey, noncePrefix)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+
+	er := &encryptReader{
+		src:         bufio.NewReaderSize(reader, encryptChunkSize),
+		aead:        aead,
+		noncePrefix: noncePrefix,
+		pending:     header,
+	}
+	return e.inner.UploadFile(ctx, er, filePath)
+}
+
+func (e *EncryptStore) DeleteFile(ctx context.Context, filePath string) error {
+	return e.inner.DeleteFile(ctx, filePath)
+}
+
+func (e *EncryptStore) FileMeta(ctx context.Context, file string) (*FileMeta, error) {
+	meta, err := e.inner.FileMeta(ctx, file)
+	if err != nil || meta == nil {
+		return meta, err
+	}
+	return e.plainMeta(ctx, file, meta)
+}
+
+func (e *EncryptStore) List(ctx context.Context, dir string) ([]*FileMeta, error) {
+	metas, err := e.inner.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, meta := range metas {
+		if !meta.IsDir {
+			if metas[i], err = e.plainMeta(ctx, cleanKey(path.Join(dir, meta.Name)), meta); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return metas, nil
+}
+
+func (e *EncryptStore) DownloadFile(ctx context.Context, writer io.Writer, key string) error {
+	dw := &decryptWriter{w: writer, open: e.openHeader}
+	if err := e.inner.DownloadFile(ctx, dw, key); err != nil {
+		return err
+	}
+	return dw.Close()
+}
+
+func (e *EncryptStore) DownloadRange(ctx context.Context, writer io.Writer, key string, offset, length int64) error {
+	meta, err := e.inner.FileMeta(ctx, key)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return fmt.Errorf("failed to download file %s: %w", key, ErrNotExist)
+	}
+	encrypted, err := e.encrypted(ctx, key, meta)
+	if err != nil {
+		return err
+	}
+	if !encrypted {
+		return DownloadRange(ctx, e.inner, writer, key, offset, length)
+	}
+	plainSize, chunks := plainSizeOf(meta.Size)
+	if offset >= plainSize || length == 0 {
+		return nil
+	}
+	if length < 0 || offset+length > plainSize {
+		length = plainSize - offset
+	}
+
+	headerBuffer := bytes.NewBuffer(nil)
+	if err := DownloadRange(ctx, e.inner, headerBuffer, key, 0, int64(encryptHeaderSize)); err != nil {
+		return err
+	}
+	aead, noncePrefix, err := e.openHeader(headerBuffer.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to decrypt file %s: %w", key, err)
+	}
+
+	first := offset / encryptChunkSize
+	last := (offset + length - 1) / encryptChunkSize
+	cipherOffset := int64(encryptHeaderSize) + first*encryptFrameSize
+	cipherLength := (last - first + 1) * encryptFrameSize
+
+	rw := &rangeDecryptWriter{
+		w:           writer,
+		aead:        aead,
+		noncePrefix: noncePrefix,
+		index:       uint32(first),
+		lastIndex:   uint32(chunks - 1),
+		skip:        offset - first*encryptChunkSize,
+		remain:      length,
+	}
+	if err := DownloadRange(ctx, e.inner, rw, key, cipherOffset, cipherLength); err != nil {
+		return err
+	}
+	return rw.Close()
+}
+
+func (e *EncryptStore) VersioningEnabled(ctx context.Context) (bool, error) {
+	v, err := versionedInner(e.inner)
+	if err != nil {
+		return false, nil
+	}
+	return v.VersioningEnabled(ctx)
+}
+
+func (e *EncryptStore) ListVersions(ctx context.Context, key string) ([]*FileVersion, error) {
+	v, err := versionedInner(e.inner)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := v.ListVersions(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		version.Size, _ = plainSizeOf(version.Size)
+	}
+	return versions, nil
+}
+
+func (e *EncryptStore) DownloadVersion(ctx context.Context, writer io.Writer, key, versionID string) error {
+	v, err := versionedInner(e.inner)
+	if err != nil {
+		return err
+	}
+	dw := &decryptWriter{w: writer, open: e.openHeader}
+	if err := v.DownloadVersion(ctx, dw, key, versionID); err != nil {
+		return err
+	}
+	return dw.Close()
+}
+
+func (e *EncryptStore) RestoreVersion(ctx context.Context, key, versionID string) error {
+	v, err := versionedInner(e.inner)
+	if err != nil {
+		return err
+	}
+	return v.RestoreVersion(ctx, key, versionID)
+}
+
+func (e *EncryptStore) DeleteVersion(ctx context.Context, key, versionID string) error {
+	v, err := versionedInner(e.inner)
+	if err != nil {
+		return err
+	}
+	return v.DeleteVersion(ctx, key, versionID)
+}
+
+// Rotate re-wraps the data key of every file under dir with newKey, the content is not re-encrypted.
+// Files already using newKey are skipped. The returned count is the number of rewritten files.
+// Old versions kept by native versioning are not rotated, they still need the previous key.
+func (e *EncryptStore) Rotate(ctx context.Context, dir string, newKey []byte) (int, error) {
+	if len(newKey) != 32 {
+		return 0, fmt.Errorf("master key must be 32 bytes, got %d", len(newKey))
+	}
+	newKeyID := masterKeyID(newKey)
+
+	rotated := 0
+	err := Walk(ctx, e.inner, dir, func(key string, meta *FileMeta) error {
+		headerBuffer := bytes.NewBuffer(nil)
+		if err := DownloadRange(ctx, e.inner, headerBuffer, key, 0, int64(encryptHeaderSize)); err != nil {
+			return err
+		}
+		header := headerBuffer.Bytes()
+		if !bytes.HasPrefix(header, []byte(encryptMagic)) {
+			// a file written before the encryption was enabled has no data key
+			return nil
+		}
+		keyID, dataKey, noncePrefix, err := e.unwrapHeader(header)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt file %s: %w", key, err)
+		}
+		if keyID == newKeyID {
+			return nil
+		}
+		newHeader, err := e.sealHeader(newKeyID, newKey, dataKey, noncePrefix)
+		if err != nil {
+			return err
+		}
+
+		// spool the ciphertext to a temporary file, as some stores truncate the file
+		// before the new content is written
+		tmp, err := os.CreateTemp("", "fm-rotate-*")
+		if err != nil {
+			return fmt.Errorf("failed to create temp file: %w", err)
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		if _, err := tmp.Write(newHeader); err != nil {
+			return fmt.Errorf("failed to write temp file: %w", err)
+		}
+		if err := DownloadRange(ctx, e.inner, tmp, key, int64(encryptHeaderSize), -1); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek temp file: %w", err)
+		}
+		if err := e.inner.UploadFile(ctx, tmp, key); err != nil {
+			return err
+		}
+		rotated++
+		return nil
+	})
+	return rotated, err
+}
+
+func (e *EncryptStore) plainMeta(ctx context.Context, key string, meta *FileMeta) (*FileMeta, error) {
+	encrypted, err := e.encrypted(ctx, key, meta)
+	if err != nil || !encrypted {
+		return meta, err
+	}
+	plain := *meta
+	plain.Size, _ = plainSizeOf(meta.Size)
+	return &plain, nil
+}
+
+// encrypted reports whether the file starts with the header, the files written before the encryption was
+// enabled do not
+func (e *EncryptStore) encrypted(ctx context.Context, key string, meta *FileMeta) (bool, error) {
+	if meta.Size < int64(encryptHeaderSize+encryptTagSize) {
+		// even an empty file is larger encrypted
+		return false, nil
+	}
+	e.mu.Lock()
+	probed, ok := e.probed[key]
+	e.mu.Unlock()
+	if ok && meta.ETag != "" && probed.etag == meta.ETag {
+		return probed.encrypted, nil
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	if err := DownloadRange(ctx, e.inner, buffer, key, 0, int64(len(encryptMagic))); err != nil {
+		if errors.Is(err, ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	encrypted := buffer.String() == encryptMagic
+	if meta.ETag != "" {
+		e.mu.Lock()
+		e.probed[key] = probedFile{etag: meta.ETag, encrypted: encrypted}
+		e.mu.Unlock()
+	}
+	return encrypted, nil
+}
+
+// plainSizeOf returns the plaintext size and the count of chunks of an encrypted file
+func plainSizeOf(cipherSize int64) (int64, int64) {
+	body := cipherSize - int64(encryptHeaderSize)
+	if body < encryptTagSize {
+		return 0, 0
+	}
+	chunks := (body + encryptFrameSize - 1) / encryptFrameSize
+	return body - chunks*encryptTagSize, chunks
+}
+
+func (e *EncryptStore) sealHeader(keyID string, masterKey, dataKey, noncePrefix []byte) ([]byte, error) {
+	header := make([]byte, 0, encryptHeaderSize)
+	header = append(header, encryptMagic...)
+	header = append(header, keyID...)
+	header = binary.BigEndian.AppendUint32(header, encryptChunkSize)
+	header = append(header, noncePrefix...)
+
+	kek, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, kek.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	// the header fields are authenticated together with the data key
+	wrapped := kek.Seal(nonce, nonce, dataKey, header)
+	return append(header, wrapped...), nil
+}
+
+func (e *EncryptStore) unwrapHeader(header []byte) (keyID string, dataKey, noncePrefix []byte, err error) {
+	if len(header) != encryptHeaderSize || string(header[:len(encryptMagic)]) != encryptMagic {
+		return "", nil, nil, errors.New("not an encrypted file")
+	}
+	pos := len(encryptMagic)
+	keyID = string(header[pos : pos+encryptKeyIDSize])
+	pos += encryptKeyIDSize
+	if binary.BigEndian.Uint32(header[pos:]) != encryptChunkSize {
+		return "", nil, nil, errors.New("unsupported chunk size")
+	}
+	pos += 4
+	noncePrefix = append([]byte(nil), header[pos:pos+encryptNoncePrefix]...)
+	pos += encryptNoncePrefix
+
+	masterKey, ok := e.keys[keyID]
+	if !ok {
+		return "", nil, nil, fmt.Errorf("unknown master key %x", keyID)
+	}
+	kek, err := newGCM(masterKey)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	wrapped := header[pos:]
+	dataKey, err = kek.Open(nil, wrapped[:kek.NonceSize()], wrapped[kek.NonceSize():], header[:pos])
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return keyID, dataKey, noncePrefix, nil
+}
+
+func (e *EncryptStore) openHeader(header []byte) (cipher.AEAD, []byte, error) {
+	_, dataKey, noncePrefix, err := e.unwrapHeader(header)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, noncePrefix, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gcm: %w", err)
+	}
+	return aead, nil
+}
+
+func chunkNonce(noncePrefix []byte, index uint32, last bool) []byte {
+	nonce := make([]byte, 0, 12)
+	nonce = append(nonce, noncePrefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, index)
+	if last {
+		return append(nonce, 1)
+	}
+	return append(nonce, 0)
+}
+
+// encryptReader produces the header followed by the encrypted chunks of src
+type encryptReader struct {
+	src         *bufio.Reader
+	aead        cipher.AEAD
+	noncePrefix []byte
+	index       uint32
+	pending     []byte
+	done        bool
+	buf         []byte
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.sealChunk(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+func (r *encryptReader) sealChunk() error {
+	if r.buf == nil {
+		r.buf = make([]byte, encryptChunkSize, encryptFrameSize)
+	}
+	n, err := io.ReadFull(r.src, r.buf[:encryptChunkSize])
+	last := false
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		if _, err := r.src.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	r.pending = r.aead.Seal(r.buf[:0], chunkNonce(r.noncePrefix, r.index, last), r.buf[:n], nil)
+	r.index++
+	r.done = last
+	return nil
+}
+
+// decryptWriter decrypts a whole encrypted file written to it, the last chunk is decrypted by Close. A file
+// without the header is written as it is.
+type decryptWriter struct {
+	w    io.Writer
+	open func(header []byte) (cipher.AEAD, []byte, error)
+	// plain is set once the file is known to have no header
+	plain bool
+
+	aead        cipher.AEAD
+	noncePrefix []byte
+	index       uint32
+	buf         []byte
+}
+
+func (d *decryptWriter) Write(p []byte) (int, error) {
+	if d.plain {
+		return d.w.Write(p)
+	}
+	d.buf = append(d.buf, p...)
+	if d.aead == nil {
+		if n := min(len(d.buf), len(encryptMagic)); string(d.buf[:n]) != encryptMagic[:n] {
+			d.plain = true
+			if _, err := d.w.Write(d.buf); err != nil {
+				return 0, err
+			}
+			d.buf = nil
+			return len(p), nil
+		}
+		if len(d.buf) < encryptHeaderSize {
+			return len(p), nil
+		}
+		aead, noncePrefix, err := d.open(d.buf[:encryptHeaderSize])
+		if err != nil {
+			return 0, fmt.Errorf("failed to decrypt file: %w", err)
+		}
+		d.aead, d.noncePrefix = aead, noncePrefix
+		d.buf = append(d.buf[:0], d.buf[encryptHeaderSize:]...)
+	}
+
+	// a frame is only known not to be the last one when more data follows it
+	consumed := 0
+	for len(d.buf)-consumed > encryptFrameSize {
+		if err := d.openChunk(d.buf[consumed:consumed+encryptFrameSize], false); err != nil {
+			return 0, err
+		}
+		consumed += encryptFrameSize
+	}
+	d.buf = append(d.buf[:0], d.buf[consumed:]...)
+	return len(p), nil
+}
+
+func (d *decryptWriter) Close() error {
+	if d.plain {
+		return nil
+	}
+	if d.aead == nil && len(d.buf) < len(encryptMagic) {
+		// a file shorter than the magic, or empty, has no header
+		_, err := d.w.Write(d.buf)
+		return err
+	}
+	if d.aead == nil || len(d.buf) < encryptTagSize {
+		return errors.New("failed to decrypt file: truncated")
+	}
+	return d.openChunk(d.buf, true)
+}
+
+func (d *decryptWriter) openChunk(frame []byte, last bool) error {
+	plain, err := d.aead.Open(nil, chunkNonce(d.noncePrefix, d.index, last), frame, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk %d: %w", d.index, err)
+	}
+	d.index++
+	_, err = d.w.Write(plain)
+	return err
+}
+
+// rangeDecryptWriter decrypts the frames of a ranged download and writes the wanted part of the plaintext
+type rangeDecryptWriter struct {
+	w           io.Writer
+	aead        cipher.AEAD
+	noncePrefix []byte
+	index       uint32
+	lastIndex   uint32
+	skip        int64
+	remain      int64
+	buf         []byte
+}
+
+func (r *rangeDecryptWriter) Write(p []byte) (int, error) {
+	r.buf = append(r.buf, p...)
+	consumed := 0
+	for len(r.buf)-consumed >= encryptFrameSize {
+		if err := r.openChunk(r.buf[consumed : consumed+encryptFrameSize]); err != nil {
+			return 0, err
+		}
+		consumed += encryptFrameSize
+	}
+	r.buf = append(r.buf[:0], r.buf[consumed:]...)
+	return len(p), nil
+}
+
+func (r *rangeDecryptWriter) Close() error {
+	if len(r.buf) > 0 {
+		if err := r.openChunk(r.buf); err != nil {
+			return err
+		}
+	}
+	if r.remain > 0 {
+		return errors.New("failed to decrypt file: truncated")
+	}
+	return nil
+}
+
+func (r *rangeDecryptWriter) openChunk(frame []byte) error {
+	plain, err := r.aead.Open(nil, chunkNonce(r.noncePrefix, r.index, r.index == r.lastIndex), frame, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk %d: %w", r.index, err)
+	}
+	r.index++
+
+	if r.skip > 0 {
+		plain = plain[r.skip:]
+		r.skip = 0
+	}
+	if int64(len(plain)) > r.remain {
+		plain = plain[:r.remain]
+	}
+	r.remain -= int64(len(plain))
+	_, err = r.w.Write(plain)
+	return err
+}
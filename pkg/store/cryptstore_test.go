@@ -0,0 +1,171 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/store/storetest"
+)
+
+func newMasterKey(t *testing.T) []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		st, err := store.NewEncryptStore(store.NewMemStore(), newMasterKey(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	})
+}
+
+func TestEncryptStoreCiphertext(t *testing.T) {
+	inner := store.NewMemStore()
+	st, err := store.NewEncryptStore(inner, newMasterKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := strings.Repeat("secret ", 30000)
+	storetest.Upload(t, st, "secret.txt", content)
+
+	raw := storetest.Download(t, inner, "secret.txt")
+	if strings.Contains(raw, "secret") {
+		t.Fatal("inner store contains plaintext")
+	}
+
+	// flip one bit of the second chunk
+	tampered := []byte(raw)
+	tampered[len(tampered)/2] ^= 1
+	if err := inner.UploadFile(context.Background(), bytes.NewReader(tampered), "secret.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.DownloadFile(context.Background(), bytes.NewBuffer(nil), "secret.txt"); err == nil {
+		t.Fatal("download of tampered file should fail")
+	}
+
+	// drop the last chunk
+	truncated := []byte(raw)[:len(raw)-len(raw)%(64*1024+16)]
+	if err := inner.UploadFile(context.Background(), bytes.NewReader(truncated), "secret.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.DownloadFile(context.Background(), bytes.NewBuffer(nil), "secret.txt"); err == nil {
+		t.Fatal("download of truncated file should fail")
+	}
+}
+
+func TestEncryptStoreLegacyFiles(t *testing.T) {
+	ctx := context.Background()
+	inner := store.NewMemStore()
+	legacy := strings.Repeat("written before the encryption ", 5000)
+	storetest.Upload(t, inner, "legacy.txt", legacy)
+	storetest.Upload(t, inner, "dir/short.txt", "FM")
+	storetest.Upload(t, inner, "dir/empty.txt", "")
+
+	st, err := store.NewEncryptStore(inner, newMasterKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storetest.Upload(t, st, "dir/new.txt", "encrypted")
+
+	if got := storetest.Download(t, st, "legacy.txt"); got != legacy {
+		t.Fatal("content mismatch of the legacy file")
+	}
+	for key, want := range map[string]string{"dir/short.txt": "FM", "dir/empty.txt": "", "dir/new.txt": "encrypted"} {
+		if got := storetest.Download(t, st, key); got != want {
+			t.Fatalf("got %q for %s, want %q", got, key, want)
+		}
+	}
+	meta, err := st.FileMeta(ctx, "legacy.txt")
+	if err != nil || meta == nil || meta.Size != int64(len(legacy)) {
+		t.Fatalf("meta of the legacy file %+v, %v", meta, err)
+	}
+	metas, err := st.List(ctx, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := map[string]int64{}
+	for _, meta := range metas {
+		sizes[meta.Name] = meta.Size
+	}
+	if sizes["short.txt"] != 2 || sizes["empty.txt"] != 0 || sizes["new.txt"] != 9 {
+		t.Fatalf("listed sizes %v", sizes)
+	}
+	buffer := bytes.NewBuffer(nil)
+	if err := st.DownloadRange(ctx, buffer, "legacy.txt", 8, 6); err != nil || buffer.String() != "before" {
+		t.Fatalf("got %q, %v for the range of the legacy file", buffer.String(), err)
+	}
+
+	// the rotation skips the legacy files, an overwrite encrypts them
+	rotated, err := st.Rotate(ctx, "", newMasterKey(t))
+	if err != nil || rotated != 1 {
+		t.Fatalf("rotated %d files, %v", rotated, err)
+	}
+	storetest.Upload(t, st, "legacy.txt", "now encrypted")
+	if strings.Contains(storetest.Download(t, inner, "legacy.txt"), "now encrypted") {
+		t.Fatal("the overwritten legacy file is not encrypted")
+	}
+}
+
+func TestEncryptStoreRotate(t *testing.T) {
+	inner := store.NewMemStore()
+	oldKey, newKey := newMasterKey(t), newMasterKey(t)
+
+	st, err := store.NewEncryptStore(inner, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storetest.Upload(t, st, "a.txt", "content a")
+	storetest.Upload(t, st, "dir/b.txt", strings.Repeat("b", 200000))
+
+	rotated, err := st.Rotate(context.Background(), "", newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated != 2 {
+		t.Fatalf("rotated %d files, want 2", rotated)
+	}
+
+	// the new key alone can read the files, the old key can't
+	rotatedStore, err := store.NewEncryptStore(inner, newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storetest.Download(t, rotatedStore, "a.txt"); got != "content a" {
+		t.Fatalf("got %q after rotation", got)
+	}
+	if got := storetest.Download(t, rotatedStore, "dir/b.txt"); got != strings.Repeat("b", 200000) {
+		t.Fatal("content mismatch after rotation")
+	}
+	if err := st.DownloadFile(context.Background(), bytes.NewBuffer(nil), "a.txt"); err == nil {
+		t.Fatal("old key should not decrypt rotated files")
+	}
+
+	// a store knowing the old key as previous key reads it too
+	storetest.Upload(t, st, "c.txt", "content c")
+	mixed, err := store.NewEncryptStore(inner, newKey, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := storetest.Download(t, mixed, "c.txt"); got != "content c" {
+		t.Fatalf("got %q with previous key", got)
+	}
+
+	rotated, err = mixed.Rotate(context.Background(), "", newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated != 1 {
+		t.Fatalf("rotated %d files, want 1", rotated)
+	}
+}
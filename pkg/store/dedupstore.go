@@ -0,0 +1,305 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var _ Store = (*DedupStore)(nil)
+var _ RangeDownloader = (*DedupStore)(nil)
+
+const (
+	dedupDir      = ".blobs"
+	dedupDataDir  = dedupDir + "/data"
+	dedupIndexDir = dedupDir + "/index"
+
+	// dedupRefPrefix starts the content of every reference file
+	dedupRefPrefix = "#fm-dedup-ref sha256:"
+	// dedupRefMaxSize is larger than any reference file, larger files are never read to check for a reference
+	dedupRefMaxSize = 128
+)
+
+// DedupStore stores the content of every file once by its SHA-256 in .blobs/data, the file keys
+// only hold a small reference to the blob. The number of references of every blob is kept in
+// .blobs/index, a blob is deleted with its last reference. Files written before dedup was enabled
+// are served as they are.
+type DedupStore struct {
+	inner Store
+
+	// mu serializes the updates of reference counts
+	mu sync.Mutex
+}
+
+// DedupStats describes the space saved by deduplication
+type DedupStats struct {
+	Blobs        int   `json:"blobs"`
+	References   int   `json:"references"`
+	LogicalSize  int64 `json:"logicalSize"`
+	PhysicalSize int64 `json:"physicalSize"`
+	SavedSize    int64 `json:"savedSize"`
+}
+
+type dedupIndex struct {
+	Size int64 `json:"size"`
+	Refs int   `json:"refs"`
+}
+
+type dedupRef struct {
+	hash string
+	size int64
+}
+
+func NewDedupStore(inner Store) *DedupStore {
+	return &DedupStore{inner: inner}
+}
+
+func (d *DedupStore) Unwrap() Store {
+	return d.inner
+}
+
+func (d *DedupStore) UploadFile(ctx context.Context, reader io.Reader, filePath string) error {
+	// spool to a temporary file to know the hash before anything is written to the store
+	tmp, err := os.CreateTemp("", "fm-dedup-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), reader)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	ref := &dedupRef{hash: hex.EncodeToString(hash.Sum(nil)), size: size}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	oldRef, err := d.readRef(ctx, filePath)
+	if err != nil {
+		return err
+	}
+
+	index, err := d.readIndex(ctx, ref.hash)
+	if err != nil {
+		return err
+	}
+	if index == nil {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to seek temp file: %w", err)
+		}
+		if err := d.inner.UploadFile(ctx, tmp, blobKey(ref.hash)); err != nil {
+			return err
+		}
+		index = &dedupIndex{Size: size}
+	}
+	index.Refs++
+	if err := d.writeIndex(ctx, ref.hash, index); err != nil {
+		return err
+	}
+
+	if err := d.inner.UploadFile(ctx, strings.NewReader(ref.String()), filePath); err != nil {
+		return err
+	}
+
+	if oldRef != nil {
+		return d.release(ctx, oldRef.hash)
+	}
+	return nil
+}
+
+func (d *DedupStore) DeleteFile(ctx context.Context, filePath string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ref, err := d.readRef(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	if err := d.inner.DeleteFile(ctx, filePath); err != nil {
+		return err
+	}
+	if ref != nil {
+		return d.release(ctx, ref.hash)
+	}
+	return nil
+}
+
+func (d *DedupStore) FileMeta(ctx context.Context, file string) (*FileMeta, error) {
+	meta, err := d.inner.FileMeta(ctx, file)
+	if err != nil || meta == nil {
+		return meta, err
+	}
+	return d.resolveMeta(ctx, file, meta)
+}
+
+func (d *DedupStore) List(ctx context.Context, dir string) ([]*FileMeta, error) {
+	metas, err := d.inner.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if cleanKey(dir) == "" {
+		metas = hideEntry(metas, dedupDir)
+	}
+	result := make([]*FileMeta, 0, len(metas))
+	for _, meta := range metas {
+		if !meta.IsDir {
+			if meta, err = d.resolveMeta(ctx, path.Join(dir, meta.Name), meta); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, meta)
+	}
+	return result, nil
+}
+
+func (d *DedupStore) DownloadFile(ctx context.Context, writer io.Writer, key string) error {
+	ref, err := d.readRef(ctx, key)
+	if err != nil {
+		return err
+	}
+	if ref == nil {
+		return d.inner.DownloadFile(ctx, writer, key)
+	}
+	return d.inner.DownloadFile(ctx, writer, blobKey(ref.hash))
+}
+
+func (d *DedupStore) DownloadRange(ctx context.Context, writer io.Writer, key string, offset, length int64) error {
+	ref, err := d.readRef(ctx, key)
+	if err != nil {
+		return err
+	}
+	if ref == nil {
+		return DownloadRange(ctx, d.inner, writer, key, offset, length)
+	}
+	return DownloadRange(ctx, d.inner, writer, blobKey(ref.hash), offset, length)
+}
+
+// Stats walks the blob index and sums up the logical and physical sizes
+func (d *DedupStore) Stats(ctx context.Context) (*DedupStats, error) {
+	stats := &DedupStats{}
+	err := Walk(ctx, d.inner, dedupIndexDir, func(key string, meta *FileMeta) error {
+		index, err := d.readIndex(ctx, path.Base(key))
+		if err != nil || index == nil {
+			return err
+		}
+		stats.Blobs++
+		stats.References += index.Refs
+		stats.PhysicalSize += index.Size
+		stats.LogicalSize += index.Size * int64(index.Refs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	stats.SavedSize = stats.LogicalSize - stats.PhysicalSize
+	return stats, nil
+}
+
+// release drops one reference of the blob, the blob is deleted when no reference is left
+func (d *DedupStore) release(ctx context.Context, hash string) error {
+	index, err := d.readIndex(ctx, hash)
+	if err != nil || index == nil {
+		return err
+	}
+	index.Refs--
+	if index.Refs > 0 {
+		return d.writeIndex(ctx, hash, index)
+	}
+	if err := d.inner.DeleteFile(ctx, blobKey(hash)); err != nil {
+		return err
+	}
+	return d.inner.DeleteFile(ctx, indexKey(hash))
+}
+
+func (d *DedupStore) resolveMeta(ctx context.Context, key string, meta *FileMeta) (*FileMeta, error) {
+	if meta.Size > dedupRefMaxSize {
+		return meta, nil
+	}
+	ref, err := d.readRef(ctx, key)
+	if err != nil || ref == nil {
+		return meta, err
+	}
+	resolved := *meta
+	resolved.Size = ref.size
+	// the content is identified by its hash, which makes a better etag than the one of the reference
+	resolved.ETag = "\"" + ref.hash + "\""
+	return &resolved, nil
+}
+
+// readRef returns the reference stored at key, nil if key doesn't exist or is not a reference
+func (d *DedupStore) readRef(ctx context.Context, key string) (*dedupRef, error) {
+	meta, err := d.inner.FileMeta(ctx, key)
+	if err != nil || meta == nil || meta.Size > dedupRefMaxSize {
+		return nil, err
+	}
+	buffer := bytes.NewBuffer(nil)
+	if err := d.inner.DownloadFile(ctx, buffer, key); err != nil {
+		return nil, err
+	}
+	return parseDedupRef(buffer.String()), nil
+}
+
+func (d *DedupStore) readIndex(ctx context.Context, hash string) (*dedupIndex, error) {
+	buffer := bytes.NewBuffer(nil)
+	if err := d.inner.DownloadFile(ctx, buffer, indexKey(hash)); err != nil {
+		if errors.Is(err, ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var index dedupIndex
+	if err := json.Unmarshal(buffer.Bytes(), &index); err != nil {
+		return nil, fmt.Errorf("failed to parse blob index %s: %w", hash, err)
+	}
+	return &index, nil
+}
+
+func (d *DedupStore) writeIndex(ctx context.Context, hash string, index *dedupIndex) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	return d.inner.UploadFile(ctx, bytes.NewReader(data), indexKey(hash))
+}
+
+func (r *dedupRef) String() string {
+	return fmt.Sprintf("%s%s %d\n", dedupRefPrefix, r.hash, r.size)
+}
+
+func parseDedupRef(content string) *dedupRef {
+	rest, ok := strings.CutPrefix(content, dedupRefPrefix)
+	if !ok {
+		return nil
+	}
+	fields := strings.Fields(rest)
+	if len(fields) != 2 || len(fields[0]) != sha256.Size*2 {
+		return nil
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &dedupRef{hash: fields[0], size: size}
+}
+
+func blobKey(hash string) string {
+	return path.Join(dedupDataDir, hash[:2], hash)
+}
+
+func indexKey(hash string) string {
+	return path.Join(dedupIndexDir, hash[:2], hash)
+}
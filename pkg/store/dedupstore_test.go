@@ -0,0 +1,105 @@
+package store_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/store/storetest"
+)
+
+func TestDedupStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return store.NewDedupStore(store.NewMemStore())
+	})
+}
+
+func TestDedupEncryptStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		encryptStore, err := store.NewEncryptStore(store.NewMemStore(), newMasterKey(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store.NewDedupStore(encryptStore)
+	})
+}
+
+func TestDedupStoreRefCount(t *testing.T) {
+	inner := store.NewMemStore()
+	st := store.NewDedupStore(inner)
+	ctx := context.Background()
+
+	content := strings.Repeat("artifact", 1000)
+	storetest.Upload(t, st, "2024/01/a.bin", content)
+	storetest.Upload(t, st, "2024/02/b.bin", content)
+	storetest.Upload(t, st, "other.bin", "other")
+
+	stats, err := st.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blobs != 2 || stats.References != 3 || stats.SavedSize != int64(len(content)) {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// the references are small, the content is stored once
+	meta, err := inner.FileMeta(ctx, "2024/01/a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Size >= int64(len(content)) {
+		t.Fatalf("reference has size %d", meta.Size)
+	}
+	meta, err = st.FileMeta(ctx, "2024/01/a.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Size != int64(len(content)) {
+		t.Fatalf("resolved size is %d, want %d", meta.Size, len(content))
+	}
+
+	if err := st.DeleteFile(ctx, "2024/01/a.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if got := storetest.Download(t, st, "2024/02/b.bin"); got != content {
+		t.Fatal("content lost while still referenced")
+	}
+
+	// overwriting the last reference releases the blob
+	storetest.Upload(t, st, "2024/02/b.bin", "new content")
+	stats, err = st.Stats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Blobs != 2 || stats.References != 2 || stats.SavedSize != 0 {
+		t.Fatalf("unexpected stats after overwrite: %+v", stats)
+	}
+
+	if err := st.DeleteFile(ctx, "2024/02/b.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.DeleteFile(ctx, "other.bin"); err != nil {
+		t.Fatal(err)
+	}
+	metas, err := inner.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 0 {
+		t.Fatalf("inner store should be empty, got %d entries", len(metas))
+	}
+}
+
+func TestDedupStoreLegacyFiles(t *testing.T) {
+	inner := store.NewMemStore()
+	storetest.Upload(t, inner, "legacy.txt", "written before dedup")
+
+	st := store.NewDedupStore(inner)
+	if got := storetest.Download(t, st, "legacy.txt"); got != "written before dedup" {
+		t.Fatalf("got %q", got)
+	}
+	if err := st.DeleteFile(context.Background(), "legacy.txt"); err != nil {
+		t.Fatal(err)
+	}
+}
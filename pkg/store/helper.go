@@ -0,0 +1,163 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"strings"
+)
+
+// Wrapper is implemented by the stores which decorate another store
+type Wrapper interface {
+	Unwrap() Store
+}
+
+// As returns the first store of type T in the decorator chain of st
+func As[T Store](st Store) (T, bool) {
+	for st != nil {
+		if t, ok := st.(T); ok {
+			return t, true
+		}
+		w, ok := st.(Wrapper)
+		if !ok {
+			break
+		}
+		st = w.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// RangeDownloader is implemented by stores which can download a part of a file without reading the whole file
+type RangeDownloader interface {
+	// DownloadRange writes length bytes starting at offset to writer, a negative length means until the end of file
+	DownloadRange(ctx context.Context, writer io.Writer, key string, offset, length int64) error
+}
+
+// DownloadRange downloads a part of the file, it falls back to skipping the unwanted bytes of
+// a full download if st is not a RangeDownloader
+func DownloadRange(ctx context.Context, st Store, writer io.Writer, key string, offset, length int64) error {
+	if rd, ok := st.(RangeDownloader); ok {
+		return rd.DownloadRange(ctx, writer, key, offset, length)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	rw := &rangeWriter{w: writer, skip: offset, remain: length, cancel: cancel}
+	err := st.DownloadFile(ctx, rw, key)
+	if err != nil && !rw.done {
+		return err
+	}
+	return nil
+}
+
+var errRangeDone = errors.New("range download completed")
+
+// rangeWriter drops the first skip bytes and stops the download after remain bytes are written
+type rangeWriter struct {
+	w      io.Writer
+	skip   int64
+	remain int64
+	done   bool
+	cancel context.CancelFunc
+}
+
+func (r *rangeWriter) Write(p []byte) (int, error) {
+	if r.done {
+		return 0, errRangeDone
+	}
+	n := len(p)
+	if r.skip > 0 {
+		if int64(len(p)) <= r.skip {
+			r.skip -= int64(len(p))
+			return n, nil
+		}
+		p = p[r.skip:]
+		r.skip = 0
+	}
+	if r.remain >= 0 && int64(len(p)) >= r.remain {
+		p = p[:r.remain]
+		r.done = true
+	}
+	if _, err := r.w.Write(p); err != nil {
+		return 0, err
+	}
+	if r.remain >= 0 {
+		r.remain -= int64(len(p))
+	}
+	if r.done {
+		r.cancel()
+		return n, errRangeDone
+	}
+	return n, nil
+}
+
+// Walk calls fn for every file under dir recursively
+func Walk(ctx context.Context, st Store, dir string, fn func(key string, meta *FileMeta) error) error {
+	metas, err := st.List(ctx, dir)
+	if err != nil {
+		return err
+	}
+	for _, meta := range metas {
+		key := cleanKey(path.Join(dir, meta.Name))
+		if meta.IsDir {
+			if err := Walk(ctx, st, key, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(key, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PasteDir keeps the pastes of the code server. Like the trees of the decorators the users do not access it,
+// but its files have metadata and count to the quotas like the others.
+const PasteDir = ".pastes"
+
+// IsInternalKey reports whether key belongs to the internal trees of the decorators or to the pastes, users must
+// not access them
+func IsInternalKey(key string) bool {
+	return isDecoratorKey(key) || underDir(cleanKey(key), PasteDir)
+}
+
+// isDecoratorKey reports whether key belongs to the trees the decorators keep their own data in
+func isDecoratorKey(key string) bool {
+	key = cleanKey(key)
+	for _, dir := range []string{metaDir, trashDir, versionDir, dedupDir} {
+		if underDir(key, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func underDir(key, dir string) bool {
+	return key == dir || strings.HasPrefix(key, dir+"/")
+}
+
+// Copy copies the content of src to dst within the store
+func Copy(ctx context.Context, st Store, src, dst string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(st.DownloadFile(ctx, pw, src))
+	}()
+	err := st.UploadFile(ctx, pr, dst)
+	pr.CloseWithError(err)
+	return err
+}
+
+// hideEntry removes the entry with name from metas, it is used to hide the internal directories from List
+func hideEntry(metas []*FileMeta, name string) []*FileMeta {
+	result := metas[:0]
+	for _, meta := range metas {
+		if meta.Name != name {
+			result = append(result, meta)
+		}
+	}
+	return result
+}
@@ -7,9 +7,11 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var _ Store = (*LocalStore)(nil)
+var _ RangeDownloader = (*LocalStore)(nil)
 
 type LocalStore struct {
 	cfg *config.LocalStoreConfig
@@ -29,26 +31,47 @@ func (l *LocalStore) UploadFile(ctx context.Context, reader io.Reader, filePath
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	newFile, err := os.Create(fullFilePath)
+	// Write to a temp file first, so that a failed upload neither leaves a partial file nor truncates the existing one
+	newFile, err := os.CreateTemp(dir, "."+filepath.Base(fullFilePath)+".upload-*")
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
-	defer newFile.Close()
+	defer os.Remove(newFile.Name())
+	// temp files are private, uploads get the permissions os.Create would give them
+	_ = newFile.Chmod(0o644)
 
 	// Copy the uploaded file to the new file
 	_, err = io.Copy(newFile, reader)
+	if closeErr := newFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return fmt.Errorf("failed to copy file: %w", err)
 	}
 
+	if err := os.Rename(newFile.Name(), fullFilePath); err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
 	return nil
 }
 
 func (l *LocalStore) DeleteFile(ctx context.Context, filePath string) error {
-	err := os.Remove(l.getFullFilePath(filePath))
+	fullFilePath := l.getFullFilePath(filePath)
+	err := os.Remove(fullFilePath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
 		return fmt.Errorf("failed to delete file: %w", err)
 	}
+
+	// Remove the empty parent directories, so that they disappear from List as they do in S3
+	root := filepath.Clean(l.cfg.UploadDir) + string(filepath.Separator)
+	for dir := filepath.Dir(fullFilePath); strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
 	return nil
 }
 
@@ -62,7 +85,12 @@ func (l *LocalStore) FileMeta(ctx context.Context, file string) (*FileMeta, erro
 		}
 		return nil, fmt.Errorf("failed to check file: %w", err)
 	}
+	if stat.IsDir() {
+		return nil, nil
+	}
 	meta.Size = stat.Size()
+	meta.ModTime = stat.ModTime()
+	meta.ETag = localETag(stat)
 	return &meta, nil
 }
 
@@ -70,6 +98,9 @@ func (l *LocalStore) DownloadFile(ctx context.Context, writer io.Writer, key str
 	fullFilePath := l.getFullFilePath(key)
 	file, err := os.Open(fullFilePath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("failed to open file %s: %w", key, ErrNotExist)
+		}
 		return fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
@@ -82,8 +113,31 @@ func (l *LocalStore) DownloadFile(ctx context.Context, writer io.Writer, key str
 	return nil
 }
 
+func (l *LocalStore) DownloadRange(ctx context.Context, writer io.Writer, key string, offset, length int64) error {
+	file, err := os.Open(l.getFullFilePath(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("failed to open file %s: %w", key, ErrNotExist)
+		}
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek file: %w", err)
+	}
+
+	var reader io.Reader = file
+	if length >= 0 {
+		reader = io.LimitReader(file, length)
+	}
+	if _, err := io.Copy(writer, reader); err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return nil
+}
+
 func (l *LocalStore) List(ctx context.Context, dir string) ([]*FileMeta, error) {
-	l.getFullFilePath(dir)
 	stats, err := os.ReadDir(l.getFullFilePath(dir))
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -104,11 +158,19 @@ func (l *LocalStore) List(ctx context.Context, dir string) ([]*FileMeta, error)
 				return nil, fmt.Errorf("failed to get file info: %w", err)
 			}
 			f.Size = info.Size()
+			f.ModTime = info.ModTime()
+			f.ETag = localETag(info)
 		}
+		files = append(files, f)
 	}
 	return files, nil
 }
 
+// localETag derives the etag from modification time and size like most web servers do
+func localETag(info os.FileInfo) string {
+	return fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size())
+}
+
 func (l *LocalStore) getFullFilePath(key string) string {
 	return filepath.Join(l.cfg.UploadDir, key)
 }
@@ -0,0 +1,15 @@
+package store_test
+
+import (
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/store/storetest"
+)
+
+func TestLocalStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return store.NewLocalStore(&config.LocalStoreConfig{UploadDir: t.TempDir()})
+	})
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ Store = (*MemStore)(nil)
+var _ RangeDownloader = (*MemStore)(nil)
+
+// MemStore keeps all files in memory, it is mainly used by tests
+type MemStore struct {
+	mu    sync.RWMutex
+	files map[string]*memFile
+}
+
+type memFile struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{files: make(map[string]*memFile)}
+}
+
+func (f *memFile) meta(name string) *FileMeta {
+	return &FileMeta{
+		Name:    name,
+		Size:    int64(len(f.data)),
+		ETag:    f.etag,
+		ModTime: f.modTime,
+	}
+}
+
+func (m *MemStore) UploadFile(ctx context.Context, reader io.Reader, filePath string) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	sum := md5.Sum(data)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[cleanKey(filePath)] = &memFile{
+		data:    data,
+		etag:    "\"" + hex.EncodeToString(sum[:]) + "\"",
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+func (m *MemStore) DeleteFile(ctx context.Context, filePath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.files, cleanKey(filePath))
+	return nil
+}
+
+func (m *MemStore) FileMeta(ctx context.Context, file string) (*FileMeta, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key := cleanKey(file)
+	f, ok := m.files[key]
+	if !ok {
+		return nil, nil
+	}
+	return f.meta(path.Base(key)), nil
+}
+
+func (m *MemStore) DownloadFile(ctx context.Context, writer io.Writer, key string) error {
+	m.mu.RLock()
+	f, ok := m.files[cleanKey(key)]
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("failed to download file %s: %w", key, ErrNotExist)
+	}
+
+	if _, err := io.Copy(writer, bytes.NewReader(f.data)); err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return nil
+}
+
+func (m *MemStore) DownloadRange(ctx context.Context, writer io.Writer, key string, offset, length int64) error {
+	m.mu.RLock()
+	f, ok := m.files[cleanKey(key)]
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("failed to download file %s: %w", key, ErrNotExist)
+	}
+
+	data := f.data
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if length >= 0 && length < int64(len(data)) {
+		data = data[:length]
+	}
+	if _, err := writer.Write(data); err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	return nil
+}
+
+func (m *MemStore) List(ctx context.Context, dir string) ([]*FileMeta, error) {
+	prefix := cleanKey(dir)
+	if prefix != "" {
+		prefix += "/"
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	dirs := make(map[string]bool)
+	var metas []*FileMeta
+	for key, f := range m.files {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		name := strings.TrimPrefix(key, prefix)
+		if i := strings.Index(name, "/"); i >= 0 {
+			dirs[name[:i]] = true
+			continue
+		}
+		metas = append(metas, f.meta(name))
+	}
+	for name := range dirs {
+		metas = append(metas, &FileMeta{
+			Name:  name,
+			IsDir: true,
+		})
+	}
+
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].Name < metas[j].Name
+	})
+	return metas, nil
+}
+
+// cleanKey normalizes a key to a slash separated path without leading or trailing slash
+func cleanKey(key string) string {
+	key = path.Clean("/" + strings.ReplaceAll(key, "\\", "/"))
+	return strings.TrimPrefix(key, "/")
+}
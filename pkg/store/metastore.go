@@ -0,0 +1,184 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+)
+
+var _ MetadataStore = (*MetaStore)(nil)
+var _ RangeDownloader = (*MetaStore)(nil)
+
+const metaDir = ".meta"
+
+// MetaExpiresAt is the metadata key of the RFC 3339 time after which the file is deleted
+const MetaExpiresAt = "expires-at"
+
+// MetaSHA256 is the metadata key of the hex SHA-256 of the content computed at upload
+const MetaSHA256 = "sha256"
+
+// MetadataStore is implemented by stores which keep user metadata with the files
+type MetadataStore interface {
+	Store
+
+	// UploadFileWithMetadata uploads the file like UploadFile and replaces its metadata
+	UploadFileWithMetadata(ctx context.Context, reader io.Reader, filePath string, metadata map[string]string) error
+
+	// SetMetadata replaces the metadata of an existing file
+	SetMetadata(ctx context.Context, filePath string, metadata map[string]string) error
+}
+
+// UploadFileWithMetadata uploads the file with metadata, the metadata is dropped if st is not a MetadataStore
+func UploadFileWithMetadata(ctx context.Context, st Store, reader io.Reader, filePath string, metadata map[string]string) error {
+	if ms, ok := st.(MetadataStore); ok {
+		return ms.UploadFileWithMetadata(ctx, reader, filePath, metadata)
+	}
+	return st.UploadFile(ctx, reader, filePath)
+}
+
+// SetMetadata replaces the metadata of the file, it does nothing if st is not a MetadataStore
+func SetMetadata(ctx context.Context, st Store, filePath string, metadata map[string]string) error {
+	if ms, ok := st.(MetadataStore); ok {
+		return ms.SetMetadata(ctx, filePath, metadata)
+	}
+	return nil
+}
+
+// MetaStore keeps the metadata of every file as a JSON sidecar in the .meta tree, like the object
+// metadata of S3 it is replaced when the file is uploaded again and removed with the file.
+type MetaStore struct {
+	inner Store
+}
+
+func NewMetaStore(inner Store) *MetaStore {
+	return &MetaStore{inner: inner}
+}
+
+func (m *MetaStore) Unwrap() Store {
+	return m.inner
+}
+
+func (m *MetaStore) UploadFile(ctx context.Context, reader io.Reader, filePath string) error {
+	return m.UploadFileWithMetadata(ctx, reader, filePath, nil)
+}
+
+func (m *MetaStore) UploadFileWithMetadata(ctx context.Context, reader io.Reader, filePath string, metadata map[string]string) error {
+	if err := m.inner.UploadFile(ctx, reader, filePath); err != nil {
+		return err
+	}
+	if isDecoratorKey(cleanKey(filePath)) {
+		return nil
+	}
+	return m.writeMetadata(ctx, filePath, metadata)
+}
+
+func (m *MetaStore) SetMetadata(ctx context.Context, filePath string, metadata map[string]string) error {
+	meta, err := m.inner.FileMeta(ctx, filePath)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return fmt.Errorf("failed to set metadata of %s: %w", filePath, ErrNotExist)
+	}
+	return m.writeMetadata(ctx, filePath, metadata)
+}
+
+func (m *MetaStore) DeleteFile(ctx context.Context, filePath string) error {
+	if err := m.inner.DeleteFile(ctx, filePath); err != nil {
+		return err
+	}
+	if isDecoratorKey(cleanKey(filePath)) {
+		return nil
+	}
+	return m.inner.DeleteFile(ctx, metaKey(filePath))
+}
+
+func (m *MetaStore) FileMeta(ctx context.Context, file string) (*FileMeta, error) {
+	meta, err := m.inner.FileMeta(ctx, file)
+	if err != nil || meta == nil || isDecoratorKey(cleanKey(file)) {
+		return meta, err
+	}
+	if meta.Metadata, err = m.readMetadata(ctx, file); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// List fills in the metadata of the files, only the sidecars which exist are read
+func (m *MetaStore) List(ctx context.Context, dir string) ([]*FileMeta, error) {
+	metas, err := m.inner.List(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	if cleanKey(dir) == "" {
+		metas = hideEntry(metas, metaDir)
+	}
+	if isDecoratorKey(cleanKey(dir)) {
+		return metas, nil
+	}
+
+	sidecars, err := m.inner.List(ctx, metaKey(dir))
+	if err != nil {
+		return nil, err
+	}
+	hasMetadata := make(map[string]bool, len(sidecars))
+	for _, sidecar := range sidecars {
+		if !sidecar.IsDir {
+			hasMetadata[sidecar.Name] = true
+		}
+	}
+	for _, meta := range metas {
+		if meta.IsDir || !hasMetadata[meta.Name] {
+			continue
+		}
+		if meta.Metadata, err = m.readMetadata(ctx, path.Join(dir, meta.Name)); err != nil {
+			return nil, err
+		}
+	}
+	return metas, nil
+}
+
+func (m *MetaStore) DownloadFile(ctx context.Context, writer io.Writer, key string) error {
+	return m.inner.DownloadFile(ctx, writer, key)
+}
+
+func (m *MetaStore) DownloadRange(ctx context.Context, writer io.Writer, key string, offset, length int64) error {
+	return DownloadRange(ctx, m.inner, writer, key, offset, length)
+}
+
+func (m *MetaStore) readMetadata(ctx context.Context, filePath string) (map[string]string, error) {
+	buffer := bytes.NewBuffer(nil)
+	if err := m.inner.DownloadFile(ctx, buffer, metaKey(filePath)); err != nil {
+		if errors.Is(err, ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read metadata of %s: %w", filePath, err)
+	}
+	var metadata map[string]string
+	if err := json.Unmarshal(buffer.Bytes(), &metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse metadata of %s: %w", filePath, err)
+	}
+	return metadata, nil
+}
+
+func (m *MetaStore) writeMetadata(ctx context.Context, filePath string, metadata map[string]string) error {
+	if len(metadata) == 0 {
+		return m.inner.DeleteFile(ctx, metaKey(filePath))
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if err := m.inner.UploadFile(ctx, bytes.NewReader(data), metaKey(filePath)); err != nil {
+		return fmt.Errorf("failed to write metadata of %s: %w", filePath, err)
+	}
+	return nil
+}
+
+func metaKey(filePath string) string {
+	return path.Join(metaDir, cleanKey(filePath))
+}
@@ -0,0 +1,96 @@
+package store_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/store/storetest"
+)
+
+func TestMetaStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return store.NewMetaStore(store.NewMemStore())
+	})
+}
+
+func TestMetaStoreMetadata(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMetaStore(store.NewMemStore())
+
+	metadata := map[string]string{"owner": "alice"}
+	if err := st.UploadFileWithMetadata(ctx, strings.NewReader("data"), "dir/a.txt", metadata); err != nil {
+		t.Fatal(err)
+	}
+	storetest.Upload(t, st, "dir/b.txt", "data")
+
+	meta, err := st.FileMeta(ctx, "dir/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Metadata["owner"] != "alice" {
+		t.Fatalf("unexpected metadata: %v", meta.Metadata)
+	}
+
+	metas, err := st.List(ctx, "dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, meta := range metas {
+		if got := meta.Metadata["owner"]; (meta.Name == "a.txt") != (got == "alice") {
+			t.Fatalf("unexpected metadata of %s: %v", meta.Name, meta.Metadata)
+		}
+	}
+
+	// uploading again replaces the metadata
+	storetest.Upload(t, st, "dir/a.txt", "new data")
+	if meta, err = st.FileMeta(ctx, "dir/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if meta.Metadata != nil {
+		t.Fatalf("metadata is kept after overwrite: %v", meta.Metadata)
+	}
+
+	if err := st.SetMetadata(ctx, "dir/missing.txt", metadata); err == nil {
+		t.Fatal("expected an error setting metadata of a missing file")
+	}
+
+	// the sidecar is removed with the file and hidden from the listing
+	if err := st.SetMetadata(ctx, "dir/a.txt", metadata); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"dir/a.txt", "dir/b.txt"} {
+		if err := st.DeleteFile(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if metas, err = st.Unwrap().List(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 0 {
+		t.Fatalf("unexpected entries left: %+v", metas)
+	}
+}
+
+func TestMetaStorePastes(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMetaStore(store.NewMemStore())
+
+	// the pastes are internal but keep their metadata, e.g. the expiry the retention sweeps them by
+	key := store.PasteDir + "/records/a.json"
+	if !store.IsInternalKey(key) || store.IsInternalKey("pastes/a.json") {
+		t.Fatalf("unexpected internal keys")
+	}
+	metadata := map[string]string{store.MetaExpiresAt: "2020-01-01T00:00:00Z"}
+	if err := st.UploadFileWithMetadata(ctx, strings.NewReader("{}"), key, metadata); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := st.FileMeta(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Metadata[store.MetaExpiresAt] != metadata[store.MetaExpiresAt] {
+		t.Fatalf("unexpected metadata: %v", meta.Metadata)
+	}
+}
@@ -0,0 +1,487 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/graydovee/fileManager/pkg/config"
+)
+
+var _ MetadataStore = (*QuotaStore)(nil)
+var _ RangeDownloader = (*QuotaStore)(nil)
+
+var (
+	// ErrQuotaExceeded is returned (wrapped) by an upload which would exceed a quota
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrFileTooLarge is returned (wrapped) by an upload larger than the max file size
+	ErrFileTooLarge = errors.New("file too large")
+)
+
+// MetaOwner is the metadata key of the owner of a file, see TokenOwner
+const MetaOwner = "owner"
+
+// anyOwner is the token of the rule which applies to every token without a rule of its own
+const anyOwner = "*"
+
+type ownerKey struct{}
+
+// WithOwner attributes the uploads made with ctx to owner
+func WithOwner(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, owner)
+}
+
+func ownerFrom(ctx context.Context) string {
+	owner, _ := ctx.Value(ownerKey{}).(string)
+	return owner
+}
+
+// TokenOwner returns the owner of the files uploaded with token, a fingerprint so that the token is never stored
+func TokenOwner(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// QuotaLimit limits the size and the count of files, 0 means unlimited
+type QuotaLimit struct {
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+	MaxFiles int64 `json:"maxFiles,omitempty"`
+}
+
+// QuotaRule limits the files of an owner, or of every owner separately if Owner is *, or the files under Prefix
+type QuotaRule struct {
+	Owner  string
+	Prefix string
+	Limit  QuotaLimit
+}
+
+// ParseQuotaRule parses token:<token>=<size>[/<files>], token:*=<size>[/<files>] or prefix:<path>=<size>[/<files>]
+func ParseQuotaRule(s string) (QuotaRule, error) {
+	scope, limit, ok := strings.Cut(strings.TrimSpace(s), "=")
+	if !ok {
+		return QuotaRule{}, fmt.Errorf("invalid quota rule %q", s)
+	}
+	var rule QuotaRule
+	kind, value, _ := strings.Cut(scope, ":")
+	switch {
+	case kind == "token" && value == anyOwner:
+		rule.Owner = anyOwner
+	case kind == "token" && value != "":
+		rule.Owner = TokenOwner(value)
+	case kind == "prefix" && cleanKey(value) != "":
+		rule.Prefix = cleanKey(value)
+	default:
+		return QuotaRule{}, fmt.Errorf("invalid quota rule %q", s)
+	}
+
+	size, files, _ := strings.Cut(limit, "/")
+	var err error
+	if rule.Limit.MaxBytes, err = config.ParseSize(size); err != nil {
+		return QuotaRule{}, fmt.Errorf("invalid quota rule %q: %w", s, err)
+	}
+	if files != "" {
+		if rule.Limit.MaxFiles, err = strconv.ParseInt(strings.TrimSpace(files), 10, 64); err != nil {
+			return QuotaRule{}, fmt.Errorf("invalid quota rule %q: %w", s, err)
+		}
+	}
+	return rule, nil
+}
+
+// Usage is the storage used in the scope of a quota
+type Usage struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+	QuotaLimit
+}
+
+// QuotaUsage is the usage of the scopes which apply to an owner
+type QuotaUsage struct {
+	Total    Usage            `json:"total"`
+	Owner    *Usage           `json:"owner,omitempty"`
+	Prefixes map[string]Usage `json:"prefixes,omitempty"`
+}
+
+// quotaScope is the usage of a quota including the uploads in progress
+type quotaScope struct {
+	Usage
+	pendingBytes int64
+	pendingFiles int64
+}
+
+func (s *quotaScope) fits(bytes, files int64) bool {
+	return (s.MaxBytes <= 0 || s.Bytes+s.pendingBytes+bytes <= s.MaxBytes) &&
+		(s.MaxFiles <= 0 || s.Files+s.pendingFiles+files <= s.MaxFiles)
+}
+
+type quotaEntry struct {
+	size  int64
+	owner string
+}
+
+// QuotaStore enforces the max file size and the quotas of all files, of the owners and of path prefixes while
+// the uploads are streamed. The usage is indexed in memory, it is built by scanning the store when created and
+// kept current on upload and delete, Refresh and Rebuild catch up with the changes made around the decorator.
+// The owner of an upload is taken from the context and saved in the metadata, the internal trees are not counted.
+type QuotaStore struct {
+	inner       Store
+	maxFileSize int64
+	limit       QuotaLimit
+	rules       []QuotaRule
+
+	mu       sync.Mutex
+	files    map[string]quotaEntry
+	total    *quotaScope
+	owners   map[string]*quotaScope
+	prefixes []*quotaScope
+}
+
+// NewQuotaStore wraps inner and builds the usage index, rules are checked in order and the first owner rule applies
+func NewQuotaStore(ctx context.Context, inner Store, limit QuotaLimit, maxFileSize int64, rules []QuotaRule) (*QuotaStore, error) {
+	q := &QuotaStore{
+		inner:       inner,
+		maxFileSize: maxFileSize,
+		limit:       limit,
+		rules:       rules,
+	}
+	if err := q.Rebuild(ctx); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+func newQuotaStoreFromConfig(ctx context.Context, inner Store, cfg *config.QuotaConfig) (*QuotaStore, error) {
+	rules := make([]QuotaRule, 0, len(cfg.Rules))
+	for _, s := range cfg.Rules {
+		rule, err := ParseQuotaRule(s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return NewQuotaStore(ctx, inner, QuotaLimit{MaxBytes: cfg.MaxBytes, MaxFiles: cfg.MaxFiles}, cfg.MaxFileSize, rules)
+}
+
+func (q *QuotaStore) Unwrap() Store {
+	return q.inner
+}
+
+func (q *QuotaStore) UploadFile(ctx context.Context, reader io.Reader, filePath string) error {
+	return q.UploadFileWithMetadata(ctx, reader, filePath, nil)
+}
+
+func (q *QuotaStore) UploadFileWithMetadata(ctx context.Context, reader io.Reader, filePath string, metadata map[string]string) error {
+	key := cleanKey(filePath)
+	if isDecoratorKey(key) {
+		return UploadFileWithMetadata(ctx, q.inner, reader, filePath, metadata)
+	}
+
+	owner := ownerFrom(ctx)
+	res, err := q.reserve(key, owner)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", filePath, err)
+	}
+	defer q.release(res)
+
+	counter := &quotaReader{r: reader, q: q, res: res}
+	if err := UploadFileWithMetadata(ctx, q.inner, counter, filePath, withOwner(metadata, owner)); err != nil {
+		// the inner stores do not always wrap the errors of the reader
+		if counter.err != nil {
+			return fmt.Errorf("failed to upload %s: %w", filePath, counter.err)
+		}
+		return err
+	}
+	q.commit(res)
+	return nil
+}
+
+// SetMetadata replaces the metadata of the file, the owner is kept
+func (q *QuotaStore) SetMetadata(ctx context.Context, filePath string, metadata map[string]string) error {
+	q.mu.Lock()
+	owner := q.files[cleanKey(filePath)].owner
+	q.mu.Unlock()
+	return SetMetadata(ctx, q.inner, filePath, withOwner(metadata, owner))
+}
+
+func (q *QuotaStore) DeleteFile(ctx context.Context, filePath string) error {
+	if err := q.inner.DeleteFile(ctx, filePath); err != nil {
+		return err
+	}
+	key := cleanKey(filePath)
+	if isDecoratorKey(key) {
+		return nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for k := range q.files {
+		if key == "" || k == key || strings.HasPrefix(k, key+"/") {
+			q.remove(k)
+		}
+	}
+	return nil
+}
+
+func (q *QuotaStore) FileMeta(ctx context.Context, file string) (*FileMeta, error) {
+	return q.inner.FileMeta(ctx, file)
+}
+
+func (q *QuotaStore) List(ctx context.Context, dir string) ([]*FileMeta, error) {
+	return q.inner.List(ctx, dir)
+}
+
+func (q *QuotaStore) DownloadFile(ctx context.Context, writer io.Writer, key string) error {
+	return q.inner.DownloadFile(ctx, writer, key)
+}
+
+func (q *QuotaStore) DownloadRange(ctx context.Context, writer io.Writer, key string, offset, length int64) error {
+	return DownloadRange(ctx, q.inner, writer, key, offset, length)
+}
+
+// Usage returns the usage of all files, of owner and of the prefix rules
+func (q *QuotaStore) Usage(owner string) *QuotaUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	usage := &QuotaUsage{Total: q.total.Usage}
+	if owner != "" {
+		if scope, ok := q.owners[owner]; ok {
+			usage.Owner = &scope.Usage
+		} else {
+			usage.Owner = &Usage{QuotaLimit: q.ownerLimit(owner)}
+		}
+	}
+	for i, rule := range q.rules {
+		if rule.Prefix == "" {
+			continue
+		}
+		if usage.Prefixes == nil {
+			usage.Prefixes = make(map[string]Usage)
+		}
+		usage.Prefixes[rule.Prefix] = q.prefixes[i].Usage
+	}
+	return usage
+}
+
+// Refresh updates the index entry of the file from the inner store, e.g. after it is restored from the trash
+func (q *QuotaStore) Refresh(ctx context.Context, filePath string) error {
+	key := cleanKey(filePath)
+	meta, err := q.inner.FileMeta(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.remove(key)
+	if meta != nil && !meta.IsDir {
+		q.add(key, quotaEntry{size: meta.Size, owner: meta.Metadata[MetaOwner]})
+	}
+	return nil
+}
+
+// Rebuild replaces the index with the usage found by scanning the store
+func (q *QuotaStore) Rebuild(ctx context.Context) error {
+	files := make(map[string]quotaEntry)
+	err := Walk(ctx, q.inner, "", func(key string, meta *FileMeta) error {
+		files[key] = quotaEntry{size: meta.Size, owner: meta.Metadata[MetaOwner]}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan the store for the quota usage: %w", err)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.files = make(map[string]quotaEntry, len(files))
+	q.total = &quotaScope{Usage: Usage{QuotaLimit: q.limit}}
+	q.owners = make(map[string]*quotaScope)
+	q.prefixes = make([]*quotaScope, len(q.rules))
+	for i, rule := range q.rules {
+		q.prefixes[i] = &quotaScope{Usage: Usage{QuotaLimit: rule.Limit}}
+	}
+	for key, entry := range files {
+		q.add(key, entry)
+	}
+	return nil
+}
+
+// ownerLimit returns the limit of the first rule of owner, or of the first rule of every owner
+func (q *QuotaStore) ownerLimit(owner string) QuotaLimit {
+	for _, rule := range q.rules {
+		if rule.Owner == owner {
+			return rule.Limit
+		}
+	}
+	for _, rule := range q.rules {
+		if rule.Owner == anyOwner {
+			return rule.Limit
+		}
+	}
+	return QuotaLimit{}
+}
+
+// scopes returns the scopes a file is counted in
+func (q *QuotaStore) scopes(key, owner string) []*quotaScope {
+	scopes := []*quotaScope{q.total}
+	if owner != "" {
+		scope, ok := q.owners[owner]
+		if !ok {
+			scope = &quotaScope{Usage: Usage{QuotaLimit: q.ownerLimit(owner)}}
+			q.owners[owner] = scope
+		}
+		scopes = append(scopes, scope)
+	}
+	for i, rule := range q.rules {
+		if rule.Prefix != "" && (key == rule.Prefix || strings.HasPrefix(key, rule.Prefix+"/")) {
+			scopes = append(scopes, q.prefixes[i])
+		}
+	}
+	return scopes
+}
+
+func (q *QuotaStore) add(key string, entry quotaEntry) {
+	q.files[key] = entry
+	for _, scope := range q.scopes(key, entry.owner) {
+		scope.Bytes += entry.size
+		scope.Files++
+	}
+}
+
+func (q *QuotaStore) remove(key string) {
+	entry, ok := q.files[key]
+	if !ok {
+		return
+	}
+	delete(q.files, key)
+	for _, scope := range q.scopes(key, entry.owner) {
+		scope.Bytes -= entry.size
+		scope.Files--
+	}
+}
+
+// quotaReservation counts an upload in progress in its scopes, the file it replaces is credited to the
+// scopes it is counted in
+type quotaReservation struct {
+	key    string
+	owner  string
+	size   int64
+	scopes []*reservedScope
+	done   bool
+}
+
+type reservedScope struct {
+	*quotaScope
+	creditBytes int64
+	newFiles    int64
+}
+
+func (q *QuotaStore) reserve(key, owner string) (*quotaReservation, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	res := &quotaReservation{key: key, owner: owner}
+	old, exists := q.files[key]
+	var oldScopes []*quotaScope
+	if exists {
+		oldScopes = q.scopes(key, old.owner)
+	}
+	for _, scope := range q.scopes(key, owner) {
+		reserved := &reservedScope{quotaScope: scope, newFiles: 1}
+		for _, oldScope := range oldScopes {
+			if oldScope == scope {
+				reserved.creditBytes, reserved.newFiles = old.size, 0
+			}
+		}
+		if !scope.fits(-reserved.creditBytes, reserved.newFiles) {
+			return nil, ErrQuotaExceeded
+		}
+		res.scopes = append(res.scopes, reserved)
+	}
+	for _, scope := range res.scopes {
+		scope.pendingFiles += scope.newFiles
+	}
+	return res, nil
+}
+
+// grow counts n more bytes of the upload, it fails if they exceed the max file size or a quota
+func (q *QuotaStore) grow(res *quotaReservation, n int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.maxFileSize > 0 && res.size+n > q.maxFileSize {
+		return ErrFileTooLarge
+	}
+	for _, scope := range res.scopes {
+		if !scope.fits(n-scope.creditBytes, 0) {
+			return ErrQuotaExceeded
+		}
+	}
+	res.size += n
+	for _, scope := range res.scopes {
+		scope.pendingBytes += n
+	}
+	return nil
+}
+
+// commit replaces the index entry of the file with the completed upload
+func (q *QuotaStore) commit(res *quotaReservation) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked(res)
+	q.remove(res.key)
+	q.add(res.key, quotaEntry{size: res.size, owner: res.owner})
+}
+
+func (q *QuotaStore) release(res *quotaReservation) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.releaseLocked(res)
+}
+
+func (q *QuotaStore) releaseLocked(res *quotaReservation) {
+	if res.done {
+		return
+	}
+	res.done = true
+	for _, scope := range res.scopes {
+		scope.pendingBytes -= res.size
+		scope.pendingFiles -= scope.newFiles
+	}
+}
+
+// quotaReader counts the bytes of an upload and fails once they exceed a limit
+type quotaReader struct {
+	r   io.Reader
+	q   *QuotaStore
+	res *quotaReservation
+	err error
+}
+
+func (r *quotaReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if r.err = r.q.grow(r.res, int64(n)); r.err != nil {
+			return 0, r.err
+		}
+	}
+	return n, err
+}
+
+// withOwner returns a copy of metadata with the owner set
+func withOwner(metadata map[string]string, owner string) map[string]string {
+	if owner == "" {
+		return metadata
+	}
+	md := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		md[k] = v
+	}
+	md[MetaOwner] = owner
+	return md
+}
@@ -0,0 +1,130 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/store/storetest"
+)
+
+func TestQuotaStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		st, err := store.NewQuotaStore(context.Background(), store.NewMetaStore(store.NewMemStore()), store.QuotaLimit{}, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	})
+}
+
+func TestParseQuotaRule(t *testing.T) {
+	rule, err := store.ParseQuotaRule("token:secret=10K/5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.Owner != store.TokenOwner("secret") || rule.Limit.MaxBytes != 10<<10 || rule.Limit.MaxFiles != 5 {
+		t.Fatalf("unexpected rule: %+v", rule)
+	}
+	if rule, err = store.ParseQuotaRule("prefix:/tmp/=1M"); err != nil || rule.Prefix != "tmp" || rule.Limit.MaxFiles != 0 {
+		t.Fatalf("unexpected rule: %+v, %v", rule, err)
+	}
+	for _, s := range []string{"tmp=1M", "user:a=1M", "token:=1M", "prefix:/=1M", "token:*=big", "token:*=1M/many"} {
+		if _, err := store.ParseQuotaRule(s); err == nil {
+			t.Fatalf("expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestQuotaStoreLimits(t *testing.T) {
+	ctx := context.Background()
+	inner := store.NewMetaStore(store.NewMemStore())
+	storetest.Upload(t, inner, "existing.txt", "12345")
+
+	alice, err := store.ParseQuotaRule("token:alice=10/2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := store.ParseQuotaRule("prefix:tmp=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.NewQuotaStore(ctx, inner, store.QuotaLimit{MaxBytes: 30}, 8, []store.QuotaRule{alice, tmp})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if usage := st.Usage(""); usage.Total.Bytes != 5 || usage.Total.Files != 1 {
+		t.Fatalf("existing files are not indexed: %+v", usage.Total)
+	}
+
+	upload := func(ctx context.Context, key, content string) error {
+		return st.UploadFile(ctx, strings.NewReader(content), key)
+	}
+	if err := upload(ctx, "big.txt", "123456789"); !errors.Is(err, store.ErrFileTooLarge) {
+		t.Fatalf("expected ErrFileTooLarge, got %v", err)
+	}
+	if err := upload(ctx, "tmp/a.txt", "12345"); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded of the prefix, got %v", err)
+	}
+
+	// the owner is limited to 10 bytes and 2 files, an overwrite frees the replaced file
+	owner := store.TokenOwner("alice")
+	ctx = store.WithOwner(ctx, owner)
+	if err := upload(ctx, "a.txt", "1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := upload(ctx, "b.txt", "1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := upload(ctx, "c.txt", "1"); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded of the file count, got %v", err)
+	}
+	if err := upload(ctx, "b.txt", "123456"); err != nil {
+		t.Fatal(err)
+	}
+	if err := upload(ctx, "a.txt", "12345"); !errors.Is(err, store.ErrQuotaExceeded) {
+		t.Fatalf("expected ErrQuotaExceeded of the size, got %v", err)
+	}
+	if got := storetest.Download(t, st, "a.txt"); got != "1234" {
+		t.Fatalf("the rejected upload replaced the file: %q", got)
+	}
+
+	usage := st.Usage(owner)
+	if usage.Owner == nil || usage.Owner.Bytes != 10 || usage.Owner.Files != 2 || usage.Owner.MaxBytes != 10 {
+		t.Fatalf("unexpected owner usage: %+v", usage.Owner)
+	}
+	if usage.Total.Bytes != 15 || usage.Total.Files != 3 {
+		t.Fatalf("unexpected total usage: %+v", usage.Total)
+	}
+
+	// the owner is saved in the metadata and kept when the metadata is replaced
+	if err := st.SetMetadata(ctx, "a.txt", map[string]string{"k": "v"}); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := st.FileMeta(ctx, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Metadata[store.MetaOwner] != owner || meta.Metadata["k"] != "v" {
+		t.Fatalf("unexpected metadata: %v", meta.Metadata)
+	}
+
+	if err := st.DeleteFile(ctx, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if usage := st.Usage(owner); usage.Owner.Bytes != 6 || usage.Owner.Files != 1 {
+		t.Fatalf("unexpected owner usage after delete: %+v", usage.Owner)
+	}
+
+	// the index is rebuilt from the owners in the metadata
+	storetest.Upload(t, inner, "outside.txt", "123")
+	if err := st.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+	usage = st.Usage(owner)
+	if usage.Owner.Bytes != 6 || usage.Total.Bytes != 14 || usage.Total.Files != 3 {
+		t.Fatalf("unexpected usage after rebuild: %+v %+v", usage.Total, usage.Owner)
+	}
+}
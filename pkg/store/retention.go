@@ -0,0 +1,117 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/config"
+)
+
+// RetentionRule expires the files matching Pattern TTL after they are uploaded.
+// Pattern is matched against the whole key, * matches within a path segment and ** matches any segments.
+type RetentionRule struct {
+	Pattern string
+	TTL     time.Duration
+}
+
+// ParseRetentionRule parses a rule like tmp/**=7d
+func ParseRetentionRule(s string) (RetentionRule, error) {
+	pattern, ttl, ok := strings.Cut(s, "=")
+	if !ok {
+		return RetentionRule{}, fmt.Errorf("invalid retention rule %q, expected pattern=ttl", s)
+	}
+	rule := RetentionRule{Pattern: cleanKey(strings.TrimSpace(pattern))}
+	if _, err := path.Match(rule.Pattern, ""); err != nil || rule.Pattern == "" {
+		return RetentionRule{}, fmt.Errorf("invalid pattern of retention rule %q", s)
+	}
+	d, err := config.ParseDuration(ttl)
+	if err != nil || d <= 0 {
+		return RetentionRule{}, fmt.Errorf("invalid ttl of retention rule %q", s)
+	}
+	rule.TTL = d
+	return rule, nil
+}
+
+// Match reports whether key matches the pattern of the rule
+func (r RetentionRule) Match(key string) bool {
+	return matchSegments(strings.Split(r.Pattern, "/"), strings.Split(cleanKey(key), "/"))
+}
+
+func matchSegments(pattern, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(pattern[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], segments[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
+
+// Retention decides when files expire, from the expires-at metadata set at upload or else the first matching rule
+type Retention struct {
+	rules []RetentionRule
+}
+
+func NewRetention(rules []RetentionRule) *Retention {
+	return &Retention{rules: rules}
+}
+
+// NewRetentionFromConfig parses the rules of cfg
+func NewRetentionFromConfig(cfg *config.RetentionConfig) (*Retention, error) {
+	rules := make([]RetentionRule, 0, len(cfg.Rules))
+	for _, s := range cfg.Rules {
+		rule, err := ParseRetentionRule(s)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return NewRetention(rules), nil
+}
+
+// ExpiresAt returns when the file expires, false if it is kept forever
+func (r *Retention) ExpiresAt(key string, meta *FileMeta) (time.Time, bool) {
+	if v, ok := meta.Metadata[MetaExpiresAt]; ok {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			return t, true
+		}
+	}
+	for _, rule := range r.rules {
+		if rule.Match(key) {
+			return meta.ModTime.Add(rule.TTL), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Sweep walks the store and deletes the expired files, the count of deleted files is returned
+func (r *Retention) Sweep(ctx context.Context, st Store) (int, error) {
+	now := time.Now()
+	deleted := 0
+	err := Walk(ctx, st, "", func(key string, meta *FileMeta) error {
+		expiresAt, ok := r.ExpiresAt(key, meta)
+		if !ok || expiresAt.After(now) {
+			return nil
+		}
+		if err := st.DeleteFile(ctx, key); err != nil {
+			return fmt.Errorf("failed to delete expired file %s: %w", key, err)
+		}
+		deleted++
+		return nil
+	})
+	return deleted, err
+}
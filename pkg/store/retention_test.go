@@ -0,0 +1,81 @@
+package store_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/store/storetest"
+)
+
+func TestRetentionRuleMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"tmp/**", "tmp/a.txt", true},
+		{"tmp/**", "tmp/a/b/c.txt", true},
+		{"tmp/**", "tmpfile", false},
+		{"**/*.log", "a.log", true},
+		{"**/*.log", "2024/10/app.log", true},
+		{"**/*.log", "2024/10/app.txt", false},
+		{"2024/*/*.iso", "2024/10/os.iso", true},
+		{"2024/*/*.iso", "2024/10/sub/os.iso", false},
+	}
+	for _, tt := range tests {
+		rule, err := store.ParseRetentionRule(tt.pattern + "=7d")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rule.TTL != 7*24*time.Hour {
+			t.Fatalf("unexpected ttl %s", rule.TTL)
+		}
+		if got := rule.Match(tt.key); got != tt.want {
+			t.Errorf("%s match %s = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"tmp/**", "tmp/**=", "=7d", "tmp/[=7d", "tmp/**=-1h"} {
+		if _, err := store.ParseRetentionRule(s); err == nil {
+			t.Errorf("expected an error parsing %q", s)
+		}
+	}
+}
+
+func TestRetentionSweep(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMetaStore(store.NewMemStore())
+	retention := store.NewRetention([]store.RetentionRule{{Pattern: "tmp/**", TTL: time.Nanosecond}})
+
+	storetest.Upload(t, st, "tmp/a.txt", "a")
+	storetest.Upload(t, st, "keep/b.txt", "b")
+	expired := map[string]string{store.MetaExpiresAt: time.Now().Add(-time.Minute).Format(time.RFC3339)}
+	if err := st.UploadFileWithMetadata(ctx, strings.NewReader("c"), "keep/c.txt", expired); err != nil {
+		t.Fatal(err)
+	}
+	later := map[string]string{store.MetaExpiresAt: time.Now().Add(time.Hour).Format(time.RFC3339)}
+	if err := st.UploadFileWithMetadata(ctx, strings.NewReader("d"), "keep/d.txt", later); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	deleted, err := retention.Sweep(ctx, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 2 {
+		t.Fatalf("deleted %d files, want 2", deleted)
+	}
+	for key, exist := range map[string]bool{"tmp/a.txt": false, "keep/b.txt": true, "keep/c.txt": false, "keep/d.txt": true} {
+		meta, err := st.FileMeta(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (meta != nil) != exist {
+			t.Errorf("%s exists = %v, want %v", key, meta != nil, exist)
+		}
+	}
+}
@@ -17,10 +17,12 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 	fconfig "github.com/graydovee/fileManager/pkg/config"
 )
 
 var _ Store = (*S3Store)(nil)
+var _ RangeDownloader = (*S3Store)(nil)
 
 type S3Store struct {
 	cfg *fconfig.S3StoreConfig
@@ -110,8 +112,10 @@ func (s *S3Store) FileMeta(ctx context.Context, file string) (*FileMeta, error)
 	}
 
 	meta := &FileMeta{
-		Name: filepath.Base(file),
-		Size: *head.ContentLength,
+		Name:    filepath.Base(file),
+		Size:    *head.ContentLength,
+		ETag:    aws.ToString(head.ETag),
+		ModTime: aws.ToTime(head.LastModified),
 	}
 
 	return meta, nil
@@ -148,9 +152,47 @@ func (s *S3Store) DownloadFile(ctx context.Context, writer io.Writer, key string
 	})
 
 	if err != nil {
+		var noSuchKeyErr *s3types.NoSuchKey
+		if errors.As(err, &noSuchKeyErr) {
+			return fmt.Errorf("failed to download file %s: %w", key, ErrNotExist)
+		}
+		return fmt.Errorf("failed to download file %s: %v", key, err)
+	}
+
+	return nil
+}
+
+func (s *S3Store) DownloadRange(ctx context.Context, writer io.Writer, key string, offset, length int64) error {
+	rangeHeader := fmt.Sprintf("bytes=%d-", offset)
+	if length == 0 {
+		return nil
+	}
+	if length > 0 {
+		rangeHeader = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+
+	output, err := s.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.cfg.Bucket,
+		Key:    &key,
+		Range:  &rangeHeader,
+	})
+	if err != nil {
+		var noSuchKeyErr *s3types.NoSuchKey
+		if errors.As(err, &noSuchKeyErr) {
+			return fmt.Errorf("failed to download file %s: %w", key, ErrNotExist)
+		}
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "InvalidRange" {
+			// offset is beyond the end of file
+			return nil
+		}
 		return fmt.Errorf("failed to download file %s: %v", key, err)
 	}
+	defer output.Body.Close()
 
+	if _, err := io.Copy(writer, output.Body); err != nil {
+		return fmt.Errorf("failed to copy file %s: %v", key, err)
+	}
 	return nil
 }
 
@@ -177,15 +219,17 @@ func (s *S3Store) List(ctx context.Context, dir string) ([]*FileMeta, error) {
 
 		for _, obj := range objects.CommonPrefixes {
 			metas = append(metas, &FileMeta{
-				Name:  strings.TrimPrefix(*obj.Prefix, dir),
+				Name:  strings.TrimSuffix(strings.TrimPrefix(*obj.Prefix, dir), "/"),
 				IsDir: true,
 			})
 		}
 
 		for _, obj := range objects.Contents {
 			metas = append(metas, &FileMeta{
-				Name: strings.TrimPrefix(*obj.Key, dir),
-				Size: *obj.Size,
+				Name:    strings.TrimPrefix(*obj.Key, dir),
+				Size:    *obj.Size,
+				ETag:    aws.ToString(obj.ETag),
+				ModTime: aws.ToTime(obj.LastModified),
 			})
 		}
 
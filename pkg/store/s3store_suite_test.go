@@ -0,0 +1,56 @@
+package store_test
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/store/storetest"
+)
+
+// TestS3StoreSuite runs the conformance suite against the S3 bucket of ../../.env or the environment. The
+// suite lists the root of the bucket, so it needs an empty bucket and is skipped if the bucket has files;
+// the files of every sub test are deleted after it.
+func TestS3StoreSuite(t *testing.T) {
+	cfg := config.GetDefault("../../.env")
+	if cfg.Store.S3.Endpoint == "" {
+		t.Skip("STORE_S3_ENDPOINT is not configured")
+	}
+	st, err := store.NewS3Store(&cfg.Store.S3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := st.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) > 0 {
+		t.Skipf("the bucket %s is not empty", cfg.Store.S3.Bucket)
+	}
+
+	storetest.Run(t, func(t *testing.T) store.Store {
+		t.Cleanup(func() {
+			deleteAll(t, st, "")
+		})
+		return st
+	})
+}
+
+// deleteAll deletes the files under the directory recursively
+func deleteAll(t *testing.T, st store.Store, dir string) {
+	ctx := context.Background()
+	files, err := st.List(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		key := path.Join(dir, file.Name)
+		if file.IsDir {
+			deleteAll(t, st, key)
+		} else if err := st.DeleteFile(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
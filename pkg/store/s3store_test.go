@@ -8,16 +8,25 @@ import (
 	"testing"
 )
 
-func TestListObj(t *testing.T) {
+// newTestS3Store creates a S3Store from ../../.env, the test is skipped if no endpoint is configured
+func newTestS3Store(t *testing.T) *S3Store {
 	cfg := config.GetDefault("../../.env")
 
 	flag.Parse()
 
+	if cfg.Store.S3.Endpoint == "" {
+		t.Skip("STORE_S3_ENDPOINT is not configured")
+	}
+
 	store, err := NewS3Store(&cfg.Store.S3)
 	if err != nil {
 		t.Fatal(err)
 	}
-	//store := NewLocalStore(&cfg.Store.Local)
+	return store
+}
+
+func TestListObj(t *testing.T) {
+	store := newTestS3Store(t)
 
 	dirs, err := store.List(context.Background(), "/codex/xx")
 	if err != nil {
@@ -25,22 +34,14 @@ func TestListObj(t *testing.T) {
 	}
 
 	t.Log(dirs)
-	t.Log(files)
 }
 
 func TestS3Store(t *testing.T) {
-	cfg := config.GetDefault("../../.env")
-
-	flag.Parse()
-
-	store, err := NewS3Store(&cfg.Store.S3)
-	if err != nil {
-		t.Fatal(err)
-	}
+	store := newTestS3Store(t)
 
 	buffer := bytes.NewBuffer([]byte("test"))
 
-	err = store.UploadFile(context.Background(), buffer, "test/test1.txt")
+	err := store.UploadFile(context.Background(), buffer, "test/test1.txt")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -51,7 +52,7 @@ func TestS3Store(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !exists {
+	if exists == nil {
 		t.Fatal("file not exists")
 	}
 
@@ -63,7 +64,7 @@ func TestS3Store(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if exists {
+	if exists != nil {
 		t.Fatal("file exists")
 	}
 }
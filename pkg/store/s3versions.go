@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+var _ VersionedStore = (*S3Store)(nil)
+
+// VersioningEnabled reports whether versioning is enabled on the bucket
+func (s *S3Store) VersioningEnabled(ctx context.Context) (bool, error) {
+	output, err := s.s3Client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{
+		Bucket: &s.cfg.Bucket,
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotImplemented" {
+			// S3 compatible services without versioning support
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get bucket versioning: %v", err)
+	}
+	return output.Status == s3types.BucketVersioningStatusEnabled, nil
+}
+
+func (s *S3Store) ListVersions(ctx context.Context, key string) ([]*FileVersion, error) {
+	var versions []*FileVersion
+	var keyMarker, versionIDMarker *string
+
+	for {
+		output, err := s.s3Client.ListObjectVersions(ctx, &s3.ListObjectVersionsInput{
+			Bucket:          &s.cfg.Bucket,
+			Prefix:          &key,
+			KeyMarker:       keyMarker,
+			VersionIdMarker: versionIDMarker,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list versions of %s: %v", key, err)
+		}
+
+		// versions of the same key are returned the newest first
+		for _, v := range output.Versions {
+			if aws.ToString(v.Key) != key {
+				continue
+			}
+			versions = append(versions, &FileVersion{
+				ID:       aws.ToString(v.VersionId),
+				Size:     aws.ToInt64(v.Size),
+				ModTime:  aws.ToTime(v.LastModified),
+				IsLatest: aws.ToBool(v.IsLatest),
+			})
+		}
+
+		if !aws.ToBool(output.IsTruncated) {
+			break
+		}
+		keyMarker, versionIDMarker = output.NextKeyMarker, output.NextVersionIdMarker
+	}
+	return versions, nil
+}
+
+func (s *S3Store) DownloadVersion(ctx context.Context, writer io.Writer, key, versionID string) error {
+	output, err := s.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:    &s.cfg.Bucket,
+		Key:       &key,
+		VersionId: &versionID,
+	})
+	if err != nil {
+		return s.versionError(key, versionID, err)
+	}
+	defer output.Body.Close()
+
+	if _, err := io.Copy(writer, output.Body); err != nil {
+		return fmt.Errorf("failed to copy version %s of %s: %v", versionID, key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) RestoreVersion(ctx context.Context, key, versionID string) error {
+	source := url.PathEscape(s.cfg.Bucket+"/"+key) + "?versionId=" + url.QueryEscape(versionID)
+	_, err := s.s3Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     &s.cfg.Bucket,
+		Key:        &key,
+		CopySource: &source,
+	})
+	if err != nil {
+		return s.versionError(key, versionID, err)
+	}
+	return nil
+}
+
+func (s *S3Store) DeleteVersion(ctx context.Context, key, versionID string) error {
+	_, err := s.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:    &s.cfg.Bucket,
+		Key:       &key,
+		VersionId: &versionID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete version %s of %s: %v", versionID, key, err)
+	}
+	return nil
+}
+
+func (s *S3Store) versionError(key, versionID string, err error) error {
+	var noSuchKeyErr *s3types.NoSuchKey
+	var apiErr smithy.APIError
+	if errors.As(err, &noSuchKeyErr) || (errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NoSuchVersion" || apiErr.ErrorCode() == "InvalidArgument")) {
+		return fmt.Errorf("version %s of %s: %w", versionID, key, ErrVersionNotExist)
+	}
+	return fmt.Errorf("failed to access version %s of %s: %v", versionID, key, err)
+}
@@ -2,18 +2,38 @@ package store
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/config"
 )
 
+// ErrNotExist is returned (wrapped) by DownloadFile when the key does not exist
+var ErrNotExist = errors.New("file does not exist")
+
+// ErrExist is returned (wrapped) when a file is not written because it already exists
+var ErrExist = errors.New("file already exists")
+
 type FileMeta struct {
 	Name  string
 	Size  int64
 	IsDir bool
+
+	// ETag changes whenever the content of the file changes, it is empty for directories
+	ETag    string
+	ModTime time.Time
+
+	// Metadata is the user metadata of the file, only filled in by the MetaStore
+	Metadata map[string]string
 }
 
 type Store interface {
+	// UploadFile creates the file or overwrites it if it already exists
 	UploadFile(ctx context.Context, reader io.Reader, filePath string) error
 
+	// DeleteFile removes the file, deleting a file which does not exist is not an error
 	DeleteFile(ctx context.Context, filePath string) error
 
 	// FileMeta Only support file stat, if it is a directory, consider it as not exist
@@ -21,7 +41,75 @@ type Store interface {
 	FileMeta(ctx context.Context, file string) (*FileMeta, error)
 
 	// List all files or directories under the directory
+	// directory names have no trailing slash, return empty if directory not exist
 	List(ctx context.Context, dir string) ([]*FileMeta, error)
 
+	// DownloadFile writes the file content to writer, return ErrNotExist if file not exist
 	DownloadFile(ctx context.Context, writer io.Writer, key string) error
 }
+
+// New creates the store configured by cfg, including the enabled decorators
+func New(cfg *config.StoreConfig) (Store, error) {
+	var st Store
+	switch cfg.Type {
+	case config.StoreTypeLocal:
+		st = NewLocalStore(&cfg.Local)
+	case config.StoreTypeS3:
+		s3Store, err := NewS3Store(&cfg.S3)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create S3 store: %w", err)
+		}
+		st = s3Store
+	case config.StoreTypeMemory:
+		st = NewMemStore()
+	default:
+		return nil, fmt.Errorf("unsupported store type: %s", cfg.Type)
+	}
+
+	if cfg.Cache.Dir != "" {
+		cacheStore, err := NewCacheStore(st, cfg.Cache.Dir, cfg.Cache.MaxSize)
+		if err != nil {
+			return nil, err
+		}
+		st = cacheStore
+	}
+
+	// the cache keeps the ciphertext, so that no plaintext is written to the local disk
+	if cfg.Encryption.Enabled() {
+		encryptStore, err := newEncryptStoreFromConfig(st, &cfg.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		st = encryptStore
+	}
+
+	// dedup must see the plaintext, the encrypted content of identical files differs
+	if cfg.Dedup {
+		st = NewDedupStore(st)
+	}
+
+	if cfg.Versioning.Enabled {
+		versionStore, err := NewVersionStore(context.Background(), st, cfg.Versioning.MaxVersions, cfg.Versioning.MaxAge)
+		if err != nil {
+			return nil, err
+		}
+		st = versionStore
+	}
+
+	if cfg.Trash.Enabled {
+		st = NewTrashStore(st)
+	}
+
+	// the metadata is written through the other decorators as regular files
+	st = NewMetaStore(st)
+
+	// the quota records the owners in the metadata
+	if cfg.Quota.Enabled() {
+		quotaStore, err := newQuotaStoreFromConfig(context.Background(), st, &cfg.Quota)
+		if err != nil {
+			return nil, err
+		}
+		st = quotaStore
+	}
+	return st, nil
+}
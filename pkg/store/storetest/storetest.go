@@ -0,0 +1,243 @@
+// Package storetest provides a conformance test suite which every store.Store implementation must pass.
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/store"
+)
+
+// Factory returns a new empty store for each sub test
+type Factory func(t *testing.T) store.Store
+
+// Run runs the whole conformance suite against the stores created by factory
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, st store.Store)
+	}{
+		{"UploadAndDownload", testUploadAndDownload},
+		{"Overwrite", testOverwrite},
+		{"EmptyFile", testEmptyFile},
+		{"FileMeta", testFileMeta},
+		{"List", testList},
+		{"ListNestedDirs", testListNestedDirs},
+		{"Delete", testDelete},
+		{"NotExist", testNotExist},
+		{"DownloadRange", testDownloadRange},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, factory(t))
+		})
+	}
+}
+
+// Upload uploads content to key and fails the test on error
+func Upload(t *testing.T, st store.Store, key, content string) {
+	t.Helper()
+	if err := st.UploadFile(context.Background(), strings.NewReader(content), key); err != nil {
+		t.Fatalf("upload %s: %v", key, err)
+	}
+}
+
+// Download returns the content of key and fails the test on error
+func Download(t *testing.T, st store.Store, key string) string {
+	t.Helper()
+	buffer := bytes.NewBuffer(nil)
+	if err := st.DownloadFile(context.Background(), buffer, key); err != nil {
+		t.Fatalf("download %s: %v", key, err)
+	}
+	return buffer.String()
+}
+
+func testUploadAndDownload(t *testing.T, st store.Store) {
+	content := strings.Repeat("hello world\n", 1024)
+	Upload(t, st, "a/b/c.txt", content)
+
+	if got := Download(t, st, "a/b/c.txt"); got != content {
+		t.Fatalf("downloaded content mismatch, got %d bytes, want %d bytes", len(got), len(content))
+	}
+}
+
+func testOverwrite(t *testing.T, st store.Store) {
+	Upload(t, st, "overwrite.txt", "a long first version")
+	Upload(t, st, "overwrite.txt", "second")
+
+	if got := Download(t, st, "overwrite.txt"); got != "second" {
+		t.Fatalf("got %q after overwrite, want %q", got, "second")
+	}
+	meta, err := st.FileMeta(context.Background(), "overwrite.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta == nil || meta.Size != int64(len("second")) {
+		t.Fatalf("unexpected meta after overwrite: %+v", meta)
+	}
+
+	Upload(t, st, "overwrite.txt", "third version")
+	newMeta, err := st.FileMeta(context.Background(), "overwrite.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newMeta == nil || newMeta.ETag == "" || newMeta.ETag == meta.ETag {
+		t.Fatalf("etag should change after overwrite, got %q and %q", meta.ETag, newMeta.ETag)
+	}
+}
+
+func testEmptyFile(t *testing.T, st store.Store) {
+	Upload(t, st, "empty", "")
+
+	meta, err := st.FileMeta(context.Background(), "empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta == nil || meta.Size != 0 {
+		t.Fatalf("unexpected meta of empty file: %+v", meta)
+	}
+	if got := Download(t, st, "empty"); got != "" {
+		t.Fatalf("got %q, want empty content", got)
+	}
+}
+
+func testFileMeta(t *testing.T, st store.Store) {
+	Upload(t, st, "dir/file.txt", "12345")
+
+	meta, err := st.FileMeta(context.Background(), "dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta == nil {
+		t.Fatal("meta of existing file is nil")
+	}
+	if meta.Name != "file.txt" || meta.Size != 5 || meta.IsDir || meta.ETag == "" || meta.ModTime.IsZero() {
+		t.Fatalf("unexpected meta: %+v", meta)
+	}
+
+	// directories are not files
+	for _, key := range []string{"dir", "dir/", "", "missing.txt", "dir/missing.txt"} {
+		meta, err := st.FileMeta(context.Background(), key)
+		if err != nil {
+			t.Fatalf("meta of %q: %v", key, err)
+		}
+		if meta != nil {
+			t.Fatalf("meta of %q should be nil, got %+v", key, meta)
+		}
+	}
+}
+
+func testList(t *testing.T, st store.Store) {
+	Upload(t, st, "list/a.txt", "a")
+	Upload(t, st, "list/b.txt", "bb")
+	Upload(t, st, "list/sub/c.txt", "ccc")
+	Upload(t, st, "other.txt", "o")
+
+	assertList(t, st, "list", "a.txt:1", "b.txt:2", "sub/")
+	assertList(t, st, "list/", "a.txt:1", "b.txt:2", "sub/")
+	assertList(t, st, "/list", "a.txt:1", "b.txt:2", "sub/")
+	assertList(t, st, "", "list/", "other.txt:1")
+	assertList(t, st, "/", "list/", "other.txt:1")
+	assertList(t, st, "missing")
+}
+
+func testListNestedDirs(t *testing.T, st store.Store) {
+	Upload(t, st, "x/y/z/deep.txt", "deep")
+
+	assertList(t, st, "x", "y/")
+	assertList(t, st, "x/y", "z/")
+	assertList(t, st, "x/y/z", "deep.txt:4")
+}
+
+func testDelete(t *testing.T, st store.Store) {
+	Upload(t, st, "del/keep.txt", "keep")
+	Upload(t, st, "del/sub/gone.txt", "gone")
+
+	if err := st.DeleteFile(context.Background(), "del/sub/gone.txt"); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := st.FileMeta(context.Background(), "del/sub/gone.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta != nil {
+		t.Fatalf("deleted file still exists: %+v", meta)
+	}
+	assertList(t, st, "del", "keep.txt:4")
+
+	// deleting twice is not an error
+	if err := st.DeleteFile(context.Background(), "del/sub/gone.txt"); err != nil {
+		t.Fatalf("delete a missing file: %v", err)
+	}
+}
+
+func testNotExist(t *testing.T, st store.Store) {
+	err := st.DownloadFile(context.Background(), bytes.NewBuffer(nil), "missing/file.txt")
+	if !errors.Is(err, store.ErrNotExist) {
+		t.Fatalf("download a missing file should return ErrNotExist, got %v", err)
+	}
+}
+
+// plainStore hides the optional interfaces of the wrapped store
+type plainStore struct {
+	store.Store
+}
+
+func testDownloadRange(t *testing.T, st store.Store) {
+	content := strings.Repeat("0123456789", 10000)
+	Upload(t, st, "range.txt", content)
+
+	cases := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 10, content[:10]},
+		{5, 20, content[5:25]},
+		{99990, -1, content[99990:]},
+		{12345, 54321, content[12345 : 12345+54321]},
+		{99995, 100, content[99995:]},
+		{0, -1, content},
+		{100000, -1, ""},
+	}
+	for _, s := range []store.Store{st, plainStore{st}} {
+		for _, c := range cases {
+			buffer := bytes.NewBuffer(nil)
+			if err := store.DownloadRange(context.Background(), s, buffer, "range.txt", c.offset, c.length); err != nil {
+				t.Fatalf("download range %d+%d: %v", c.offset, c.length, err)
+			}
+			if buffer.String() != c.want {
+				t.Fatalf("download range %d+%d got %d bytes, want %d bytes", c.offset, c.length, buffer.Len(), len(c.want))
+			}
+		}
+	}
+}
+
+// assertList checks the entries of dir, files are formatted as "name:size" and directories as "name/"
+func assertList(t *testing.T, st store.Store, dir string, want ...string) {
+	t.Helper()
+	metas, err := st.List(context.Background(), dir)
+	if err != nil {
+		t.Fatalf("list %q: %v", dir, err)
+	}
+
+	got := make([]string, 0, len(metas))
+	for _, meta := range metas {
+		if meta.IsDir {
+			got = append(got, meta.Name+"/")
+		} else {
+			got = append(got, meta.Name+":"+strconv.FormatInt(meta.Size, 10))
+		}
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("list %q got [%s], want [%s]", dir, strings.Join(got, ","), strings.Join(want, ","))
+	}
+}
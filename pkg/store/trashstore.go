@@ -0,0 +1,257 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var _ Store = (*TrashStore)(nil)
+var _ RangeDownloader = (*TrashStore)(nil)
+
+const (
+	trashDir        = ".trash"
+	trashFilesDir   = trashDir + "/files"
+	trashRecordsDir = trashDir + "/records"
+	trashMetaDir    = trashDir + "/meta"
+)
+
+// ErrTrashNotExist is returned (wrapped) when the trash entry does not exist
+var ErrTrashNotExist = errors.New("trash entry does not exist")
+
+// TrashEntry is a deleted file in the trash
+type TrashEntry struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// TrashStore moves deleted files to the .trash area instead of removing them, they can be
+// restored until the trash is emptied or purged. The metadata sidecar of the MetaStore above is
+// moved with the file, so that a restored file keeps its metadata.
+type TrashStore struct {
+	inner Store
+}
+
+func NewTrashStore(inner Store) *TrashStore {
+	return &TrashStore{inner: inner}
+}
+
+func (t *TrashStore) Unwrap() Store {
+	return t.inner
+}
+
+func (t *TrashStore) UploadFile(ctx context.Context, reader io.Reader, filePath string) error {
+	return t.inner.UploadFile(ctx, reader, filePath)
+}
+
+// DeleteFile moves the file to the trash, files already in the trash or other internal files are deleted permanently
+func (t *TrashStore) DeleteFile(ctx context.Context, filePath string) error {
+	key := cleanKey(filePath)
+	if IsInternalKey(key) {
+		return t.inner.DeleteFile(ctx, key)
+	}
+
+	meta, err := t.inner.FileMeta(ctx, key)
+	if err != nil || meta == nil {
+		return err
+	}
+
+	entry := &TrashEntry{
+		ID:        newTrashID(),
+		Path:      key,
+		Size:      meta.Size,
+		DeletedAt: time.Now(),
+	}
+	if err := Copy(ctx, t.inner, key, trashFileKey(entry.ID)); err != nil {
+		return fmt.Errorf("failed to move %s to trash: %w", key, err)
+	}
+	if err := t.copyIfExist(ctx, metaKey(key), trashMetaKey(entry.ID)); err != nil {
+		return fmt.Errorf("failed to move the metadata of %s to trash: %w", key, err)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := t.inner.UploadFile(ctx, bytes.NewReader(data), trashRecordKey(entry.ID)); err != nil {
+		return fmt.Errorf("failed to record %s in trash: %w", key, err)
+	}
+	return t.inner.DeleteFile(ctx, key)
+}
+
+func (t *TrashStore) FileMeta(ctx context.Context, file string) (*FileMeta, error) {
+	return t.inner.FileMeta(ctx, file)
+}
+
+func (t *TrashStore) List(ctx context.Context, dir string) ([]*FileMeta, error) {
+	metas, err := t.inner.List(ctx, dir)
+	if err != nil || cleanKey(dir) != "" {
+		return metas, err
+	}
+	return hideEntry(metas, trashDir), nil
+}
+
+func (t *TrashStore) DownloadFile(ctx context.Context, writer io.Writer, key string) error {
+	return t.inner.DownloadFile(ctx, writer, key)
+}
+
+func (t *TrashStore) DownloadRange(ctx context.Context, writer io.Writer, key string, offset, length int64) error {
+	return DownloadRange(ctx, t.inner, writer, key, offset, length)
+}
+
+// ListTrash returns the entries of the trash, the latest deleted first
+func (t *TrashStore) ListTrash(ctx context.Context) ([]*TrashEntry, error) {
+	metas, err := t.inner.List(ctx, trashRecordsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*TrashEntry
+	for _, meta := range metas {
+		if meta.IsDir {
+			continue
+		}
+		entry, err := t.readEntry(ctx, strings.TrimSuffix(meta.Name, ".json"))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// RestoreTrash moves the entry back to its original path, it fails with ErrExist if the path is taken
+func (t *TrashStore) RestoreTrash(ctx context.Context, id string) (*TrashEntry, error) {
+	entry, err := t.readEntry(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := t.inner.FileMeta(ctx, entry.Path)
+	if err != nil {
+		return nil, err
+	}
+	if meta != nil {
+		return nil, fmt.Errorf("failed to restore %s: %w", entry.Path, ErrExist)
+	}
+
+	if err := Copy(ctx, t.inner, trashFileKey(id), entry.Path); err != nil {
+		return nil, fmt.Errorf("failed to restore %s: %w", entry.Path, err)
+	}
+	if err := t.copyIfExist(ctx, trashMetaKey(id), metaKey(entry.Path)); err != nil {
+		return nil, fmt.Errorf("failed to restore the metadata of %s: %w", entry.Path, err)
+	}
+	return entry, t.remove(ctx, id)
+}
+
+// DeleteTrash deletes the entry permanently
+func (t *TrashStore) DeleteTrash(ctx context.Context, id string) error {
+	if _, err := t.readEntry(ctx, id); err != nil {
+		return err
+	}
+	return t.remove(ctx, id)
+}
+
+// EmptyTrash deletes all entries permanently
+func (t *TrashStore) EmptyTrash(ctx context.Context) (int, error) {
+	return t.Purge(ctx, 0)
+}
+
+// Purge deletes the entries deleted longer than retention ago, the count of deleted entries is returned
+func (t *TrashStore) Purge(ctx context.Context, retention time.Duration) (int, error) {
+	entries, err := t.ListTrash(ctx)
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	for _, entry := range entries {
+		if time.Since(entry.DeletedAt) < retention {
+			continue
+		}
+		if err := t.remove(ctx, entry.ID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+func (t *TrashStore) readEntry(ctx context.Context, id string) (*TrashEntry, error) {
+	if !validTrashID(id) {
+		return nil, fmt.Errorf("trash entry %s: %w", id, ErrTrashNotExist)
+	}
+	buffer := bytes.NewBuffer(nil)
+	if err := t.inner.DownloadFile(ctx, buffer, trashRecordKey(id)); err != nil {
+		if errors.Is(err, ErrNotExist) {
+			return nil, fmt.Errorf("trash entry %s: %w", id, ErrTrashNotExist)
+		}
+		return nil, err
+	}
+	var entry TrashEntry
+	if err := json.Unmarshal(buffer.Bytes(), &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse trash entry %s: %w", id, err)
+	}
+	return &entry, nil
+}
+
+// copyIfExist copies src to dst, it does nothing if src does not exist
+func (t *TrashStore) copyIfExist(ctx context.Context, src, dst string) error {
+	meta, err := t.inner.FileMeta(ctx, src)
+	if err != nil || meta == nil {
+		return err
+	}
+	return Copy(ctx, t.inner, src, dst)
+}
+
+func (t *TrashStore) remove(ctx context.Context, id string) error {
+	if err := t.inner.DeleteFile(ctx, trashFileKey(id)); err != nil {
+		return err
+	}
+	if err := t.inner.DeleteFile(ctx, trashMetaKey(id)); err != nil {
+		return err
+	}
+	return t.inner.DeleteFile(ctx, trashRecordKey(id))
+}
+
+// newTrashID returns a sortable unique id like 20241024010203456-1a2b3c4d
+func newTrashID() string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return strings.Replace(time.Now().UTC().Format("20060102150405.000"), ".", "", 1) + "-" + hex.EncodeToString(suffix)
+}
+
+func validTrashID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func trashFileKey(id string) string {
+	return path.Join(trashFilesDir, id)
+}
+
+func trashMetaKey(id string) string {
+	return path.Join(trashMetaDir, id+".json")
+}
+
+func trashRecordKey(id string) string {
+	return path.Join(trashRecordsDir, id+".json")
+}
@@ -0,0 +1,128 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/store/storetest"
+)
+
+func TestTrashStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return store.NewTrashStore(store.NewMemStore())
+	})
+}
+
+func TestTrashStoreRestore(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewTrashStore(store.NewMemStore())
+
+	storetest.Upload(t, st, "docs/a.txt", "first")
+	if err := st.DeleteFile(ctx, "docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := st.ListTrash(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Path != "docs/a.txt" || entries[0].Size != 5 {
+		t.Fatalf("unexpected trash entries: %+v", entries)
+	}
+
+	// the trash is hidden from the listing
+	metas, err := st.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 0 {
+		t.Fatalf("unexpected root entries: %+v", metas)
+	}
+
+	// restoring onto an existing file conflicts
+	storetest.Upload(t, st, "docs/a.txt", "second")
+	if _, err := st.RestoreTrash(ctx, entries[0].ID); !errors.Is(err, store.ErrExist) {
+		t.Fatalf("expected ErrExist, got %v", err)
+	}
+	if err := st.DeleteFile(ctx, "docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := st.RestoreTrash(ctx, entries[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := storetest.Download(t, st, "docs/a.txt"); got != "first" {
+		t.Fatalf("got %q after restore, want first", got)
+	}
+	if _, err := st.RestoreTrash(ctx, entries[0].ID); !errors.Is(err, store.ErrTrashNotExist) {
+		t.Fatalf("expected ErrTrashNotExist, got %v", err)
+	}
+}
+
+func TestTrashStoreRestoreMetadata(t *testing.T) {
+	ctx := context.Background()
+	trash := store.NewTrashStore(store.NewMemStore())
+	st := store.NewMetaStore(trash)
+
+	metadata := map[string]string{store.MetaSHA256: "abc", "owner": "alice"}
+	if err := st.UploadFileWithMetadata(ctx, strings.NewReader("a"), "docs/a.txt", metadata); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.DeleteFile(ctx, "docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := trash.ListTrash(ctx)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("unexpected trash entries: %+v, %v", entries, err)
+	}
+	if _, err := trash.RestoreTrash(ctx, entries[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := st.FileMeta(ctx, "docs/a.txt")
+	if err != nil || meta == nil {
+		t.Fatalf("unexpected meta %+v, %v", meta, err)
+	}
+	if !reflect.DeepEqual(meta.Metadata, metadata) {
+		t.Fatalf("got metadata %v after restore, want %v", meta.Metadata, metadata)
+	}
+}
+
+func TestTrashStorePurge(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewTrashStore(store.NewMemStore())
+
+	storetest.Upload(t, st, "a.txt", "a")
+	storetest.Upload(t, st, "b.txt", "b")
+	for _, key := range []string{"a.txt", "b.txt"} {
+		if err := st.DeleteFile(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	purged, err := st.Purge(ctx, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 0 {
+		t.Fatalf("purged %d fresh entries", purged)
+	}
+
+	purged, err = st.EmptyTrash(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 2 {
+		t.Fatalf("purged %d entries, want 2", purged)
+	}
+	entries, err := st.ListTrash(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("unexpected entries after emptying: %+v", entries)
+	}
+}
@@ -0,0 +1,338 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var _ Store = (*VersionStore)(nil)
+var _ RangeDownloader = (*VersionStore)(nil)
+
+// ErrVersionNotExist is returned (wrapped) when the requested version of a file does not exist
+var ErrVersionNotExist = errors.New("version does not exist")
+
+const (
+	versionDir = ".versions"
+	// versionIDLayout sorts by time, the dot of the fraction is removed
+	versionIDLayout = "20060102150405.000000000"
+)
+
+// FileVersion is one version in the history of a file
+type FileVersion struct {
+	ID       string    `json:"id"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	IsLatest bool      `json:"isLatest"`
+}
+
+// VersionedStore is implemented by stores which can keep the history of files natively
+type VersionedStore interface {
+	// VersioningEnabled reports whether the history is actually kept, e.g. if the bucket has versioning enabled
+	VersioningEnabled(ctx context.Context) (bool, error)
+
+	// ListVersions returns all versions of key, the newest first
+	ListVersions(ctx context.Context, key string) ([]*FileVersion, error)
+
+	DownloadVersion(ctx context.Context, writer io.Writer, key, versionID string) error
+
+	// RestoreVersion makes a copy of the version the latest version of key
+	RestoreVersion(ctx context.Context, key, versionID string) error
+
+	DeleteVersion(ctx context.Context, key, versionID string) error
+}
+
+var errVersioningUnsupported = errors.New("versioning is not supported by the store")
+
+// versionedInner returns the inner store of a decorator as VersionedStore
+func versionedInner(inner Store) (VersionedStore, error) {
+	v, ok := inner.(VersionedStore)
+	if !ok {
+		return nil, errVersioningUnsupported
+	}
+	return v, nil
+}
+
+// VersionStore keeps the history of every file. It uses the native versioning of the inner store if
+// it is enabled, otherwise the replaced and deleted files are copied to the .versions sidecar tree.
+// Old versions are removed when there are more than maxVersions of a file or they are older than maxAge.
+type VersionStore struct {
+	inner    Store
+	versions VersionedStore
+
+	maxVersions int
+	maxAge      time.Duration
+}
+
+// NewVersionStore wraps inner, maxVersions and maxAge are ignored if not positive
+func NewVersionStore(ctx context.Context, inner Store, maxVersions int, maxAge time.Duration) (*VersionStore, error) {
+	v := &VersionStore{
+		inner:       inner,
+		maxVersions: maxVersions,
+		maxAge:      maxAge,
+	}
+
+	if native, ok := inner.(VersionedStore); ok {
+		enabled, err := native.VersioningEnabled(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check versioning: %w", err)
+		}
+		if enabled {
+			v.versions = native
+			return v, nil
+		}
+	}
+	v.versions = &sidecarVersions{inner: inner}
+	return v, nil
+}
+
+func (v *VersionStore) Unwrap() Store {
+	return v.inner
+}
+
+// Native reports whether the native versioning of the inner store is used
+func (v *VersionStore) Native() bool {
+	_, sidecar := v.versions.(*sidecarVersions)
+	return !sidecar
+}
+
+func (v *VersionStore) UploadFile(ctx context.Context, reader io.Reader, filePath string) error {
+	if IsInternalKey(cleanKey(filePath)) {
+		return v.inner.UploadFile(ctx, reader, filePath)
+	}
+	if sidecar, ok := v.versions.(*sidecarVersions); ok {
+		if err := sidecar.archive(ctx, filePath); err != nil {
+			return err
+		}
+	}
+	if err := v.inner.UploadFile(ctx, reader, filePath); err != nil {
+		return err
+	}
+	return v.Prune(ctx, filePath)
+}
+
+func (v *VersionStore) DeleteFile(ctx context.Context, filePath string) error {
+	if IsInternalKey(cleanKey(filePath)) {
+		return v.inner.DeleteFile(ctx, filePath)
+	}
+	if sidecar, ok := v.versions.(*sidecarVersions); ok {
+		if err := sidecar.archive(ctx, filePath); err != nil {
+			return err
+		}
+	}
+	if err := v.inner.DeleteFile(ctx, filePath); err != nil {
+		return err
+	}
+	return v.Prune(ctx, filePath)
+}
+
+func (v *VersionStore) FileMeta(ctx context.Context, file string) (*FileMeta, error) {
+	return v.inner.FileMeta(ctx, file)
+}
+
+func (v *VersionStore) List(ctx context.Context, dir string) ([]*FileMeta, error) {
+	metas, err := v.inner.List(ctx, dir)
+	if err != nil || cleanKey(dir) != "" {
+		return metas, err
+	}
+	return hideEntry(metas, versionDir), nil
+}
+
+func (v *VersionStore) DownloadFile(ctx context.Context, writer io.Writer, key string) error {
+	return v.inner.DownloadFile(ctx, writer, key)
+}
+
+func (v *VersionStore) DownloadRange(ctx context.Context, writer io.Writer, key string, offset, length int64) error {
+	return DownloadRange(ctx, v.inner, writer, key, offset, length)
+}
+
+// ListVersions returns the versions of key, the newest first
+func (v *VersionStore) ListVersions(ctx context.Context, key string) ([]*FileVersion, error) {
+	return v.versions.ListVersions(ctx, key)
+}
+
+func (v *VersionStore) DownloadVersion(ctx context.Context, writer io.Writer, key, versionID string) error {
+	return v.versions.DownloadVersion(ctx, writer, key, versionID)
+}
+
+// RestoreVersion makes the version the latest version of key, the replaced content is kept as a version
+func (v *VersionStore) RestoreVersion(ctx context.Context, key, versionID string) error {
+	if sidecar, ok := v.versions.(*sidecarVersions); ok {
+		if err := sidecar.archive(ctx, key); err != nil {
+			return err
+		}
+	}
+	if err := v.versions.RestoreVersion(ctx, key, versionID); err != nil {
+		return err
+	}
+	return v.Prune(ctx, key)
+}
+
+// Prune removes the old versions of key exceeding the retention limits, the latest version is always kept
+func (v *VersionStore) Prune(ctx context.Context, key string) error {
+	if v.maxVersions <= 0 && v.maxAge <= 0 {
+		return nil
+	}
+	versions, err := v.versions.ListVersions(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	kept := 0
+	for _, version := range versions {
+		if version.IsLatest {
+			continue
+		}
+		kept++
+		tooMany := v.maxVersions > 0 && kept > v.maxVersions
+		tooOld := v.maxAge > 0 && time.Since(version.ModTime) > v.maxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := v.versions.DeleteVersion(ctx, key, version.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sidecarVersions keeps the versions of key in .versions/key/<version id>
+type sidecarVersions struct {
+	inner Store
+}
+
+func (s *sidecarVersions) VersioningEnabled(ctx context.Context) (bool, error) {
+	return true, nil
+}
+
+// archive copies the current content of key to a new version, the version keeps the ID ListVersions
+// reported for the latest version, so the ID stays valid once it is overwritten
+func (s *sidecarVersions) archive(ctx context.Context, key string) error {
+	meta, err := s.inner.FileMeta(ctx, key)
+	if err != nil || meta == nil {
+		return err
+	}
+
+	modTime := meta.ModTime.UTC()
+	versionKey := s.versionKey(key, formatVersionID(modTime))
+	for {
+		exist, err := s.inner.FileMeta(ctx, versionKey)
+		if err != nil {
+			return err
+		}
+		if exist == nil {
+			break
+		}
+		// the stores of a coarse mod time give two writes of the same second the same ID
+		modTime = modTime.Add(time.Nanosecond)
+		versionKey = s.versionKey(key, formatVersionID(modTime))
+	}
+	return Copy(ctx, s.inner, key, versionKey)
+}
+
+func (s *sidecarVersions) ListVersions(ctx context.Context, key string) ([]*FileVersion, error) {
+	var versions []*FileVersion
+	meta, err := s.inner.FileMeta(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if meta != nil {
+		versions = append(versions, &FileVersion{
+			ID:       formatVersionID(meta.ModTime.UTC()),
+			Size:     meta.Size,
+			ModTime:  meta.ModTime,
+			IsLatest: true,
+		})
+	}
+
+	metas, err := s.inner.List(ctx, s.versionKey(key, ""))
+	if err != nil {
+		return nil, err
+	}
+	var history []*FileVersion
+	for _, m := range metas {
+		if m.IsDir {
+			continue
+		}
+		archived, err := parseVersionID(m.Name)
+		if err != nil {
+			continue
+		}
+		history = append(history, &FileVersion{
+			ID:      m.Name,
+			Size:    m.Size,
+			ModTime: archived,
+		})
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].ID > history[j].ID
+	})
+	return append(versions, history...), nil
+}
+
+func (s *sidecarVersions) DownloadVersion(ctx context.Context, writer io.Writer, key, versionID string) error {
+	if latest, err := s.isLatest(ctx, key, versionID); err != nil || latest {
+		if err != nil {
+			return err
+		}
+		return s.inner.DownloadFile(ctx, writer, key)
+	}
+	if _, err := parseVersionID(versionID); err != nil {
+		return fmt.Errorf("version %s of %s: %w", versionID, key, ErrVersionNotExist)
+	}
+	err := s.inner.DownloadFile(ctx, writer, s.versionKey(key, versionID))
+	if errors.Is(err, ErrNotExist) {
+		return fmt.Errorf("version %s of %s: %w", versionID, key, ErrVersionNotExist)
+	}
+	return err
+}
+
+func (s *sidecarVersions) RestoreVersion(ctx context.Context, key, versionID string) error {
+	if _, err := parseVersionID(versionID); err != nil {
+		return fmt.Errorf("version %s of %s: %w", versionID, key, ErrVersionNotExist)
+	}
+	versionKey := s.versionKey(key, versionID)
+	meta, err := s.inner.FileMeta(ctx, versionKey)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return fmt.Errorf("version %s of %s: %w", versionID, key, ErrVersionNotExist)
+	}
+	return Copy(ctx, s.inner, versionKey, key)
+}
+
+func (s *sidecarVersions) DeleteVersion(ctx context.Context, key, versionID string) error {
+	if _, err := parseVersionID(versionID); err != nil {
+		return fmt.Errorf("version %s of %s: %w", versionID, key, ErrVersionNotExist)
+	}
+	return s.inner.DeleteFile(ctx, s.versionKey(key, versionID))
+}
+
+func (s *sidecarVersions) isLatest(ctx context.Context, key, versionID string) (bool, error) {
+	meta, err := s.inner.FileMeta(ctx, key)
+	if err != nil || meta == nil {
+		return false, err
+	}
+	return formatVersionID(meta.ModTime.UTC()) == versionID, nil
+}
+
+func (s *sidecarVersions) versionKey(key, versionID string) string {
+	return path.Join(versionDir, cleanKey(key), versionID)
+}
+
+func formatVersionID(t time.Time) string {
+	return strings.Replace(t.Format(versionIDLayout), ".", "", 1)
+}
+
+func parseVersionID(id string) (time.Time, error) {
+	if len(id) != len(versionIDLayout)-1 {
+		return time.Time{}, errors.New("invalid version id")
+	}
+	return time.Parse(versionIDLayout, id[:14]+"."+id[14:])
+}
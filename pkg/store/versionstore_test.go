@@ -0,0 +1,135 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/graydovee/fileManager/pkg/store"
+	"github.com/graydovee/fileManager/pkg/store/storetest"
+)
+
+func TestVersionStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		st, err := store.NewVersionStore(context.Background(), store.NewMemStore(), 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	})
+}
+
+func TestVersionStoreHistory(t *testing.T) {
+	ctx := context.Background()
+	inner := store.NewLocalStore(&config.LocalStoreConfig{UploadDir: t.TempDir()})
+	st, err := store.NewVersionStore(ctx, inner, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Native() {
+		t.Fatal("local store has no native versioning")
+	}
+
+	storetest.Upload(t, st, "latest/agent", "v1")
+	storetest.Upload(t, st, "latest/agent", "v2")
+	storetest.Upload(t, st, "latest/agent", "v3")
+
+	versions, err := st.ListVersions(ctx, "latest/agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 || !versions[0].IsLatest {
+		t.Fatalf("unexpected versions: %+v", versions)
+	}
+	if got := downloadVersion(t, st, "latest/agent", versions[2].ID); got != "v1" {
+		t.Fatalf("oldest version is %q, want v1", got)
+	}
+	if got := downloadVersion(t, st, "latest/agent", versions[0].ID); got != "v3" {
+		t.Fatalf("latest version is %q, want v3", got)
+	}
+
+	// restore keeps the replaced content and the retention limit drops the oldest
+	if err := st.RestoreVersion(ctx, "latest/agent", versions[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := storetest.Download(t, st, "latest/agent"); got != "v1" {
+		t.Fatalf("got %q after restore, want v1", got)
+	}
+	versions, err = st.ListVersions(ctx, "latest/agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("got %d versions, want latest and 2 old versions", len(versions))
+	}
+	if got := downloadVersion(t, st, "latest/agent", versions[1].ID); got != "v3" {
+		t.Fatalf("newest old version is %q, want v3", got)
+	}
+
+	// deleted files can be restored
+	if err := st.DeleteFile(ctx, "latest/agent"); err != nil {
+		t.Fatal(err)
+	}
+	versions, err = st.ListVersions(ctx, "latest/agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) == 0 || versions[0].IsLatest {
+		t.Fatalf("unexpected versions after delete: %+v", versions)
+	}
+	if err := st.RestoreVersion(ctx, "latest/agent", versions[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := storetest.Download(t, st, "latest/agent"); got != "v1" {
+		t.Fatalf("got %q after restoring a deleted file, want v1", got)
+	}
+
+	// the sidecar tree is hidden
+	metas, err := st.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metas) != 1 || metas[0].Name != "latest" {
+		t.Fatalf("unexpected root entries: %+v", metas)
+	}
+
+	err = st.DownloadVersion(ctx, bytes.NewBuffer(nil), "latest/agent", "20000101000000000000000")
+	if !errors.Is(err, store.ErrVersionNotExist) {
+		t.Fatalf("expected ErrVersionNotExist, got %v", err)
+	}
+}
+
+func TestVersionStoreLatestID(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.NewVersionStore(ctx, store.NewMemStore(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storetest.Upload(t, st, "a.txt", "v1")
+	versions, err := st.ListVersions(ctx, "a.txt")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("unexpected versions %+v, %v", versions, err)
+	}
+	latest := versions[0].ID
+
+	// the ID of the latest version still points to it once it is overwritten
+	storetest.Upload(t, st, "a.txt", "v2")
+	if got := downloadVersion(t, st, "a.txt", latest); got != "v1" {
+		t.Fatalf("version %s is %q, want v1", latest, got)
+	}
+	versions, err = st.ListVersions(ctx, "a.txt")
+	if err != nil || len(versions) != 2 || versions[1].ID != latest {
+		t.Fatalf("unexpected versions %+v, %v", versions, err)
+	}
+}
+
+func downloadVersion(t *testing.T, st *store.VersionStore, key, versionID string) string {
+	t.Helper()
+	buffer := bytes.NewBuffer(nil)
+	if err := st.DownloadVersion(context.Background(), buffer, key, versionID); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.String()
+}
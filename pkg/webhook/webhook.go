@@ -0,0 +1,281 @@
+// Package webhook posts the events of the file and code servers to the configured URLs.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/config"
+)
+
+// The event types
+const (
+	EventUpload      = "file.upload"
+	EventDelete      = "file.delete"
+	EventPasteCreate = "paste.create"
+	// EventShareAccess is posted when a shared link is opened, the download of a file or the page, the raw code
+	// or the zip of a paste
+	EventShareAccess = "share.access"
+)
+
+const (
+	// SignatureHeader carries sha256=<hex HMAC-SHA256 of the body>, keyed with the secret
+	SignatureHeader = "X-Webhook-Signature"
+	EventHeader     = "X-Webhook-Event"
+	IDHeader        = "X-Webhook-Id"
+)
+
+const (
+	deliveryTimeout = 10 * time.Second
+	pollInterval    = time.Second
+	minBackoff      = time.Second
+	maxBackoff      = time.Hour
+)
+
+// Actor is who caused an event, the token is the owner fingerprint of the token, never the token itself
+type Actor struct {
+	Token string `json:"token,omitempty"`
+	IP    string `json:"ip,omitempty"`
+}
+
+// Event is the JSON payload of a webhook
+type Event struct {
+	ID          string    `json:"id"`
+	Type        string    `json:"type"`
+	Time        time.Time `json:"time"`
+	Key         string    `json:"key"`
+	Size        int64     `json:"size,omitempty"`
+	SHA256      string    `json:"sha256,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	DownloadURL string    `json:"downloadUrl,omitempty"`
+	Actor       Actor     `json:"actor"`
+}
+
+// delivery is an event queued for one URL
+type delivery struct {
+	URL         string    `json:"url"`
+	Event       *Event    `json:"event"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"nextAttempt"`
+}
+
+// Dispatcher delivers the events to the webhooks. Every delivery is written to the queue directory before it is
+// posted and retried with an exponential backoff until it succeeds or runs out of attempts, so it survives restarts.
+// A nil Dispatcher drops all events.
+type Dispatcher struct {
+	cfg    *config.WebhookConfig
+	client *http.Client
+	wake   chan struct{}
+}
+
+// NewDispatcher returns nil if no webhook URL is configured
+func NewDispatcher(cfg *config.WebhookConfig) (*Dispatcher, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, nil
+	}
+	if err := os.MkdirAll(cfg.QueueDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the webhook queue: %w", err)
+	}
+	return &Dispatcher{
+		cfg:    cfg,
+		client: &http.Client{Timeout: deliveryTimeout},
+		wake:   make(chan struct{}, 1),
+	}, nil
+}
+
+// Notify queues the event for every webhook if it passes the filters
+func (d *Dispatcher) Notify(event *Event) {
+	if d == nil || !d.match(event) {
+		return
+	}
+	if event.ID == "" {
+		event.ID = newID()
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	for i, url := range d.cfg.URLs {
+		name := fmt.Sprintf("%s-%d.json", event.ID, i)
+		if err := d.save(name, &delivery{URL: url, Event: event, NextAttempt: event.Time}); err != nil {
+			log.Printf("Error queueing the webhook event %s: %s\n", event.ID, err.Error())
+		}
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) match(event *Event) bool {
+	if len(d.cfg.Events) > 0 && !slices.Contains(d.cfg.Events, event.Type) {
+		return false
+	}
+	if len(d.cfg.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range d.cfg.Prefixes {
+		prefix = strings.Trim(prefix, "/")
+		if prefix == "" || event.Key == prefix || strings.HasPrefix(event.Key, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Run delivers the queued events until ctx is done
+func (d *Dispatcher) Run(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		d.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// deliverDue posts the deliveries whose next attempt is due
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	entries, err := os.ReadDir(d.cfg.QueueDir)
+	if err != nil {
+		log.Printf("Error reading the webhook queue: %s\n", err.Error())
+		return
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return
+		}
+		name := entry.Name()
+		if entry.IsDir() || path.Ext(name) != ".json" {
+			continue
+		}
+		dl, err := d.load(name)
+		if err != nil {
+			log.Printf("Error reading the webhook delivery %s: %s\n", name, err.Error())
+			continue
+		}
+		if dl.NextAttempt.After(now) {
+			continue
+		}
+
+		err = d.post(ctx, dl)
+		if err == nil {
+			d.remove(name)
+			continue
+		}
+		dl.Attempts++
+		if dl.Attempts >= d.cfg.MaxAttempts {
+			log.Printf("Dropping the webhook event %s to %s after %d attempts: %s\n", dl.Event.ID, dl.URL, dl.Attempts, err.Error())
+			d.remove(name)
+			continue
+		}
+		dl.NextAttempt = time.Now().Add(backoff(dl.Attempts))
+		if err := d.save(name, dl); err != nil {
+			log.Printf("Error updating the webhook delivery %s: %s\n", name, err.Error())
+		}
+	}
+}
+
+func (d *Dispatcher) post(ctx context.Context, dl *delivery) error {
+	body, err := json.Marshal(dl.Event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, dl.Event.Type)
+	req.Header.Set(IDHeader, dl.Event.ID)
+	if d.cfg.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(d.cfg.Secret, body))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the signature header of body, receivers compare it with hmac.Equal
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// backoff doubles the delay after every failed attempt
+func backoff(attempts int) time.Duration {
+	delay := minBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+func (d *Dispatcher) load(name string) (*delivery, error) {
+	data, err := os.ReadFile(filepath.Join(d.cfg.QueueDir, name))
+	if err != nil {
+		return nil, err
+	}
+	dl := &delivery{}
+	if err := json.Unmarshal(data, dl); err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+// save writes the delivery through a temp file, so that a crash never leaves a partial delivery
+func (d *Dispatcher) save(name string, dl *delivery) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(d.cfg.QueueDir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(d.cfg.QueueDir, name))
+}
+
+func (d *Dispatcher) remove(name string) {
+	if err := os.Remove(filepath.Join(d.cfg.QueueDir, name)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing the webhook delivery %s: %s\n", name, err.Error())
+	}
+}
+
+// newID returns a random id which sorts by time
+func newID() string {
+	random := make([]byte, 4)
+	_, _ = rand.Read(random)
+	return fmt.Sprintf("%d-%s", time.Now().UnixNano(), hex.EncodeToString(random))
+}
@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/graydovee/fileManager/pkg/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatcher(t *testing.T) {
+	var (
+		fail     = true
+		received []*Event
+	)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, Sign("secret", body), r.Header.Get(SignatureHeader))
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		event := &Event{}
+		assert.NoError(t, json.Unmarshal(body, event))
+		assert.Equal(t, event.Type, r.Header.Get(EventHeader))
+		received = append(received, event)
+	}))
+	defer hook.Close()
+
+	cfg := &config.WebhookConfig{
+		URLs:        []string{hook.URL},
+		Secret:      "secret",
+		Events:      []string{EventUpload, EventDelete},
+		Prefixes:    []string{"releases/"},
+		QueueDir:    t.TempDir(),
+		MaxAttempts: 3,
+	}
+	d, err := NewDispatcher(cfg)
+	assert.NoError(t, err)
+
+	// the events are filtered by type and path
+	d.Notify(&Event{Type: EventUpload, Key: "releases/v1/app.tar.gz", Size: 42})
+	d.Notify(&Event{Type: EventUpload, Key: "releases-old/app.tar.gz"})
+	d.Notify(&Event{Type: EventPasteCreate, Key: "releases/note.txt"})
+	queued := func() int {
+		entries, err := os.ReadDir(cfg.QueueDir)
+		assert.NoError(t, err)
+		return len(entries)
+	}
+	assert.Equal(t, 1, queued())
+
+	// a failed delivery is retried after the backoff, also by a new dispatcher
+	ctx := context.Background()
+	d.deliverDue(ctx)
+	assert.Equal(t, 1, queued())
+	entries, _ := os.ReadDir(cfg.QueueDir)
+	dl, err := d.load(entries[0].Name())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dl.Attempts)
+	assert.True(t, dl.NextAttempt.After(time.Now()))
+
+	fail = false
+	d, err = NewDispatcher(cfg)
+	assert.NoError(t, err)
+	d.deliverDue(ctx)
+	assert.Empty(t, received)
+
+	dl.NextAttempt = time.Now()
+	assert.NoError(t, d.save(entries[0].Name(), dl))
+	d.deliverDue(ctx)
+	assert.Equal(t, 0, queued())
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, "releases/v1/app.tar.gz", received[0].Key)
+		assert.Equal(t, int64(42), received[0].Size)
+		assert.NotEmpty(t, received[0].ID)
+	}
+
+	// a delivery is dropped after the max attempts
+	fail = true
+	d.Notify(&Event{Type: EventDelete, Key: "releases/v1/app.tar.gz"})
+	for i := 0; i < cfg.MaxAttempts; i++ {
+		entries, _ := os.ReadDir(cfg.QueueDir)
+		dl, err := d.load(entries[0].Name())
+		assert.NoError(t, err)
+		dl.NextAttempt = time.Now()
+		assert.NoError(t, d.save(entries[0].Name(), dl))
+		d.deliverDue(ctx)
+	}
+	assert.Equal(t, 0, queued())
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Second, backoff(1))
+	assert.Equal(t, 8*time.Second, backoff(4))
+	assert.Equal(t, time.Hour, backoff(30))
+}
+
+func TestNilDispatcher(t *testing.T) {
+	d, err := NewDispatcher(&config.WebhookConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, d)
+	d.Notify(&Event{Type: EventUpload})
+}